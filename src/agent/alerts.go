@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// alertRule fires when Metric reaches Trigger for Dwell consecutive ticks and
+// clears once it drops below Clear, which defaults to Trigger. Keeping Clear
+// under Trigger gives the rule hysteresis so a metric hovering at the
+// threshold doesn't flap.
+type alertRule struct {
+	Metric  string  `json:"metric"`
+	Trigger float64 `json:"trigger"`
+	Clear   float64 `json:"clear"`
+	Dwell   int     `json:"dwell"`
+}
+
+type alertState struct {
+	over   int
+	firing bool
+}
+
+type alerter struct {
+	mu    sync.Mutex
+	rules []alertRule
+	state map[string]map[int]*alertState // container ID -> rule index -> state
+}
+
+var alerts = &alerter{state: map[string]map[int]*alertState{}}
+
+// parseAlertRules reads rules from JSON such as
+// [{"metric":"CPU_PCT","trigger":80,"clear":70,"dwell":3}].
+func parseAlertRules(v string) ([]alertRule, error) {
+	// Clear is decoded separately to tell a clear of 0 from a missing one.
+	var raw []struct {
+		alertRule
+		Clear *float64 `json:"clear"`
+	}
+	if err := json.Unmarshal([]byte(v), &raw); err != nil {
+		return nil, err
+	}
+	rules := make([]alertRule, len(raw))
+	for i := range raw {
+		r := &rules[i]
+		*r = raw[i].alertRule
+		if r.Metric == "" {
+			return nil, fmt.Errorf("alert rule %d has no metric", i)
+		}
+		r.Clear = r.Trigger
+		if raw[i].Clear != nil && *raw[i].Clear < r.Trigger {
+			r.Clear = *raw[i].Clear
+		}
+		if r.Dwell < 1 {
+			r.Dwell = 1
+		}
+	}
+	return rules, nil
+}
+
+func (a *alerter) forget(id string) {
+	a.mu.Lock()
+	delete(a.state, id)
+	a.mu.Unlock()
+}
+
+func (a *alerter) size() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.state)
+}
+
+// evaluate runs every rule against one container's readings for this tick and
+// logs an event whenever an alert fires or clears.
+func (a *alerter) evaluate(id string, names []string, values map[string]float64) {
+	if len(a.rules) == 0 {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	states, ok := a.state[id]
+	if !ok {
+		states = map[int]*alertState{}
+		a.state[id] = states
+	}
+
+	// Several rules may watch the same metric at different thresholds.
+	for i, rule := range a.rules {
+		value, ok := values[rule.Metric]
+		if !ok {
+			continue
+		}
+		st, ok := states[i]
+		if !ok {
+			st = &alertState{}
+			states[i] = st
+		}
+
+		fields := logrus.Fields{
+			"ID":      id,
+			"Names":   names,
+			"Metric":  rule.Metric,
+			"Value":   value,
+			"Trigger": rule.Trigger,
+			"Clear":   rule.Clear,
+		}
+
+		if st.firing {
+			if value < rule.Clear {
+				st.firing, st.over = false, 0
+				fields["Alert"] = "clear"
+				logrus.WithFields(fields).Info("alert cleared")
+			}
+			continue
+		}
+
+		if value >= rule.Trigger {
+			st.over++
+		} else {
+			st.over = 0
+		}
+		if st.over >= rule.Dwell {
+			st.firing = true
+			fields["Alert"] = "fire"
+			fields["Dwell"] = rule.Dwell
+			logrus.WithFields(fields).Warn("alert fired")
+		}
+	}
+}
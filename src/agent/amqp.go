@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Enough of AMQP 0-9-1 to publish with publisher confirms: connection
+// negotiation with PLAIN auth, optionally over TLS, and one channel in
+// confirm mode.
+const (
+	amqpTimeout  = 30 * time.Second
+	amqpFrameMax = 131072
+
+	amqpFrameMethod    = 1
+	amqpFrameHeader    = 2
+	amqpFrameBody      = 3
+	amqpFrameHeartbeat = 8
+	amqpFrameEnd       = 0xce
+)
+
+// Class and method IDs of the methods used.
+const (
+	amqpConnection = 10
+	amqpChannel    = 20
+	amqpBasic      = 60
+	amqpConfirm    = 85
+)
+
+type amqpConfig struct {
+	address  string
+	user     string
+	password string
+	vhost    string
+	tls      *tls.Config
+}
+
+type amqpConn struct {
+	conn     net.Conn
+	r        *bufio.Reader
+	frameMax int
+	// tag is the delivery tag of the last message published; the broker
+	// numbers them from 1 once the channel is in confirm mode.
+	tag uint64
+}
+
+// amqpError is a connection or channel close of the broker, e.g. because the
+// exchange doesn't exist. Either way the connection is given up.
+type amqpError struct {
+	code int
+	text string
+}
+
+func (e *amqpError) Error() string { return fmt.Sprintf("amqp: %s (%d)", e.text, e.code) }
+
+// amqpMessage is a message to publish.
+type amqpMessage struct {
+	routingKey  string
+	contentType string
+	persistent  bool
+	body        []byte
+}
+
+func dialAMQP(cfg amqpConfig) (*amqpConn, error) {
+	var conn net.Conn
+	var err error
+	if cfg.tls != nil {
+		config := cfg.tls.Clone()
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(cfg.address)
+		}
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: amqpTimeout}, "tcp", cfg.address, config)
+	} else {
+		conn, err = net.DialTimeout("tcp", cfg.address, amqpTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &amqpConn{conn: conn, r: bufio.NewReader(conn), frameMax: amqpFrameMax}
+	conn.SetDeadline(time.Now().Add(amqpTimeout))
+	if err := c.open(cfg); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+func (c *amqpConn) open(cfg amqpConfig) error {
+	if _, err := c.conn.Write([]byte("AMQP\x00\x00\x09\x01")); err != nil {
+		return err
+	}
+
+	args, err := c.expect(amqpConnection, 10) // start
+	if err != nil {
+		return err
+	}
+	r := amqpReader{buf: args}
+	r.octet()
+	r.octet()
+	r.longstr() // server properties
+	if mechanisms := r.longstr(); r.err == nil && !strings.Contains(" "+mechanisms+" ", " PLAIN ") {
+		return fmt.Errorf("amqp: broker doesn't offer PLAIN authentication, only %s", mechanisms)
+	}
+	if r.err != nil {
+		return r.err
+	}
+	var w amqpWriter
+	w.table(map[string]interface{}{"product": "docker-stats", "capabilities": map[string]interface{}{"publisher_confirms": true}})
+	w.shortstr("PLAIN")
+	w.longstr("\x00" + cfg.user + "\x00" + cfg.password)
+	w.shortstr("en_US")
+	if err := c.method(0, amqpConnection, 11, w.buf); err != nil { // start-ok
+		return err
+	}
+
+	// A wrong password makes the broker close the socket instead of tuning.
+	if args, err = c.expect(amqpConnection, 30); err != nil { // tune
+		if err == io.EOF {
+			return errors.New("amqp: broker closed the connection, check the username and password")
+		}
+		return err
+	}
+	r = amqpReader{buf: args}
+	channelMax, frameMax := r.short(), int(r.long())
+	if r.err != nil {
+		return r.err
+	}
+	if frameMax != 0 && frameMax < c.frameMax {
+		c.frameMax = frameMax
+	}
+	w = amqpWriter{}
+	w.short(channelMax)
+	w.long(uint32(c.frameMax))
+	w.short(0)                                                     // no heartbeats, a dead connection shows on the next publish
+	if err := c.method(0, amqpConnection, 31, w.buf); err != nil { // tune-ok
+		return err
+	}
+
+	w = amqpWriter{}
+	w.shortstr(cfg.vhost)
+	w.shortstr("")
+	w.octet(0)
+	if err := c.method(0, amqpConnection, 40, w.buf); err != nil { // open
+		return err
+	}
+	if _, err := c.expect(amqpConnection, 41); err != nil {
+		return err
+	}
+
+	w = amqpWriter{}
+	w.shortstr("")
+	if err := c.method(1, amqpChannel, 10, w.buf); err != nil { // channel open
+		return err
+	}
+	if _, err := c.expect(amqpChannel, 11); err != nil {
+		return err
+	}
+	if err := c.method(1, amqpConfirm, 10, []byte{0}); err != nil { // select
+		return err
+	}
+	_, err = c.expect(amqpConfirm, 11)
+	return err
+}
+
+// publish sends the messages to the exchange and waits until the broker
+// confirmed every one of them.
+func (c *amqpConn) publish(exchange string, messages []amqpMessage) error {
+	c.conn.SetDeadline(time.Now().Add(amqpTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	var out []byte
+	pending := map[uint64]bool{}
+	for _, m := range messages {
+		var w amqpWriter
+		w.short(0)
+		w.shortstr(exchange)
+		w.shortstr(m.routingKey)
+		w.octet(0) // neither mandatory nor immediate
+		out = appendAMQPFrame(out, amqpFrameMethod, 1, append([]byte{0, amqpBasic, 0, 40}, w.buf...))
+
+		var h amqpWriter
+		h.short(amqpBasic)
+		h.short(0)
+		h.longlong(uint64(len(m.body)))
+		h.short(0x8000 | 0x1000 | 0x0040) // content-type, delivery-mode, timestamp
+		h.shortstr(m.contentType)
+		if m.persistent {
+			h.octet(2)
+		} else {
+			h.octet(1)
+		}
+		h.longlong(uint64(time.Now().Unix()))
+		out = appendAMQPFrame(out, amqpFrameHeader, 1, h.buf)
+		for body := m.body; len(body) > 0; {
+			n := len(body)
+			if n > c.frameMax-8 {
+				n = c.frameMax - 8
+			}
+			out = appendAMQPFrame(out, amqpFrameBody, 1, body[:n])
+			body = body[n:]
+		}
+
+		c.tag++
+		pending[c.tag] = true
+	}
+	if _, err := c.conn.Write(out); err != nil {
+		return err
+	}
+
+	nacked := 0
+	for len(pending) > 0 {
+		class, method, args, err := c.readMethod()
+		if err != nil {
+			return err
+		}
+		if class != amqpBasic || (method != 80 && method != 120) { // ack, nack
+			continue
+		}
+		r := amqpReader{buf: args}
+		tag, bits := r.longlong(), r.octet()
+		if r.err != nil {
+			return r.err
+		}
+		for t := range pending {
+			if t == tag || (bits&1 == 1 && t < tag) {
+				delete(pending, t)
+				if method == 120 {
+					nacked++
+				}
+			}
+		}
+	}
+	if nacked > 0 {
+		return fmt.Errorf("amqp: broker refused %d of %d messages", nacked, len(messages))
+	}
+	return nil
+}
+
+// expect reads the next method, which must be the given one.
+func (c *amqpConn) expect(class, method int) ([]byte, error) {
+	gotClass, gotMethod, args, err := c.readMethod()
+	if err != nil {
+		return nil, err
+	}
+	if gotClass != class || gotMethod != method {
+		return nil, fmt.Errorf("amqp: expected method %d.%d, got %d.%d", class, method, gotClass, gotMethod)
+	}
+	return args, nil
+}
+
+// readMethod returns the next method frame, skipping heartbeats. A close of
+// the broker is acknowledged and returned as an amqpError.
+func (c *amqpConn) readMethod() (int, int, []byte, error) {
+	for {
+		kind, channel, payload, err := c.readFrame()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		if kind == amqpFrameHeartbeat {
+			continue
+		}
+		if kind != amqpFrameMethod || len(payload) < 4 {
+			return 0, 0, nil, fmt.Errorf("amqp: unexpected frame type %d", kind)
+		}
+		class, method := int(binary.BigEndian.Uint16(payload)), int(binary.BigEndian.Uint16(payload[2:]))
+		args := payload[4:]
+		if class == amqpConnection && method == 50 || class == amqpChannel && method == 40 {
+			r := amqpReader{buf: args}
+			code, text := r.short(), r.shortstr()
+			c.method(channel, class, method+1, nil) // close-ok
+			return 0, 0, nil, &amqpError{code: int(code), text: text}
+		}
+		return class, method, args, nil
+	}
+}
+
+func (c *amqpConn) method(channel uint16, class, method int, args []byte) error {
+	payload := append([]byte{byte(class >> 8), byte(class), byte(method >> 8), byte(method)}, args...)
+	_, err := c.conn.Write(appendAMQPFrame(nil, amqpFrameMethod, channel, payload))
+	return err
+}
+
+func (c *amqpConn) readFrame() (byte, uint16, []byte, error) {
+	var header [7]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return 0, 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[3:])
+	if size > amqpFrameMax {
+		return 0, 0, nil, errors.New("amqp: frame too large")
+	}
+	payload := make([]byte, size+1)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, 0, nil, err
+	}
+	if payload[size] != amqpFrameEnd {
+		return 0, 0, nil, errors.New("amqp: malformed frame")
+	}
+	return header[0], binary.BigEndian.Uint16(header[1:]), payload[:size], nil
+}
+
+func (c *amqpConn) Close() error {
+	var w amqpWriter
+	w.short(200)
+	w.shortstr("")
+	w.short(0)
+	w.short(0)
+	c.conn.SetDeadline(time.Now().Add(time.Second))
+	c.method(0, amqpConnection, 50, w.buf)
+	return c.conn.Close()
+}
+
+func appendAMQPFrame(out []byte, kind byte, channel uint16, payload []byte) []byte {
+	out = append(out, kind, byte(channel>>8), byte(channel))
+	out = append(out, byte(len(payload)>>24), byte(len(payload)>>16), byte(len(payload)>>8), byte(len(payload)))
+	out = append(out, payload...)
+	return append(out, amqpFrameEnd)
+}
+
+type amqpWriter struct{ buf []byte }
+
+func (w *amqpWriter) octet(v byte) { w.buf = append(w.buf, v) }
+
+func (w *amqpWriter) short(v uint16) { w.buf = append(w.buf, byte(v>>8), byte(v)) }
+
+func (w *amqpWriter) long(v uint32) {
+	w.buf = append(w.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (w *amqpWriter) longlong(v uint64) {
+	w.long(uint32(v >> 32))
+	w.long(uint32(v))
+}
+
+func (w *amqpWriter) shortstr(s string) {
+	if len(s) > 255 {
+		s = s[:255]
+	}
+	w.buf = append(append(w.buf, byte(len(s))), s...)
+}
+
+func (w *amqpWriter) longstr(s string) {
+	w.long(uint32(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+// table writes a field table of strings, booleans and nested tables.
+func (w *amqpWriter) table(fields map[string]interface{}) {
+	var t amqpWriter
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		t.shortstr(k)
+		switch v := fields[k].(type) {
+		case string:
+			t.octet('S')
+			t.longstr(v)
+		case bool:
+			t.octet('t')
+			if v {
+				t.octet(1)
+			} else {
+				t.octet(0)
+			}
+		case map[string]interface{}:
+			t.octet('F')
+			t.table(v)
+		}
+	}
+	w.longstr(string(t.buf))
+}
+
+// amqpReader reads method arguments, keeping the first error.
+type amqpReader struct {
+	buf []byte
+	err error
+}
+
+func (r *amqpReader) take(n int) []byte {
+	if r.err != nil || len(r.buf) < n {
+		if r.err == nil {
+			r.err = errors.New("amqp: truncated method")
+		}
+		return make([]byte, n)
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *amqpReader) octet() byte { return r.take(1)[0] }
+
+func (r *amqpReader) short() uint16 { return binary.BigEndian.Uint16(r.take(2)) }
+
+func (r *amqpReader) long() uint32 { return binary.BigEndian.Uint32(r.take(4)) }
+
+func (r *amqpReader) longlong() uint64 { return binary.BigEndian.Uint64(r.take(8)) }
+
+func (r *amqpReader) shortstr() string { return string(r.take(int(r.octet()))) }
+
+func (r *amqpReader) longstr() string {
+	n := r.long()
+	if uint64(n) > uint64(len(r.buf)) {
+		r.take(len(r.buf) + 1)
+		return ""
+	}
+	return string(r.take(int(n)))
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// avroSchema is the Avro schema of Kafka records with kafka_encoding=avro,
+// the same fields as the dockerstats.v1.Sample protobuf message.
+const avroSchema = `{"type":"record","name":"Sample","namespace":"dockerstats.v1","fields":[` +
+	`{"name":"time","type":{"type":"long","logicalType":"timestamp-millis"}},` +
+	`{"name":"host","type":"string"},` +
+	`{"name":"id","type":"string"},` +
+	`{"name":"name","type":"string"},` +
+	`{"name":"image","type":"string"},` +
+	`{"name":"labels","type":{"type":"map","values":"string"}},` +
+	`{"name":"values","type":{"type":"map","values":"double"}}]}`
+
+// avroRecordName is the full name of the record in avroSchema.
+const avroRecordName = "dockerstats.v1.Sample"
+
+// avroEncoder serializes samples in the wire format of the Confluent Schema
+// Registry serializers: a zero byte, the schema ID as a big endian uint32 and
+// the Avro binary encoding of the record. The schema is registered under the
+// subject of the naming strategy, or only looked up when auto registration is
+// off; the ID is then kept for good.
+type avroEncoder struct {
+	registry     string
+	subject      string
+	username     string
+	password     string
+	autoRegister bool
+	host         string
+	client       *http.Client
+
+	mu sync.Mutex
+	id uint32
+}
+
+// newAvroEncoder reads the schema registry settings of an output producing to
+// topic: <output>_schema_registry_url, <output>_schema_registry_username and
+// <output>_schema_registry_password, <output>_subject_name_strategy and
+// <output>_schema_auto_register.
+func newAvroEncoder(output, topic string) (*avroEncoder, error) {
+	e := &avroEncoder{
+		registry:     strings.TrimRight(os.Getenv(output+"_schema_registry_url"), "/"),
+		username:     os.Getenv(output + "_schema_registry_username"),
+		autoRegister: true,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+	if e.registry == "" {
+		return nil, fmt.Errorf("%s_encoding=avro needs %s_schema_registry_url", output, output)
+	}
+	if u, err := url.Parse(e.registry); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid %s_schema_registry_url, expected http://host:8081", output)
+	}
+	var err error
+	if e.password, err = secretSetting(output + "_schema_registry_password"); err != nil {
+		return nil, fmt.Errorf("reading %s_schema_registry_password_file: %v", output, err)
+	}
+	switch v := os.Getenv(output + "_subject_name_strategy"); v {
+	case "", "topic":
+		e.subject = topic + "-value"
+	case "record":
+		e.subject = avroRecordName
+	case "topic_record":
+		e.subject = topic + "-" + avroRecordName
+	default:
+		return nil, fmt.Errorf("unknown %s_subject_name_strategy %q, expected topic, record or topic_record", output, v)
+	}
+	if v := os.Getenv(output + "_schema_auto_register"); v != "" {
+		if e.autoRegister, err = boolSetting(output + "_schema_auto_register"); err != nil {
+			return nil, err
+		}
+	}
+	e.host, _ = os.Hostname()
+	return e, nil
+}
+
+// encode serializes the sample, registering or looking up the schema first
+// if it wasn't yet.
+func (e *avroEncoder) encode(s sample, now time.Time) ([]byte, error) {
+	id, err := e.schemaID()
+	if err != nil {
+		return nil, err
+	}
+	labels := containerLabels(s)
+	buf := []byte{0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(buf[1:], id)
+	buf = avroLong(buf, now.UnixNano()/int64(time.Millisecond))
+	for _, v := range []string{e.host, s.ID, labels["name"], labels["image"]} {
+		buf = avroString(buf, v)
+	}
+	if len(s.Labels) > 0 {
+		buf = avroLong(buf, int64(len(s.Labels)))
+		for _, k := range sortedKeys(s.Labels) {
+			buf = avroString(avroString(buf, k), s.Labels[k])
+		}
+	}
+	buf = avroLong(buf, 0)
+	if len(s.Values) > 0 {
+		buf = avroLong(buf, int64(len(s.Values)))
+		for _, r := range sortedReadings(s.Values) {
+			buf = avroString(buf, r)
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(s.Values[r]))
+			buf = append(buf, b[:]...)
+		}
+	}
+	return avroLong(buf, 0), nil
+}
+
+func (e *avroEncoder) schemaID() (uint32, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.id != 0 {
+		return e.id, nil
+	}
+	// Registering an already registered schema returns its ID, while posting
+	// to the subject only looks it up.
+	path := "/subjects/" + url.PathEscape(e.subject)
+	if e.autoRegister {
+		path += "/versions"
+	}
+	body, err := json.Marshal(map[string]string{"schema": avroSchema})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.registry+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	if e.username != "" {
+		req.SetBasicAuth(e.username, e.password)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return 0, fmt.Errorf("schema registry responded %s for subject %s: %s", resp.Status, e.subject, strings.TrimSpace(string(msg)))
+	}
+	var result struct {
+		ID uint32 `json:"id"`
+	}
+	if err := json.Unmarshal(msg, &result); err != nil || result.ID == 0 {
+		return 0, errors.New("schema registry returned no schema ID")
+	}
+	e.id = result.ID
+	return e.id, nil
+}
+
+// avroLong appends a zigzag varint, the encoding of Avro ints and longs.
+func avroLong(buf []byte, v int64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutVarint(b[:], v)]...)
+}
+
+func avroString(buf []byte, s string) []byte {
+	return append(avroLong(buf, int64(len(s))), s...)
+}
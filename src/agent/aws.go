@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+}
+
+// awsCredentialSource reads credentials from AWS_ACCESS_KEY_ID and friends,
+// and otherwise from the ECS task role or the EC2 instance role, refreshing
+// those before they expire.
+type awsCredentialSource struct {
+	mu     sync.Mutex
+	cached awsCredentials
+	client *http.Client
+}
+
+var awsCreds = &awsCredentialSource{client: &http.Client{Timeout: 5 * time.Second}}
+
+const awsMetadataURL = "http://169.254.169.254/latest"
+
+func (s *awsCredentialSource) get() (awsCredentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return awsCredentials{AccessKeyID: id, SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"), Token: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cached.AccessKeyID != "" && time.Now().Add(5*time.Minute).Before(s.cached.Expiration) {
+		return s.cached, nil
+	}
+
+	var (
+		creds awsCredentials
+		err   error
+	)
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		creds, err = s.fetch("http://169.254.170.2"+uri, nil)
+	} else {
+		creds, err = s.instanceRole()
+	}
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("no AWS credentials in the environment and none from the instance: %v", err)
+	}
+	s.cached = creds
+	return creds, nil
+}
+
+// instanceRole reads the credentials of the EC2 instance role over IMDSv2.
+func (s *awsCredentialSource) instanceRole() (awsCredentials, error) {
+	req, _ := http.NewRequest(http.MethodPut, awsMetadataURL+"/api/token", nil)
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	token, err := s.read(req)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	headers := map[string]string{"X-aws-ec2-metadata-token": token}
+
+	req, _ = http.NewRequest(http.MethodGet, awsMetadataURL+"/meta-data/iam/security-credentials/", nil)
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	roles, err := s.read(req)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	role := strings.TrimSpace(strings.SplitN(roles, "\n", 2)[0])
+	if role == "" {
+		return awsCredentials{}, errors.New("the instance has no role")
+	}
+	return s.fetch(awsMetadataURL+"/meta-data/iam/security-credentials/"+role, headers)
+}
+
+func (s *awsCredentialSource) fetch(url string, headers map[string]string) (awsCredentials, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	body, err := s.read(req)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	var creds awsCredentials
+	if err := json.Unmarshal([]byte(body), &creds); err != nil {
+		return awsCredentials{}, err
+	}
+	if creds.AccessKeyID == "" {
+		return awsCredentials{}, errors.New("empty credentials")
+	}
+	return creds, nil
+}
+
+func (s *awsCredentialSource) read(req *http.Request) (string, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s responded %s", req.URL, resp.Status)
+	}
+	return string(body), nil
+}
+
+// awsRegion returns the region set for an output, falling back to AWS_REGION.
+func awsRegion(setting string) string {
+	if v := os.Getenv(setting); v != "" {
+		return v
+	}
+	if v := os.Getenv("AWS_REGION"); v != "" {
+		return v
+	}
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
+
+// signAWSv4 adds Signature Version 4 headers to req for the given body.
+func signAWSv4(req *http.Request, body []byte, service, region string, creds awsCredentials, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		strings.Replace(req.URL.Query().Encode(), "+", "%20", -1),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+)
+
+var collectBlockDevices bool
+
+// deviceCounters are the per-device readings, each a BLK_<DEVICE>_<suffix>
+// reading with the metric family exposing it.
+var deviceCounters = []struct {
+	suffix string
+	op     string
+	name   string
+	help   string
+}{
+	{"READ_BYTES", "read", "docker_container_blkio_device_read_bytes_total", "Bytes read from a block device."},
+	{"WRITE_BYTES", "write", "docker_container_blkio_device_write_bytes_total", "Bytes written to a block device."},
+}
+
+func init() {
+	registerMetrics(deviceMetrics)
+}
+
+// deviceReadings adds the bytes read from and written to every device to the
+// readings and formatted values, formatted in megabytes, and lists the
+// devices under BlockDevices.
+func deviceReadings(blkio types.BlkioStats, fields logrus.Fields, readings map[string]float64, values map[string]interface{}) {
+	perDevice := map[string]map[string]uint64{}
+	for _, e := range blkio.IoServiceBytesRecursive {
+		op := strings.ToLower(e.Op)
+		if op != "read" && op != "write" {
+			continue
+		}
+		name := blockDevices.name(e.Major, e.Minor)
+		if perDevice[name] == nil {
+			perDevice[name] = map[string]uint64{}
+		}
+		perDevice[name][op] += e.Value
+	}
+	if len(perDevice) == 0 {
+		return
+	}
+	names := make([]string, 0, len(perDevice))
+	for name, ops := range perDevice {
+		names = append(names, name)
+		prefix := deviceKey(name)
+		for _, c := range deviceCounters {
+			readings[prefix+c.suffix] = float64(ops[c.op])
+			values[prefix+strings.TrimSuffix(c.suffix, "_BYTES")+"_MB"] = formatMB(ops[c.op])
+		}
+	}
+	sort.Strings(names)
+	fields["BlockDevices"] = names
+}
+
+// deviceKey returns the prefix of a device's readings, e.g. BLK_SDA_.
+func deviceKey(name string) string {
+	return "BLK_" + readingName(name) + "_"
+}
+
+// deviceMetrics exposes the per-device readings of the last snapshot,
+// labeled by device.
+func deviceMetrics() []metric {
+	if !collectBlockDevices {
+		return nil
+	}
+	entries := snapshots.list()
+	families := make([]metric, 0, len(deviceCounters))
+	for _, c := range deviceCounters {
+		m := metric{name: c.name, help: c.help, kind: "counter"}
+		for _, e := range entries {
+			names, _ := e.sample.Fields["BlockDevices"].([]string)
+			for _, name := range names {
+				v, ok := e.sample.Values[deviceKey(name)+c.suffix]
+				if !ok {
+					continue
+				}
+				labels := containerLabels(e.sample)
+				labels["device"] = name
+				m.samples = append(m.samples, metricSample{labels: labels, value: v})
+			}
+		}
+		families = append(families, m)
+	}
+	return families
+}
+
+// blockDevices names block devices after /proc/partitions, which lists the
+// host's devices even inside a container. It's read again when a device
+// isn't known, at most once a minute.
+var blockDevices = &deviceNames{}
+
+type deviceNames struct {
+	mu     sync.Mutex
+	names  map[string]string
+	readAt time.Time
+}
+
+// name returns the device name of major:minor, e.g. sda or dm-1, or the
+// numbers joined by an underscore when the device isn't listed.
+func (d *deviceNames) name(major, minor uint64) string {
+	key := fmt.Sprintf("%d:%d", major, minor)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if name, ok := d.names[key]; ok {
+		return name
+	}
+	if time.Since(d.readAt) >= time.Minute {
+		d.readAt = time.Now()
+		if names, err := readPartitions(filepath.Join(hostProc, "partitions")); err != nil {
+			logrus.WithFields(logrus.Fields{"error": err}).Debug("cannot read block device names")
+		} else {
+			d.names = names
+		}
+		if name, ok := d.names[key]; ok {
+			return name
+		}
+	}
+	return fmt.Sprintf("%d_%d", major, minor)
+}
+
+// readPartitions maps major:minor to the device names of /proc/partitions.
+func readPartitions(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	names := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// major minor #blocks name
+		cols := strings.Fields(scanner.Text())
+		if len(cols) != 4 {
+			continue
+		}
+		if _, err := strconv.ParseUint(cols[0], 10, 64); err != nil {
+			continue
+		}
+		names[cols[0]+":"+cols[1]] = cols[3]
+	}
+	return names, scanner.Err()
+}
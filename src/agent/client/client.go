@@ -0,0 +1,122 @@
+// Package client is a typed client for the HTTP API of the docker-stats agent.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SchemaVersion is the version of the records and responses described in this
+// package. The agent reports the version it serves on /version.
+const SchemaVersion = 1
+
+// Record is the stats of one container as shipped by the agent's outputs.
+// Stats values are numbers, some of them encoded as strings with two
+// decimals (CPU_PCT, MEM_MB, ...), which json.Number reads either way.
+type Record struct {
+	Key           string                 `json:"Key"`
+	ID            string                 `json:"ID"`
+	Names         []string               `json:"Names"`
+	Image         string                 `json:"Image"`
+	ImageID       string                 `json:"ImageID"`
+	State         string                 `json:"State"`
+	Status        string                 `json:"Status"`
+	OS            string                 `json:"OS"`
+	Labels        map[string]string      `json:"Labels,omitempty"`
+	Env           map[string]string      `json:"Env,omitempty"`
+	NetworkMode   string                 `json:"NetworkMode,omitempty"`
+	NetworkShared bool                   `json:"NetworkShared,omitempty"`
+	Links         []string               `json:"Links,omitempty"`
+	ImageCreated  string                 `json:"ImageCreated,omitempty"`
+	ImageAgeDays  int                    `json:"ImageAgeDays,omitempty"`
+	SwarmNodeID   string                 `json:"SwarmNodeID,omitempty"`
+	SwarmNode     string                 `json:"SwarmNode,omitempty"`
+	SwarmService  string                 `json:"SwarmService,omitempty"`
+	SwarmTaskID   string                 `json:"SwarmTaskID,omitempty"`
+	SwarmTaskSlot int                    `json:"SwarmTaskSlot,omitempty"`
+	FirstSeen     time.Time              `json:"FirstSeen"`
+	LastSeen      time.Time              `json:"LastSeen"`
+	Stats         map[string]json.Number `json:"Stats"`
+}
+
+// Snapshot is the last record collected for a container.
+type Snapshot struct {
+	Collected time.Time `json:"collected"`
+	Record    Record    `json:"record"`
+}
+
+// Container is a monitored container as listed by /containers.
+type Container struct {
+	ID     string   `json:"id"`
+	Names  []string `json:"names"`
+	Image  string   `json:"image"`
+	State  string   `json:"state"`
+	Status string   `json:"status"`
+}
+
+// Version describes the agent build and the schema it serves.
+type Version struct {
+	Version string `json:"version"`
+	Schema  int    `json:"schema"`
+	Go      string `json:"go"`
+}
+
+// Client talks to one agent.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// New returns a client for the agent listening at baseURL, e.g. http://host:80.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), HTTPClient: &http.Client{Timeout: time.Minute}}
+}
+
+// Stats returns the last snapshot of every monitored container.
+func (c *Client) Stats(ctx context.Context) ([]Snapshot, error) {
+	var snapshots []Snapshot
+	return snapshots, c.do(ctx, http.MethodGet, "/stats", &snapshots)
+}
+
+// Containers lists the containers the agent monitors.
+func (c *Client) Containers(ctx context.Context) ([]Container, error) {
+	var containers []Container
+	return containers, c.do(ctx, http.MethodGet, "/containers", &containers)
+}
+
+// Version returns the agent version.
+func (c *Client) Version(ctx context.Context) (Version, error) {
+	var v Version
+	return v, c.do(ctx, http.MethodGet, "/version", &v)
+}
+
+// Scrape asks the agent to collect right away and returns the new records.
+func (c *Client) Scrape(ctx context.Context) ([]Record, error) {
+	var records []Record
+	return records, c.do(ctx, http.MethodPost, "/scrape", &records)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, v interface{}) error {
+	req, err := http.NewRequest(method, c.BaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
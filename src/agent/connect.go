@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	connectFailFast = "fail_fast"
+	connectRetry    = "retry"
+)
+
+// Exit codes of the agent.
+const (
+	exitDockerUnreachable = 1
+	exitInvalidConfig     = 2
+	exitOutputUnreachable = 3
+)
+
+const maxConnectBackoff = time.Minute
+
+// connected is set once dockerClient is usable; handlers check it because in
+// retry mode the HTTP server runs before the daemon has been reached.
+var connected int32
+
+func dockerConnected() bool {
+	return atomic.LoadInt32(&connected) == 1
+}
+
+// connectDocker creates the client and checks the daemon answers a ping.
+func connectDocker() error {
+	var (
+		c   dockerAPI
+		err error
+	)
+	if replayDir != "" {
+		c, err = newReplayClient(replayDir)
+	} else {
+		c, err = client.NewEnvClient()
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := c.Ping(ctx); err != nil {
+		return err
+	}
+
+	if info, err := c.Info(ctx); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err}).Warn("error getting docker info")
+	} else {
+		hostCPUs = info.NCPU
+	}
+
+	dockerClient = c
+	atomic.StoreInt32(&connected, 1)
+	return nil
+}
+
+// connectWithRetry keeps trying to reach the daemon with exponential backoff,
+// giving up once shutdown starts.
+func connectWithRetry() bool {
+	backoff := time.Second
+	for {
+		err := connectDocker()
+		if err == nil {
+			return true
+		}
+		logrus.WithFields(logrus.Fields{"error": err, "retry_in": backoff.String()}).Warn("cannot connect to docker, retrying")
+		select {
+		case <-background.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// metricsLabels lists the Docker labels, as path.Match patterns, exposed as
+// label_<name> dimensions of the container metrics.
+var metricsLabels []string
+
+// containerGauges maps the raw readings of a sample to the metric families
+// exposed for every container. They are taken from the unformatted float64
+// and byte readings, never from the two-decimal strings of the log output.
+var containerGauges = []struct {
+	reading string
+	name    string
+	help    string
+	kind    string
+}{
+	{"CPU_PCT", "docker_container_cpu_percent", "CPU usage in percent of one core.", "gauge"},
+	{"CPU_THROTTLED_PCT", "docker_container_cpu_throttled_percent", "Share of the CFS periods since the previous reading the container was throttled in.", "gauge"},
+	{"CPU_PERIODS", "docker_container_cpu_cfs_periods_total", "CFS enforcement periods elapsed.", "counter"},
+	{"CPU_THROTTLED_PERIODS", "docker_container_cpu_cfs_throttled_periods_total", "CFS enforcement periods the container was throttled in.", "counter"},
+	{"CPU_THROTTLED_NS", "docker_container_cpu_cfs_throttled_nanoseconds_total", "Time the container was throttled for.", "counter"},
+	{"MEM_BYTES", "docker_container_memory_usage_bytes", "Memory usage.", "gauge"},
+	{"MEM_LIMIT_BYTES", "docker_container_memory_limit_bytes", "Memory limit.", "gauge"},
+	{"MEM_WORKING_SET_BYTES", "docker_container_memory_working_set_bytes", "Memory usage minus the inactive file cache, as computed by the kubelet.", "gauge"},
+	{"MEM_RSS_BYTES", "docker_container_memory_rss_bytes", "Anonymous memory (rss, anon on cgroup v2).", "gauge"},
+	{"MEM_CACHE_BYTES", "docker_container_memory_cache_bytes", "Page cache memory (cache, file on cgroup v2).", "gauge"},
+	{"MEM_MAPPED_BYTES", "docker_container_memory_mapped_file_bytes", "Memory mapped files.", "gauge"},
+	{"MEM_ACTIVE_ANON_BYTES", "docker_container_memory_active_anon_bytes", "Anonymous memory on the active LRU list.", "gauge"},
+	{"MEM_INACTIVE_FILE_BYTES", "docker_container_memory_inactive_file_bytes", "Reclaimable file memory on the inactive LRU list.", "gauge"},
+	{"MEM_SWAP_BYTES", "docker_container_memory_swap_bytes", "Swap used (cgroup v1 with swap accounting).", "gauge"},
+	{"MEM_KERNEL_BYTES", "docker_container_memory_kernel_bytes", "Kernel memory (cgroup v2).", "gauge"},
+	{"MEM_PCT", "docker_container_memory_percent", "Memory usage in percent of the limit.", "gauge"},
+	{"MEM_FAILCNT", "docker_container_memory_failcnt", "Times the memory usage hit the limit (cgroup v1).", "counter"},
+	{"OOM_KILLS", "docker_container_oom_events_total", "OOM events of the container since the agent started.", "counter"},
+	{"NET_READ_BYTES", "docker_container_network_receive_bytes_total", "Bytes received on all networks.", "counter"},
+	{"NET_WRITE_BYTES", "docker_container_network_transmit_bytes_total", "Bytes sent on all networks.", "counter"},
+	{"NET_RX_ERRORS", "docker_container_network_receive_errors_total", "Receive errors on all networks.", "counter"},
+	{"NET_TX_ERRORS", "docker_container_network_transmit_errors_total", "Transmit errors on all networks.", "counter"},
+	{"NET_RX_DROPPED", "docker_container_network_receive_dropped_total", "Received packets dropped on all networks.", "counter"},
+	{"NET_TX_DROPPED", "docker_container_network_transmit_dropped_total", "Packets to send dropped on all networks.", "counter"},
+	{"BLK_READ_BYTES", "docker_container_blkio_read_bytes_total", "Bytes read from block devices.", "counter"},
+	{"BLK_WRITE_BYTES", "docker_container_blkio_write_bytes_total", "Bytes written to block devices.", "counter"},
+	{"BLK_SERVICE_NS", "docker_container_blkio_service_nanoseconds_total", "Time spent servicing block IO.", "counter"},
+	{"BLK_OPS", "docker_container_blkio_ops_total", "Block IO operations serviced.", "counter"},
+	{"BLK_READ_OPS", "docker_container_blkio_read_ops_total", "Block IO read operations serviced.", "counter"},
+	{"BLK_WRITE_OPS", "docker_container_blkio_write_ops_total", "Block IO write operations serviced.", "counter"},
+	{"BLK_READ_IOPS", "docker_container_blkio_read_iops", "Block IO read operations per second since the previous tick.", "gauge"},
+	{"BLK_WRITE_IOPS", "docker_container_blkio_write_iops", "Block IO write operations per second since the previous tick.", "gauge"},
+	{"BLK_LATENCY_MS", "docker_container_blkio_latency_milliseconds", "Average time per block IO operation since the container started.", "gauge"},
+	{"PIDS", "docker_container_pids", "Processes and threads in the container.", "gauge"},
+	{"PROCS", "docker_container_processes", "Processes listed by docker top.", "gauge"},
+	{"THREADS", "docker_container_threads", "Threads of the processes listed by docker top.", "gauge"},
+	{"SIZE_RW_BYTES", "docker_container_writable_layer_bytes", "Size of the files the container wrote to its writable layer.", "gauge"},
+	{"SIZE_ROOTFS_BYTES", "docker_container_rootfs_bytes", "Size of the container's root filesystem, image included.", "gauge"},
+	{"GPU_COUNT", "docker_container_gpus", "NVIDIA GPUs assigned to the container.", "gauge"},
+	{"GPU_UTIL_PCT", "docker_container_gpu_utilization_percent", "Average utilization of the container's GPUs.", "gauge"},
+	{"GPU_MEM_USED_BYTES", "docker_container_gpu_memory_used_bytes", "GPU memory used by the container's processes.", "gauge"},
+	{"GPU_MEM_TOTAL_BYTES", "docker_container_gpu_memory_total_bytes", "Memory of the container's GPUs.", "gauge"},
+	{"UPTIME_SECONDS", "docker_container_uptime_seconds", "Time since the container started.", "gauge"},
+	{"RESTART_COUNT", "docker_container_restarts_total", "Times the daemon restarted the container.", "counter"},
+	{"EXIT_CODE", "docker_container_last_exit_code", "Exit code of the container's last exit.", "gauge"},
+	{"HEALTHY", "docker_container_healthy", "1 while the healthcheck passes, 0 while starting or unhealthy.", "gauge"},
+	{"HEALTH_FAILING_STREAK", "docker_container_health_failing_streak", "Consecutive failed healthcheck probes.", "gauge"},
+	{"PIDS_LIMIT", "docker_container_pids_limit", "Processes and threads the container may run.", "gauge"},
+	{"PIDS_PCT", "docker_container_pids_percent", "Processes and threads in percent of the limit.", "gauge"},
+}
+
+func init() {
+	registerMetrics(containerMetrics)
+}
+
+// containerMetrics exposes the last snapshot of every container.
+func containerMetrics() []metric {
+	entries := snapshots.list()
+
+	families := make([]metric, 0, len(containerGauges))
+	for _, g := range containerGauges {
+		m := metric{name: g.name, help: g.help, kind: g.kind}
+		for _, e := range entries {
+			v, ok := e.sample.Values[g.reading]
+			if !ok {
+				continue
+			}
+			m.samples = append(m.samples, metricSample{labels: containerLabels(e.sample), value: v})
+		}
+		families = append(families, m)
+	}
+	return families
+}
+
+// containerLabels returns the dimensions of a container's metrics: its ID,
+// name and image, and the Docker labels selected by metrics_labels.
+func containerLabels(s sample) map[string]string {
+	labels := map[string]string{"id": containerKey(s)}
+	if names, ok := s.Fields["Names"].([]string); ok && len(names) > 0 {
+		labels["name"] = strings.TrimPrefix(names[0], "/")
+	}
+	if image, ok := s.Fields["Image"].(string); ok {
+		labels["image"] = image
+	}
+	for k, v := range s.Labels {
+		for _, pattern := range metricsLabels {
+			if ok, _ := path.Match(pattern, k); ok {
+				labels["label_"+sanitizeMetricName(k)] = v
+				break
+			}
+		}
+	}
+	return labels
+}
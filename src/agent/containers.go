@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	api "agent/client"
+	"github.com/docker/docker/api/types"
+)
+
+var (
+	excludePause bool
+	// pauseImage matches the infra images of Kubernetes and friends, such as
+	// k8s.gcr.io/pause:3.2, registry.k8s.io/pause or rancher/mirrored-pause.
+	pauseImage = regexp.MustCompile(`(^|/)([a-z0-9-]+-)?pause(-[a-z0-9]+)?([:@]|$)`)
+)
+
+// listContainers returns the containers the agent monitors. Both the stats
+// tick and /containers go through it so they always agree.
+func listContainers(ctx context.Context) ([]types.Container, error) {
+	containers, err := dockerClient.ContainerList(ctx, types.ContainerListOptions{})
+	if err != nil || !excludePause {
+		return containers, err
+	}
+
+	monitored := containers[:0]
+	for _, c := range containers {
+		if !isPauseContainer(c) {
+			monitored = append(monitored, c)
+		}
+	}
+	return monitored, nil
+}
+
+// isPauseContainer recognizes pod sandbox containers, either by the label
+// dockershim puts on them or by their image.
+func isPauseContainer(c types.Container) bool {
+	return c.Labels["io.kubernetes.docker.type"] == "podsandbox" || pauseImage.MatchString(c.Image)
+}
+
+// serveContainers lists the monitored containers without their stats.
+func serveContainers(w http.ResponseWriter, r *http.Request) {
+	if !dockerConnected() {
+		http.Error(w, "not connected to docker yet", http.StatusServiceUnavailable)
+		return
+	}
+	containers, err := listContainers(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	list := make([]api.Container, 0, len(containers))
+	for _, c := range containers {
+		list = append(list, api.Container{ID: c.ID, Names: c.Names, Image: c.Image, State: c.State, Status: c.Status})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
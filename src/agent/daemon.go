@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+var collectDaemon bool
+
+var lastDaemon = &recordList{}
+
+func init() {
+	registerMetrics(daemonMetrics)
+}
+
+// daemonTick exports a record of the daemon itself: its container counts by
+// state, images, storage driver and version, and the goroutines, file
+// descriptors and event listeners it reports.
+func daemonTick(ctx context.Context) {
+	info, err := dockerClient.Info(ctx)
+	if err != nil {
+		category := collectErrors.record("info", err)
+		logrus.WithFields(logrus.Fields{"error": err, "category": category}).Warn("error getting daemon info")
+		return
+	}
+
+	readings := map[string]float64{
+		"CONTAINERS":         float64(info.Containers),
+		"CONTAINERS_RUNNING": float64(info.ContainersRunning),
+		"CONTAINERS_PAUSED":  float64(info.ContainersPaused),
+		"CONTAINERS_STOPPED": float64(info.ContainersStopped),
+		"IMAGES":             float64(info.Images),
+		"NCPU":               float64(info.NCPU),
+		"MEM_TOTAL_BYTES":    float64(info.MemTotal),
+	}
+	values := map[string]interface{}{
+		"CONTAINERS":         info.Containers,
+		"CONTAINERS_RUNNING": info.ContainersRunning,
+		"CONTAINERS_PAUSED":  info.ContainersPaused,
+		"CONTAINERS_STOPPED": info.ContainersStopped,
+		"IMAGES":             info.Images,
+		"NCPU":               info.NCPU,
+		"MEM_TOTAL_MB":       formatMB(uint64(info.MemTotal)),
+	}
+	// Older daemons and some versions only count these in debug mode and
+	// report 0 otherwise.
+	if info.Debug || info.NGoroutines > 0 {
+		readings["GOROUTINES"] = float64(info.NGoroutines)
+		readings["FDS"] = float64(info.NFd)
+		readings["EVENTS_LISTENERS"] = float64(info.NEventsListener)
+		values["GOROUTINES"] = info.NGoroutines
+		values["FDS"] = info.NFd
+		values["EVENTS_LISTENERS"] = info.NEventsListener
+	}
+
+	id := info.ID
+	if id == "" {
+		id = info.Name
+	}
+	record := sample{
+		ID: id,
+		Fields: logrus.Fields{
+			"Record":          recordDaemon,
+			"Host":            info.Name,
+			"DaemonID":        info.ID,
+			"ServerVersion":   info.ServerVersion,
+			"StorageDriver":   info.Driver,
+			"CgroupDriver":    info.CgroupDriver,
+			"KernelVersion":   info.KernelVersion,
+			"OperatingSystem": info.OperatingSystem,
+			"Stats":           values,
+		},
+		Values: readings,
+	}
+	lastDaemon.set([]sample{record})
+	exportRecords([]sample{record})
+}
+
+// daemonMetrics exposes the last daemon record, with the version and storage
+// driver as labels of docker_daemon_info.
+func daemonMetrics() []metric {
+	records := lastDaemon.get()
+	if !collectDaemon || len(records) == 0 {
+		return nil
+	}
+	r := records[0]
+	version, _ := r.Fields["ServerVersion"].(string)
+	driver, _ := r.Fields["StorageDriver"].(string)
+	families := []metric{{
+		name: "docker_daemon_info", help: "Version and storage driver of the daemon, always 1.", kind: "gauge",
+		samples: []metricSample{{labels: map[string]string{"server_version": version, "storage_driver": driver}, value: 1}},
+	}}
+
+	containers := metric{name: "docker_daemon_containers", help: "Containers of the daemon by state.", kind: "gauge"}
+	for _, state := range []string{"running", "paused", "stopped"} {
+		if v, ok := r.Values["CONTAINERS_"+readingName(state)]; ok {
+			containers.samples = append(containers.samples, metricSample{labels: map[string]string{"state": state}, value: v})
+		}
+	}
+	families = append(families, containers)
+
+	gauges := []struct {
+		reading, name, help string
+	}{
+		{"IMAGES", "docker_daemon_images", "Images of the daemon."},
+		{"GOROUTINES", "docker_daemon_goroutines", "Goroutines of the daemon."},
+		{"FDS", "docker_daemon_fds", "File descriptors the daemon has open."},
+		{"EVENTS_LISTENERS", "docker_daemon_events_listeners", "Clients subscribed to the daemon's events."},
+	}
+	for _, g := range gauges {
+		if v, ok := r.Values[g.reading]; ok {
+			families = append(families, metric{name: g.name, help: g.help, kind: "gauge", samples: []metricSample{{labels: map[string]string{}, value: v}}})
+		}
+	}
+	return families
+}
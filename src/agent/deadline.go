@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A tick is given tickDeadlineFraction of its interval to finish, or the fixed
+// tickDeadline when set, so that slow collections never pile up behind each
+// other whatever the per-container timeouts are.
+var (
+	tickDeadline         time.Duration
+	tickDeadlineFraction = 0.8
+)
+
+// parseTickDeadline reads either a duration such as 45s or a percentage of the
+// interval such as 80%.
+func parseTickDeadline(v string) (time.Duration, float64, error) {
+	if strings.HasSuffix(v, "%") {
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+		if err != nil || pct <= 0 || pct > 100 {
+			return 0, 0, fmt.Errorf("invalid percentage %q", v)
+		}
+		return 0, pct / 100, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, 0, fmt.Errorf("invalid duration %q", v)
+	}
+	return d, 0, nil
+}
+
+// tickContext returns the context a tick running every interval collects under.
+func tickContext(interval time.Duration) (context.Context, context.CancelFunc) {
+	deadline := tickDeadline
+	if deadline == 0 {
+		deadline = time.Duration(float64(interval) * tickDeadlineFraction)
+	}
+	if deadline <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), deadline)
+}
+
+func describeTickDeadline() string {
+	if tickDeadline > 0 {
+		return tickDeadline.String()
+	}
+	return strconv.FormatFloat(tickDeadlineFraction*100, 'f', -1, 64) + "%"
+}
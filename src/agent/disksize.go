@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+)
+
+var collectDiskUsage bool
+
+type layerSize struct {
+	rw, rootFS int64
+}
+
+// layerSizes keeps the writable layer and root filesystem sizes of the
+// containers. Listing sizes makes the daemon walk every container's layer,
+// so they're listed in the background every interval rather than per tick,
+// and a tick reports the last listing.
+type layerSizes struct {
+	mu       sync.Mutex
+	interval time.Duration
+	sizes    map[string]layerSize
+	listedAt time.Time
+	listing  bool
+}
+
+var diskUsage = &layerSizes{interval: 5 * time.Minute, sizes: map[string]layerSize{}}
+
+// get returns the sizes of the container from the last listing, starting a
+// new listing when that's older than the interval.
+func (l *layerSizes) get(id string) (layerSize, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.listing && time.Since(l.listedAt) >= l.interval {
+		l.listing = true
+		go l.list()
+	}
+	size, ok := l.sizes[id]
+	return size, ok
+}
+
+func (l *layerSizes) list() {
+	ctx, cancel := context.WithTimeout(context.Background(), l.interval)
+	defer cancel()
+	started := time.Now()
+	containers, err := dockerClient.ContainerList(ctx, types.ContainerListOptions{Size: true})
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.listing = false
+	l.listedAt = time.Now()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err}).Warn("cannot list container sizes")
+		return
+	}
+	l.sizes = make(map[string]layerSize, len(containers))
+	for _, c := range containers {
+		l.sizes[c.ID] = layerSize{rw: c.SizeRw, rootFS: c.SizeRootFs}
+	}
+	logrus.WithFields(logrus.Fields{"containers": len(containers), "took": time.Since(started).String()}).Debug("listed container sizes")
+}
+
+// diskUsageFields adds SIZE_RW_BYTES, what the container wrote to its
+// writable layer, and SIZE_ROOTFS_BYTES, its whole root filesystem including
+// the image, once the first listing is done.
+func diskUsageFields(c types.Container, readings map[string]float64, values map[string]interface{}) {
+	size, ok := diskUsage.get(c.ID)
+	if !ok {
+		return
+	}
+	readings["SIZE_RW_BYTES"] = float64(size.rw)
+	readings["SIZE_ROOTFS_BYTES"] = float64(size.rootFS)
+	values["SIZE_RW_MB"] = formatMB(uint64(size.rw))
+	values["SIZE_ROOTFS_MB"] = formatMB(uint64(size.rootFS))
+}
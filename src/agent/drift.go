@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/robfig/cron"
+	"github.com/sirupsen/logrus"
+)
+
+// tickSchedule is the parsed stats_interval.
+var tickSchedule cron.Schedule
+
+// driftTracker compares the wall-clock time between global ticks with what
+// the schedule asked for. Growing drift means the agent can't keep up.
+type driftTracker struct {
+	mu       sync.Mutex
+	warn     time.Duration // 0 warns beyond a tenth of the expected interval
+	last     time.Time
+	actual   time.Duration
+	expected time.Duration
+}
+
+var drift = &driftTracker{}
+
+func init() {
+	registerMetrics(drift.metrics)
+}
+
+// expectedInterval is the time the schedule leaves between a tick at t and the next.
+func expectedInterval(t time.Time) time.Duration {
+	if tickSchedule == nil {
+		return 0
+	}
+	return tickSchedule.Next(t).Sub(t)
+}
+
+// tick records the start of a global tick.
+func (d *driftTracker) tick(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	last := d.last
+	d.last = now
+	if last.IsZero() || tickSchedule == nil {
+		return
+	}
+
+	d.actual = now.Sub(last)
+	d.expected = expectedInterval(last)
+
+	threshold := d.warn
+	if threshold == 0 {
+		threshold = d.expected / 10
+	}
+	if off := d.actual - d.expected; off > threshold || -off > threshold {
+		logrus.WithFields(logrus.Fields{
+			"interval": d.actual.String(),
+			"expected": d.expected.String(),
+			"drift":    off.String(),
+		}).Warn("stats tick drifted from the configured interval")
+	}
+}
+
+func (d *driftTracker) metrics() []metric {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return []metric{
+		gauge("docker_stats_tick_interval_seconds", "Wall-clock time between the last two stats ticks.", d.actual.Seconds()),
+		gauge("docker_stats_tick_drift_seconds", "Difference between the last tick interval and the configured interval.", (d.actual - d.expected).Seconds()),
+	}
+}
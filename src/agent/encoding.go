@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// encoder serializes records for push outputs.
+type encoder struct {
+	name        string
+	contentType string
+	marshal     func(v interface{}) ([]byte, error)
+}
+
+var encoders = map[string]encoder{
+	"json":     {name: "json", contentType: "application/json", marshal: json.Marshal},
+	"msgpack":  {name: "msgpack", contentType: "application/msgpack", marshal: marshalMsgpack},
+	"protobuf": {name: "protobuf", contentType: "application/x-protobuf"},
+}
+
+// outputEncoder returns the encoder selected by <output>_encoding, JSON by default.
+func outputEncoder(output string) (encoder, error) {
+	name := os.Getenv(output + "_encoding")
+	if name == "" {
+		name = "json"
+	}
+	enc, ok := encoders[name]
+	if !ok {
+		return encoder{}, fmt.Errorf("unknown %s_encoding %q", output, name)
+	}
+	if enc.name == "protobuf" && os.Getenv(output+"_records") == recordsTick {
+		return encoder{}, fmt.Errorf("%s_encoding=protobuf needs %s_records=container", output, output)
+	}
+	return enc, nil
+}
+
+// encode serializes the record of one container. Protobuf records are
+// dockerstats.v1.Sample messages of proto/stats.proto, which always carry
+// the raw readings whatever the schema.
+func (enc encoder) encode(s sample, schema string) ([]byte, error) {
+	if enc.name == "protobuf" {
+		host, _ := os.Hostname()
+		return protoSample(s, host, time.Now().UnixNano()), nil
+	}
+	return enc.marshal(s.record(schema))
+}
+
+// protoSamples encodes the samples of a tick as a dockerstats.v1.Samples
+// message.
+func protoSamples(samples []sample) []byte {
+	host, _ := os.Hostname()
+	now := time.Now().UnixNano()
+	var m protoWriter
+	for _, s := range samples {
+		m.bytes(1, protoSample(s, host, now))
+	}
+	return m.buf
+}
+
+// protoSample encodes a dockerstats.v1.Sample.
+func protoSample(s sample, host string, now int64) []byte {
+	labels := containerLabels(s)
+	var m protoWriter
+	m.varint(1, uint64(now))
+	m.string(2, host)
+	m.string(3, s.ID)
+	m.string(4, labels["name"])
+	m.string(5, labels["image"])
+	for _, k := range sortedKeys(s.Labels) {
+		var entry protoWriter
+		entry.string(1, k)
+		entry.string(2, s.Labels[k])
+		m.message(6, &entry)
+	}
+	for _, r := range sortedReadings(s.Values) {
+		var entry protoWriter
+		entry.string(1, r)
+		entry.double(2, s.Values[r])
+		m.message(7, &entry)
+	}
+	return m.buf
+}
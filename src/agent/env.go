@@ -0,0 +1,45 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	envAllowlist []string
+	envRedact    = regexp.MustCompile(`(?i)(pass|secret|token|key|credential|auth)`)
+)
+
+// filterEnv returns the variables from a container's KEY=VALUE environment
+// whose names match the allow-list. Values of names matching the redaction
+// pattern are replaced even when allow-listed.
+func filterEnv(env []string) map[string]string {
+	filtered := map[string]string{}
+	for _, kv := range env {
+		parts := strings.SplitN(kv, "=", 2)
+		name, value := parts[0], ""
+		if len(parts) == 2 {
+			value = parts[1]
+		}
+		if !envAllowed(name) {
+			continue
+		}
+		if envRedact.MatchString(name) {
+			value = redacted
+		}
+		filtered[name] = value
+	}
+	return filtered
+}
+
+func envAllowed(name string) bool {
+	for _, pattern := range envAllowlist {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+)
+
+// Categories of collection errors, so alerts can tell a daemon outage from
+// containers that exited between listing and reading their stats.
+const (
+	errContainerGone     = "container_gone"
+	errPermission        = "permission"
+	errDaemonUnreachable = "daemon_unreachable"
+	errTimeout           = "timeout"
+	errDecode            = "decode"
+	errOther             = "other"
+)
+
+// decodeError marks a stats body that couldn't be decoded.
+type decodeError struct{ err error }
+
+func (e decodeError) Error() string { return "decoding stats: " + e.err.Error() }
+
+// classifyError returns the category of an error returned by the Docker client.
+// The vendored client has no typed errors for most daemon responses, so the
+// messages are inspected as a last resort.
+func classifyError(err error) string {
+	cause := errors.Cause(err)
+	if _, ok := cause.(decodeError); ok {
+		return errDecode
+	}
+	if client.IsErrNotFound(err) {
+		return errContainerGone
+	}
+	if client.IsErrConnectionFailed(err) {
+		return errDaemonUnreachable
+	}
+	if cause == context.DeadlineExceeded {
+		return errTimeout
+	}
+	if netErr, ok := cause.(net.Error); ok && netErr.Timeout() {
+		return errTimeout
+	}
+	if os.IsPermission(cause) {
+		return errPermission
+	}
+
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "no such container") || strings.Contains(msg, "is not running"):
+		return errContainerGone
+	case strings.Contains(msg, "permission denied"):
+		return errPermission
+	case strings.Contains(msg, "cannot connect to the docker daemon") || strings.Contains(msg, "connection refused"):
+		return errDaemonUnreachable
+	case strings.Contains(msg, "timeout") || strings.Contains(msg, "deadline exceeded"):
+		return errTimeout
+	}
+	return errOther
+}
+
+// errorCounter counts collection errors by operation and category.
+type errorCounter struct {
+	mu     sync.Mutex
+	counts map[[2]string]uint64
+}
+
+var collectErrors = &errorCounter{counts: map[[2]string]uint64{}}
+
+func init() {
+	registerMetrics(collectErrors.metrics)
+}
+
+// record counts err against op and returns its category.
+func (c *errorCounter) record(op string, err error) string {
+	category := classifyError(err)
+	c.mu.Lock()
+	c.counts[[2]string{op, category}]++
+	c.mu.Unlock()
+	return category
+}
+
+func (c *errorCounter) metrics() []metric {
+	c.mu.Lock()
+	keys := make([][2]string, 0, len(c.counts))
+	for k := range c.counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	m := metric{name: "docker_stats_errors_total", help: "Collection errors by operation and category.", kind: "counter"}
+	for _, k := range keys {
+		m.samples = append(m.samples, metricSample{labels: map[string]string{"op": k[0], "category": k[1]}, value: float64(c.counts[k])})
+	}
+	c.mu.Unlock()
+	return []metric{m}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	collectEvents bool
+	// eventActions are the container events exported as records.
+	eventActions = map[string]bool{"start": true, "stop": true, "die": true, "oom": true, "kill": true, "restart": true}
+)
+
+// eventAttributes are the actor attributes with a field of their own in
+// event records; the others are the container's labels.
+var eventAttributes = map[string]string{"name": "Name", "image": "Image", "exitCode": "ExitCode", "signal": "Signal"}
+
+// startEvents subscribes to the container events of the daemon in the
+// background.
+func startEvents() {
+	go watchEvents(background)
+}
+
+// watchEvents handles the container events of the daemon until ctx is done.
+// A broken subscription is opened again, resuming from the last event seen.
+// Since only has a granularity of seconds, so the events resent from that
+// second are told apart by their time in nanoseconds.
+func watchEvents(ctx context.Context) {
+	backoff := time.Second
+	since := time.Now()
+	var last int64
+	for {
+		options := types.EventsOptions{
+			Since:   strconv.FormatInt(since.Unix(), 10),
+			Filters: filters.NewArgs(filters.Arg("type", events.ContainerEventType)),
+		}
+		messages, errs := dockerClient.Events(ctx, options)
+	stream:
+		for {
+			select {
+			case m := <-messages:
+				backoff = time.Second
+				at := m.TimeNano
+				if at == 0 {
+					at = m.Time * int64(time.Second)
+				}
+				if at > 0 && at <= last {
+					continue
+				}
+				if at > 0 {
+					last, since = at, time.Unix(0, at)
+				}
+				handleEvent(m)
+			case err := <-errs:
+				if ctx.Err() != nil {
+					return
+				}
+				logrus.WithFields(logrus.Fields{"error": err, "retry_in": backoff.String()}).Warn("docker events subscription broke, subscribing again")
+				break stream
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
+func handleEvent(m events.Message) {
+	if collectEvents && eventActions[m.Action] {
+		exportRecords([]sample{eventRecord(m)})
+	}
+	// Health changes arrive as "health_status: healthy" and the like.
+	if strings.HasPrefix(m.Action, "health_status") {
+		inspects.invalidate(m.Actor.ID)
+		return
+	}
+	switch m.Action {
+	case "start", "restart", "die":
+		// The cached inspect has the previous run's state.
+		inspects.invalidate(m.Actor.ID)
+	case "oom":
+		oomKills.add(m.Actor.ID)
+		logrus.WithFields(logrus.Fields{"ID": m.Actor.ID, "Name": m.Actor.Attributes["name"]}).Warn("container ran out of memory")
+	}
+}
+
+// describeEventActions lists the exported actions for the startup log.
+func describeEventActions() []string {
+	actions := make([]string, 0, len(eventActions))
+	for action := range eventActions {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	return actions
+}
+
+// eventRecord turns a container event into a record, as it happens rather
+// than on the next tick.
+func eventRecord(m events.Message) sample {
+	at := time.Unix(m.Time, 0)
+	if m.TimeNano > 0 {
+		at = time.Unix(0, m.TimeNano)
+	}
+	fields := logrus.Fields{
+		"Record": recordEvent,
+		"Action": m.Action,
+		"ID":     m.Actor.ID,
+		"Time":   at.UTC().Format(time.RFC3339Nano),
+	}
+	labels := map[string]string{}
+	for k, v := range m.Actor.Attributes {
+		if field, ok := eventAttributes[k]; ok {
+			fields[field] = v
+		} else {
+			labels[k] = v
+		}
+	}
+	if code, err := strconv.Atoi(m.Actor.Attributes["exitCode"]); err == nil {
+		fields["ExitCode"] = code
+	}
+	if name, ok := fields["Name"].(string); ok {
+		fields["Names"] = []string{"/" + name}
+	}
+	labelFields(fields, labels)
+	return sample{ID: m.Actor.ID, Labels: labels, Fields: fields, Values: map[string]float64{}}
+}
+
+// oomCounter counts the OOM events of every container since the agent
+// started.
+type oomCounter struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+var oomKills = &oomCounter{counts: map[string]uint64{}}
+
+func (c *oomCounter) add(id string) {
+	c.mu.Lock()
+	c.counts[id]++
+	c.mu.Unlock()
+}
+
+func (c *oomCounter) get(id string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[id]
+}
+
+func (c *oomCounter) forget(id string) {
+	c.mu.Lock()
+	delete(c.counts, id)
+	c.mu.Unlock()
+}
+
+func (c *oomCounter) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.counts)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sample is one container's stats for a tick: the formatted fields shipped by
+// outputs and the raw readings used for alerting and routing.
+type sample struct {
+	ID     string
+	Labels map[string]string
+	Fields logrus.Fields
+	Values map[string]float64
+}
+
+// Exporter ships the samples of a tick to an output. Close flushes anything
+// still buffered and releases connections; it is called once on shutdown.
+type Exporter interface {
+	Name() string
+	Export(samples []sample) error
+	Close() error
+}
+
+// exporterFactories builds an exporter from its environment settings, keyed by
+// the name used in the outputs setting.
+var exporterFactories = map[string]func() (Exporter, error){
+	"amqp":          newAMQPExporter,
+	"azure":         newAzureExporter,
+	"clickhouse":    newClickHouseExporter,
+	"cloudwatch":    newCloudWatchExporter,
+	"csv":           newCSVExporter,
+	"datadog":       newDatadogExporter,
+	"elasticsearch": newElasticsearchExporter,
+	"fluentd":       newFluentdExporter,
+	"gcm":           newGCMExporter,
+	"gelf":          newGELFExporter,
+	"graphite":      newGraphiteExporter,
+	"influx":        newInfluxExporter,
+	"jsonl":         newJSONLExporter,
+	"kafka":         newKafkaExporter,
+	"loki":          newLokiExporter,
+	"log":           newLogExporter,
+	"mqtt":          newMQTTExporter,
+	"nats":          newNATSExporter,
+	"newrelic":      newNewRelicExporter,
+	"otlp":          newOTLPExporter,
+	"postgres":      newPostgresExporter,
+	"redis":         newRedisExporter,
+	"remote_write":  newRemoteWriteExporter,
+	"splunk":        newSplunkExporter,
+	"statsd":        newStatsdExporter,
+	"syslog":        newSyslogExporter,
+	"tcp":           newTCPExporter,
+	"timestream":    newTimestreamExporter,
+	"wavefront":     newWavefrontExporter,
+	"webhook":       newWebhookExporter,
+}
+
+var exporters []Exporter
+
+// setupExporters builds the outputs named in the comma separated list.
+func setupExporters(names []string) error {
+	for _, name := range names {
+		factory, ok := exporterFactories[name]
+		if !ok {
+			return fmt.Errorf("unknown output %q", name)
+		}
+		e, err := factory()
+		if err != nil {
+			return fmt.Errorf("output %s: %v", name, err)
+		}
+		exporters = append(exporters, e)
+	}
+	return nil
+}
+
+// exportMu keeps the exports of the global tick, of the containers on their
+// own interval and of the other records from overlapping.
+var exportMu sync.Mutex
+
+// heldRecords are the records waiting for the next tick document, under
+// exportMu.
+var heldRecords []sample
+
+// tickExporter is an output that can combine the samples of a tick into one
+// document. Those documents belong to the global tick, so an output doing it
+// gets the samples of containers on their own interval with the next tick.
+type tickExporter interface {
+	Exporter
+	perTick() bool
+}
+
+func perTick(e Exporter) bool {
+	t, ok := e.(tickExporter)
+	return ok && t.perTick()
+}
+
+// export hands the samples of a tick to every output, applying the routing rules.
+func export(samples []sample) {
+	exportMu.Lock()
+	defer exportMu.Unlock()
+	snapshots.update(samples)
+	history.update(samples)
+	streams.publish(samples)
+	samples = idle.filter(samples)
+	labelled := intervals.drain()
+	records := heldRecords
+	heldRecords = nil
+	for _, e := range exporters {
+		if !outputHealth.ready(e.Name()) {
+			continue
+		}
+		tick := samples
+		if perTick(e) {
+			tick = append(append([]sample{}, samples...), labelled...)
+			if _, ok := e.(recordExporter); ok {
+				tick = append(tick, records...)
+			}
+		}
+		routed := routeSamples(e.Name(), tick)
+		if len(routed) == 0 {
+			continue
+		}
+		if err := e.Export(routed); err != nil {
+			logErrorExporting(e.Name(), err)
+		}
+	}
+}
+
+// exportLabelled hands the samples of a container collected on its own
+// interval to the outputs. They skip idle filtering, whose heartbeat counts
+// global ticks, and wait for the next tick in outputs writing tick documents.
+func exportLabelled(samples []sample) {
+	exportMu.Lock()
+	defer exportMu.Unlock()
+	snapshots.update(samples)
+	history.update(samples)
+	streams.publish(samples)
+	intervals.hold(samples)
+	for _, e := range exporters {
+		if perTick(e) || !outputHealth.ready(e.Name()) {
+			continue
+		}
+		routed := routeSamples(e.Name(), samples)
+		if len(routed) == 0 {
+			continue
+		}
+		if err := e.Export(routed); err != nil {
+			logErrorExporting(e.Name(), err)
+		}
+	}
+}
+
+// Kinds of records besides the per-container samples, set in their Record
+// field.
+const (
+	recordVolume = "volume"
+	recordImage  = "image"
+	// recordImages summarizes every image of the host.
+	recordImages = "images"
+	// recordDaemon describes the Docker daemon of the host.
+	recordDaemon = "daemon"
+	// recordHost describes the host the agent runs on.
+	recordHost = "host"
+	// recordEvent is a container event, such as a start or an OOM kill.
+	recordEvent = "event"
+)
+
+// recordExporter is an output shipping whole documents, which can take the
+// records that don't describe a container as well. Outputs modelling
+// container metrics don't implement it and never see those records.
+type recordExporter interface {
+	Exporter
+	exportsRecords()
+}
+
+// exportRecords hands records that don't describe a container, such as
+// volumes, to the outputs shipping documents. They skip the snapshots,
+// history and idle filtering the container samples go through, and join the
+// next tick document of outputs writing those.
+func exportRecords(records []sample) {
+	exportMu.Lock()
+	defer exportMu.Unlock()
+	held := false
+	for _, e := range exporters {
+		if _, ok := e.(recordExporter); !ok {
+			continue
+		}
+		if perTick(e) {
+			held = true
+			continue
+		}
+		if !outputHealth.ready(e.Name()) {
+			continue
+		}
+		routed := routeSamples(e.Name(), records)
+		if len(routed) == 0 {
+			continue
+		}
+		if err := e.Export(routed); err != nil {
+			logErrorExporting(e.Name(), err)
+		}
+	}
+	if held {
+		heldRecords = append(heldRecords, records...)
+	}
+}
+
+func logErrorExporting(output string, err error) {
+	logrus.WithFields(logrus.Fields{"output": output, "error": err}).Error("error exporting stats")
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Policies for outputs whose backend can't be reached at startup.
+const (
+	outputCheckWarn  = "warn"
+	outputCheckFail  = "fail"
+	outputCheckRetry = "retry"
+)
+
+var outputCheck = outputCheckWarn
+
+// checker is implemented by exporters with a backend that can be probed
+// before the first tick.
+type checker interface {
+	Check(ctx context.Context) error
+}
+
+// outputStatus tracks which outputs have been reached, exported on /metrics.
+// Outputs still being retried are left out of exports until they answer.
+type outputStatus struct {
+	mu       sync.Mutex
+	up       map[string]bool
+	retrying map[string]bool
+}
+
+var outputHealth = &outputStatus{up: map[string]bool{}, retrying: map[string]bool{}}
+
+func init() {
+	registerMetrics(outputHealth.metrics)
+}
+
+func (s *outputStatus) set(name string, up bool) {
+	s.mu.Lock()
+	s.up[name] = up
+	if up {
+		delete(s.retrying, name)
+	}
+	s.mu.Unlock()
+}
+
+func (s *outputStatus) retry(name string) {
+	s.mu.Lock()
+	s.retrying[name] = true
+	s.mu.Unlock()
+}
+
+// ready reports whether samples should be handed to the output.
+func (s *outputStatus) ready(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.retrying[name]
+}
+
+func (s *outputStatus) metrics() []metric {
+	m := metric{name: "docker_stats_output_up", help: "Whether the backend of an output was reachable on its last check.", kind: "gauge"}
+	s.mu.Lock()
+	for _, e := range exporters {
+		up, ok := s.up[e.Name()]
+		if !ok {
+			continue
+		}
+		v := 0.0
+		if up {
+			v = 1
+		}
+		m.samples = append(m.samples, metricSample{labels: map[string]string{"output": e.Name()}, value: v})
+	}
+	s.mu.Unlock()
+	return []metric{m}
+}
+
+// checkExporters probes every output that supports it and logs its status.
+// Under the fail policy it returns false if any is unreachable; under retry the
+// unreachable ones get no samples and keep being probed in the background
+// until they answer.
+func checkExporters() bool {
+	ok := true
+	for _, e := range exporters {
+		c, isChecker := e.(checker)
+		if !isChecker {
+			logrus.WithFields(logrus.Fields{"output": e.Name()}).Info("output ready")
+			continue
+		}
+		err := checkExporter(e.Name(), c)
+		if err == nil {
+			logrus.WithFields(logrus.Fields{"output": e.Name()}).Info("output ready")
+			continue
+		}
+
+		fields := logrus.Fields{"output": e.Name(), "error": err}
+		switch outputCheck {
+		case outputCheckFail:
+			logrus.WithFields(fields).Error("output unreachable")
+			ok = false
+		case outputCheckRetry:
+			logrus.WithFields(fields).Warn("output unreachable, retrying")
+			outputHealth.retry(e.Name())
+			go retryExporter(e.Name(), c)
+		default:
+			logrus.WithFields(fields).Warn("output unreachable, starting degraded")
+		}
+	}
+	return ok
+}
+
+func checkExporter(name string, c checker) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := c.Check(ctx)
+	outputHealth.set(name, err == nil)
+	return err
+}
+
+func retryExporter(name string, c checker) {
+	backoff := time.Second
+	for {
+		time.Sleep(backoff)
+		err := checkExporter(name, c)
+		if err == nil {
+			logrus.WithFields(logrus.Fields{"output": name}).Info("output ready")
+			return
+		}
+		if backoff *= 2; backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+		logrus.WithFields(logrus.Fields{"output": name, "error": err, "retry_in": backoff.String()}).Warn("output unreachable, retrying")
+	}
+}
+
+// dialCheck opens and closes a TCP connection to address.
+func dialCheck(ctx context.Context, address string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// urlAddress returns the host:port an http(s) URL connects to.
+func urlAddress(rawurl string) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
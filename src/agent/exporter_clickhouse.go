@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clickhouseIdentifier matches the database and table names accepted.
+var clickhouseIdentifier = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// clickhouseExporter inserts one row per container into clickhouse_table
+// through the HTTP interface at clickhouse_url, every tick's rows in a single
+// INSERT. The table is created when missing: a MergeTree partitioned by day
+// and ordered by container and time, with the readings of postgresColumns as
+// columns of their own and every reading in the stats map. clickhouse_ttl
+// drops rows once they're older.
+type clickhouseExporter struct {
+	url      string
+	username string
+	password string
+	database string
+	table    string
+	ttl      int
+	host     string
+	client   *http.Client
+
+	mu           sync.Mutex
+	bootstrapped bool
+}
+
+func newClickHouseExporter() (Exporter, error) {
+	raw := strings.TrimRight(os.Getenv("clickhouse_url"), "/")
+	if raw == "" {
+		return nil, errors.New("clickhouse_url is required")
+	}
+	if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid clickhouse_url, expected http://host:8123")
+	}
+	e := &clickhouseExporter{
+		url:      raw + "/",
+		username: os.Getenv("clickhouse_username"),
+		database: os.Getenv("clickhouse_database"),
+		table:    os.Getenv("clickhouse_table"),
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+	if e.database == "" {
+		e.database = "default"
+	}
+	if e.table == "" {
+		e.table = "docker_stats"
+	}
+	for _, name := range []string{e.database, e.table} {
+		if !clickhouseIdentifier.MatchString(name) {
+			return nil, fmt.Errorf("invalid clickhouse database or table %q", name)
+		}
+	}
+	if v := os.Getenv("clickhouse_ttl"); v != "" {
+		days, err := strconv.Atoi(strings.TrimSuffix(v, "d"))
+		if err != nil || days < 1 {
+			return nil, fmt.Errorf("invalid clickhouse_ttl %q, expected days such as 90d", v)
+		}
+		e.ttl = days
+	}
+	var err error
+	if e.password, err = secretSetting("clickhouse_password"); err != nil {
+		return nil, fmt.Errorf("reading clickhouse_password_file: %v", err)
+	}
+	e.host, _ = os.Hostname()
+	return e, nil
+}
+
+func (e *clickhouseExporter) Name() string { return "clickhouse" }
+
+func (e *clickhouseExporter) Export(samples []sample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+	now := time.Now().UTC().Format("2006-01-02 15:04:05.000")
+	var rows bytes.Buffer
+	for _, s := range samples {
+		labels := containerLabels(s)
+		row := map[string]interface{}{
+			"time":           now,
+			"host":           e.host,
+			"container_id":   containerKey(s),
+			"container_name": labels["name"],
+			"image":          labels["image"],
+			"labels":         s.Labels,
+		}
+		readings := jsonReadings(s.Values)
+		for _, c := range postgresColumns {
+			if v, ok := readings[c.reading]; ok {
+				row[c.column] = v
+			}
+		}
+		row["stats"] = readings
+		line, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		rows.Write(line)
+		rows.WriteByte('\n')
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.bootstrapped {
+		if err := e.query(e.ddl(), nil); err != nil {
+			return err
+		}
+		e.bootstrapped = true
+	}
+	return e.query("INSERT INTO "+e.database+"."+e.table+" FORMAT JSONEachRow", &rows)
+}
+
+// ddl creates the table when it doesn't exist.
+func (e *clickhouseExporter) ddl() string {
+	q := "CREATE TABLE IF NOT EXISTS " + e.database + "." + e.table + " (" +
+		"time DateTime64(3, 'UTC'), host LowCardinality(String), container_id String, " +
+		"container_name LowCardinality(String), image LowCardinality(String), labels Map(String, String)"
+	for _, c := range postgresColumns {
+		q += ", " + c.column + " Nullable(Float64)"
+	}
+	q += ", stats Map(String, Float64)) ENGINE = MergeTree PARTITION BY toYYYYMMDD(time) ORDER BY (container_name, container_id, time)"
+	if e.ttl > 0 {
+		q += " TTL toDateTime(time) + INTERVAL " + strconv.Itoa(e.ttl) + " DAY"
+	}
+	return q
+}
+
+// query runs a statement, with its data in the body when there is any.
+func (e *clickhouseExporter) query(q string, data *bytes.Buffer) error {
+	target, body := e.url, bytes.NewBufferString(q)
+	if data != nil {
+		target, body = e.url+"?query="+url.QueryEscape(q), data
+	}
+	req, err := http.NewRequest(http.MethodPost, target, body)
+	if err != nil {
+		return err
+	}
+	if e.username != "" {
+		req.Header.Set("X-ClickHouse-User", e.username)
+	}
+	if e.password != "" {
+		req.Header.Set("X-ClickHouse-Key", e.password)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("clickhouse responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (e *clickhouseExporter) Check(ctx context.Context) error {
+	address, err := urlAddress(e.url)
+	if err != nil {
+		return err
+	}
+	return dialCheck(ctx, address)
+}
+
+func (e *clickhouseExporter) Close() error { return nil }
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cloudwatchBatch is the number of metrics PutMetricData accepts per request.
+const cloudwatchBatch = 20
+
+// cloudwatchExporter publishes every raw reading with PutMetricData under
+// cloudwatch_namespace, with ContainerName, Image and Host dimensions.
+// Credentials come from the environment or the ECS task or EC2 instance role.
+type cloudwatchExporter struct {
+	namespace string
+	region    string
+	endpoint  string
+	host      string
+	client    *http.Client
+}
+
+type cloudwatchDatum struct {
+	name       string
+	value      float64
+	unit       string
+	dimensions [][2]string
+}
+
+func newCloudWatchExporter() (Exporter, error) {
+	region := awsRegion("cloudwatch_region")
+	if region == "" {
+		return nil, errors.New("cloudwatch_region or AWS_REGION is required")
+	}
+	e := &cloudwatchExporter{
+		namespace: os.Getenv("cloudwatch_namespace"),
+		region:    region,
+		endpoint:  "https://monitoring." + region + ".amazonaws.com/",
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+	if e.namespace == "" {
+		e.namespace = "DockerStats"
+	}
+	e.host, _ = os.Hostname()
+	return e, nil
+}
+
+func (e *cloudwatchExporter) Name() string { return "cloudwatch" }
+
+func (e *cloudwatchExporter) Export(samples []sample) error {
+	var data []cloudwatchDatum
+	for _, s := range samples {
+		labels := containerLabels(s)
+		dims := [][2]string{{"ContainerName", labels["name"]}, {"Image", labels["image"]}, {"Host", e.host}}
+		for _, r := range sortedReadings(s.Values) {
+			data = append(data, cloudwatchDatum{name: r, value: s.Values[r], unit: cloudwatchUnit(r), dimensions: dims})
+		}
+	}
+
+	now := time.Now()
+	for len(data) > 0 {
+		n := len(data)
+		if n > cloudwatchBatch {
+			n = cloudwatchBatch
+		}
+		if err := e.put(data[:n], now); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func (e *cloudwatchExporter) put(data []cloudwatchDatum, now time.Time) error {
+	form := url.Values{
+		"Action":    {"PutMetricData"},
+		"Version":   {"2010-08-01"},
+		"Namespace": {e.namespace},
+	}
+	ts := now.UTC().Format(time.RFC3339)
+	for i, d := range data {
+		member := "MetricData.member." + strconv.Itoa(i+1) + "."
+		form.Set(member+"MetricName", d.name)
+		form.Set(member+"Value", strconv.FormatFloat(d.value, 'f', -1, 64))
+		form.Set(member+"Unit", d.unit)
+		form.Set(member+"Timestamp", ts)
+		n := 0
+		for _, dim := range d.dimensions {
+			if dim[1] == "" {
+				continue
+			}
+			n++
+			prefix := member + "Dimensions.member." + strconv.Itoa(n) + "."
+			form.Set(prefix+"Name", dim[0])
+			form.Set(prefix+"Value", dim[1])
+		}
+	}
+	body := []byte(form.Encode())
+
+	creds, err := awsCreds.get()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signAWSv4(req, body, "monitoring", e.region, creds, now)
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("cloudwatch responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (e *cloudwatchExporter) Check(ctx context.Context) error {
+	if _, err := awsCreds.get(); err != nil {
+		return err
+	}
+	address, err := urlAddress(e.endpoint)
+	if err != nil {
+		return err
+	}
+	return dialCheck(ctx, address)
+}
+
+func (e *cloudwatchExporter) Close() error { return nil }
+
+// cloudwatchUnit picks the unit of a reading from its suffix.
+func cloudwatchUnit(reading string) string {
+	switch {
+	case strings.HasSuffix(reading, "_BYTES"):
+		return "Bytes"
+	case strings.HasSuffix(reading, "_MB"):
+		return "Megabytes"
+	case strings.HasSuffix(reading, "_PCT"):
+		return "Percent"
+	case strings.HasSuffix(reading, "_MS"):
+		return "Milliseconds"
+	case reading == "PIDS" || reading == "FDS" || strings.HasSuffix(reading, "_OPS"):
+		return "Count"
+	}
+	return "None"
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const csvDefaultColumns = "time,id,name,image,cpu_pct,mem_bytes,mem_limit_bytes,mem_pct,net_read_bytes,net_write_bytes,blk_read_bytes,blk_write_bytes,pids"
+
+// csvExporter appends one row per container to the CSV file at csv_path.
+// csv_columns picks the columns: time, id, name, image, label.<name> for a
+// Docker label, or a raw reading such as cpu_pct. Cells of missing readings
+// are left empty. The file is rotated as set by csv_max_size,
+// csv_rotate_interval and csv_max_files, every file starting with a header.
+type csvExporter struct {
+	columns []string
+
+	mu   sync.Mutex
+	file *rotatingFile
+}
+
+func newCSVExporter() (Exporter, error) {
+	file, err := newRotatingFile("csv")
+	if err != nil {
+		return nil, err
+	}
+	v := os.Getenv("csv_columns")
+	if v == "" {
+		v = csvDefaultColumns
+	}
+	e := &csvExporter{columns: splitList(v), file: file}
+
+	var header bytes.Buffer
+	w := csv.NewWriter(&header)
+	w.Write(e.columns)
+	w.Flush()
+	file.header = header.Bytes()
+	return e, nil
+}
+
+func (e *csvExporter) Name() string { return "csv" }
+
+func (e *csvExporter) Export(samples []sample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	var rows bytes.Buffer
+	w := csv.NewWriter(&rows)
+	for _, s := range samples {
+		labels := containerLabels(s)
+		row := make([]string, len(e.columns))
+		for i, c := range e.columns {
+			switch {
+			case c == "time":
+				row[i] = now
+			case c == "id" || c == "name" || c == "image":
+				row[i] = labels[c]
+			case strings.HasPrefix(c, "label."):
+				row[i] = s.Labels[strings.TrimPrefix(c, "label.")]
+			default:
+				if v, ok := s.Values[strings.ToUpper(c)]; ok && finite(v) {
+					row[i] = strconv.FormatFloat(v, 'f', -1, 64)
+				}
+			}
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.file.Write(rows.Bytes())
+	return err
+}
+
+func (e *csvExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// datadogBatch caps the series per request, well under the API's payload limit.
+const datadogBatch = 1000
+
+// datadogExporter submits every raw reading as a gauge to the Datadog metrics
+// API once per tick, named like docker.container.cpu.pct and tagged with the
+// container's name, ID, image and Docker labels. The API key comes from
+// datadog_api_key or the file named by datadog_api_key_file.
+type datadogExporter struct {
+	url    string
+	apiKey string
+	host   string
+	client *http.Client
+}
+
+type datadogSeries struct {
+	Metric string       `json:"metric"`
+	Points [][2]float64 `json:"points"`
+	Type   string       `json:"type"`
+	Host   string       `json:"host,omitempty"`
+	Tags   []string     `json:"tags,omitempty"`
+}
+
+func newDatadogExporter() (Exporter, error) {
+	key, err := secretSetting("datadog_api_key")
+	if err != nil {
+		return nil, fmt.Errorf("reading datadog_api_key_file: %v", err)
+	}
+	if key == "" {
+		return nil, errors.New("datadog_api_key or datadog_api_key_file is required")
+	}
+	site := os.Getenv("datadog_site")
+	if site == "" {
+		site = "datadoghq.com"
+	}
+	e := &datadogExporter{
+		url:    "https://api." + site + "/api/v1/series",
+		apiKey: key,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	e.host, _ = os.Hostname()
+	return e, nil
+}
+
+func (e *datadogExporter) Name() string { return "datadog" }
+
+func (e *datadogExporter) Export(samples []sample) error {
+	now := float64(time.Now().Unix())
+	var series []datadogSeries
+	for _, s := range samples {
+		tags := datadogTags(s)
+		for _, r := range sortedReadings(s.Values) {
+			// JSON has no NaN or infinity; one would fail the whole request.
+			if !finite(s.Values[r]) {
+				continue
+			}
+			series = append(series, datadogSeries{
+				Metric: "docker.container." + strings.ToLower(strings.Replace(r, "_", ".", -1)),
+				Points: [][2]float64{{now, s.Values[r]}},
+				Type:   "gauge",
+				Host:   e.host,
+				Tags:   tags,
+			})
+		}
+	}
+
+	for len(series) > 0 {
+		n := len(series)
+		if n > datadogBatch {
+			n = datadogBatch
+		}
+		if err := e.post(series[:n]); err != nil {
+			return err
+		}
+		series = series[n:]
+	}
+	return nil
+}
+
+func (e *datadogExporter) post(series []datadogSeries) error {
+	body, err := json.Marshal(map[string]interface{}{"series": series})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("DD-API-KEY", e.apiKey)
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("datadog responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (e *datadogExporter) Check(ctx context.Context) error {
+	address, err := urlAddress(e.url)
+	if err != nil {
+		return err
+	}
+	return dialCheck(ctx, address)
+}
+
+func (e *datadogExporter) Close() error { return nil }
+
+// datadogTags describes a container with key:value tags, Docker labels
+// included.
+func datadogTags(s sample) []string {
+	labels := containerLabels(s)
+	tags := []string{"container_id:" + containerKey(s)}
+	if labels["name"] != "" {
+		tags = append(tags, "container_name:"+labels["name"])
+	}
+	if labels["image"] != "" {
+		tags = append(tags, "image:"+labels["image"])
+	}
+	for _, k := range sortedKeys(s.Labels) {
+		tags = append(tags, k+":"+s.Labels[k])
+	}
+	return tags
+}
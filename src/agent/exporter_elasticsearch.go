@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	elasticsearchBatch   = 500 // documents per bulk request
+	elasticsearchRetries = 3   // attempts after a 429 before giving up
+)
+
+// elasticsearchExporter indexes one document per record through the bulk API
+// into a date-based index such as docker-stats-2024.05.01. Requests and
+// documents rejected with 429 are retried with a growing backoff.
+type elasticsearchExporter struct {
+	url    string
+	index  string
+	auth   string
+	schema string
+	client *http.Client
+}
+
+type elasticsearchBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+func newElasticsearchExporter() (Exporter, error) {
+	url := strings.TrimRight(os.Getenv("elasticsearch_url"), "/")
+	if url == "" {
+		return nil, errors.New("elasticsearch_url is required")
+	}
+	index := os.Getenv("elasticsearch_index")
+	if index == "" {
+		index = "docker-stats-%Y.%m.%d"
+	}
+
+	var auth string
+	apiKey, err := secretSetting("elasticsearch_api_key")
+	if err != nil {
+		return nil, fmt.Errorf("reading elasticsearch_api_key_file: %v", err)
+	}
+	password, err := secretSetting("elasticsearch_password")
+	if err != nil {
+		return nil, fmt.Errorf("reading elasticsearch_password_file: %v", err)
+	}
+	if apiKey != "" {
+		auth = "ApiKey " + apiKey
+	} else if user := os.Getenv("elasticsearch_username"); user != "" {
+		req, _ := http.NewRequest(http.MethodPost, url, nil)
+		req.SetBasicAuth(user, password)
+		auth = req.Header.Get("Authorization")
+	}
+
+	schema, err := outputSchema("elasticsearch")
+	if err != nil {
+		return nil, err
+	}
+	return &elasticsearchExporter{url: url, index: index, auth: auth, schema: schema, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+func (e *elasticsearchExporter) Name() string { return "elasticsearch" }
+
+func (e *elasticsearchExporter) exportsRecords() {}
+
+func (e *elasticsearchExporter) Export(samples []sample) error {
+	now := time.Now().UTC()
+	action, err := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": indexName(e.index, now)}})
+	if err != nil {
+		return err
+	}
+
+	var docs [][]byte
+	for _, record := range serialize(samples, e.schema) {
+		doc := make(map[string]interface{}, len(record)+1)
+		for k, v := range record {
+			doc[k] = v
+		}
+		doc["@timestamp"] = now.Format(time.RFC3339Nano)
+		b, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		docs = append(docs, b)
+	}
+
+	for len(docs) > 0 {
+		n := len(docs)
+		if n > elasticsearchBatch {
+			n = elasticsearchBatch
+		}
+		if err := e.bulk(action, docs[:n]); err != nil {
+			return err
+		}
+		docs = docs[n:]
+	}
+	return nil
+}
+
+// bulk indexes the documents, retrying those Elasticsearch is too busy for.
+func (e *elasticsearchExporter) bulk(action []byte, docs [][]byte) error {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		retry, err := e.post(action, docs)
+		if err != nil {
+			return err
+		}
+		if len(retry) == 0 {
+			return nil
+		}
+		if attempt == elasticsearchRetries {
+			return fmt.Errorf("elasticsearch rejected %d documents with 429 after %d retries", len(retry), elasticsearchRetries)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		docs = retry
+	}
+}
+
+// post sends one bulk request and returns the documents to try again.
+func (e *elasticsearchExporter) post(action []byte, docs [][]byte) ([][]byte, error) {
+	var body bytes.Buffer
+	for _, doc := range docs {
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(doc)
+		body.WriteByte('\n')
+	}
+	req, err := http.NewRequest(http.MethodPost, e.url+"/_bulk", &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if e.auth != "" {
+		req.Header.Set("Authorization", e.auth)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return docs, nil
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("elasticsearch responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var result elasticsearchBulkResponse
+	if err := json.Unmarshal(msg, &result); err != nil {
+		return nil, fmt.Errorf("decoding the bulk response: %v", err)
+	}
+	if !result.Errors {
+		return nil, nil
+	}
+	var retry [][]byte
+	var failed int
+	var first json.RawMessage
+	for i, item := range result.Items {
+		for _, status := range item {
+			switch {
+			case status.Status == http.StatusTooManyRequests && i < len(docs):
+				retry = append(retry, docs[i])
+			case status.Status >= 300:
+				if failed == 0 {
+					first = status.Error
+				}
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		return nil, fmt.Errorf("elasticsearch rejected %d documents: %s", failed, first)
+	}
+	return retry, nil
+}
+
+// indexName expands the %Y, %m, %d and %H of an index pattern with the time.
+func indexName(pattern string, t time.Time) string {
+	return strings.NewReplacer(
+		"%Y", strconv.Itoa(t.Year()),
+		"%m", fmt.Sprintf("%02d", t.Month()),
+		"%d", fmt.Sprintf("%02d", t.Day()),
+		"%H", fmt.Sprintf("%02d", t.Hour()),
+	).Replace(pattern)
+}
+
+func (e *elasticsearchExporter) Check(ctx context.Context) error {
+	address, err := urlAddress(e.url)
+	if err != nil {
+		return err
+	}
+	return dialCheck(ctx, address)
+}
+
+func (e *elasticsearchExporter) Close() error { return nil }
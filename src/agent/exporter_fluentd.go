@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const fluentdTimeout = 30 * time.Second
+
+// fluentdExporter sends the records of every tick as one Forward mode message
+// of the Fluentd forward protocol to fluentd_address, tagged fluentd_tag.
+// With fluentd_shared_key the connection starts with the protocol's shared
+// key handshake, and with fluentd_ack=true every message must be acknowledged
+// by the server.
+type fluentdExporter struct {
+	address   string
+	tag       string
+	hostname  string
+	sharedKey string
+	username  string
+	password  string
+	ack       bool
+	tls       *tls.Config
+	schema    string
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newFluentdExporter() (Exporter, error) {
+	e := &fluentdExporter{address: os.Getenv("fluentd_address"), tag: os.Getenv("fluentd_tag"), username: os.Getenv("fluentd_username")}
+	if e.address == "" {
+		return nil, errors.New("fluentd_address is required")
+	}
+	if _, _, err := net.SplitHostPort(e.address); err != nil {
+		e.address = net.JoinHostPort(e.address, "24224")
+	}
+	if e.tag == "" {
+		e.tag = "docker.stats"
+	}
+	e.hostname, _ = os.Hostname()
+
+	var err error
+	if e.sharedKey, err = secretSetting("fluentd_shared_key"); err != nil {
+		return nil, fmt.Errorf("reading fluentd_shared_key_file: %v", err)
+	}
+	if e.password, err = secretSetting("fluentd_password"); err != nil {
+		return nil, fmt.Errorf("reading fluentd_password_file: %v", err)
+	}
+	if e.username != "" && e.sharedKey == "" {
+		return nil, errors.New("fluentd_username needs fluentd_shared_key")
+	}
+	if e.ack, err = boolSetting("fluentd_ack"); err != nil {
+		return nil, err
+	}
+	host, _, _ := net.SplitHostPort(e.address)
+	if e.tls, err = outputTLS("fluentd", host); err != nil {
+		return nil, err
+	}
+	if e.schema, err = outputSchema("fluentd"); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *fluentdExporter) Name() string { return "fluentd" }
+
+func (e *fluentdExporter) exportsRecords() {}
+
+func (e *fluentdExporter) Export(samples []sample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+
+	// [tag, [[time, record], ...], option], times as EventTime extensions.
+	var entries bytes.Buffer
+	writeMsgpackArrayLen(&entries, len(samples))
+	now := time.Now()
+	var eventTime [10]byte
+	eventTime[0], eventTime[1] = 0xd7, 0x00
+	binary.BigEndian.PutUint32(eventTime[2:], uint32(now.Unix()))
+	binary.BigEndian.PutUint32(eventTime[6:], uint32(now.Nanosecond()))
+	for _, s := range samples {
+		record, err := marshalMsgpack(s.record(e.schema))
+		if err != nil {
+			return err
+		}
+		writeMsgpackArrayLen(&entries, 2)
+		entries.Write(eventTime[:])
+		entries.Write(record)
+	}
+	option := map[string]interface{}{"size": len(samples)}
+	var chunk string
+	if e.ack {
+		id := make([]byte, 16)
+		rand.Read(id)
+		chunk = base64.StdEncoding.EncodeToString(id)
+		option["chunk"] = chunk
+	}
+	optionBytes, err := marshalMsgpack(option)
+	if err != nil {
+		return err
+	}
+	var msg bytes.Buffer
+	writeMsgpackArrayLen(&msg, 3)
+	writeMsgpackString(&msg, e.tag)
+	msg.Write(entries.Bytes())
+	msg.Write(optionBytes)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn == nil {
+		if err := e.connect(); err != nil {
+			return err
+		}
+	}
+	if err := e.send(msg.Bytes(), chunk); err != nil {
+		e.conn.Close()
+		e.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (e *fluentdExporter) send(msg []byte, chunk string) error {
+	e.conn.SetDeadline(time.Now().Add(fluentdTimeout))
+	defer e.conn.SetDeadline(time.Time{})
+	if _, err := e.conn.Write(msg); err != nil {
+		return err
+	}
+	if chunk == "" {
+		return nil
+	}
+	resp, err := readMsgpack(e.r)
+	if err != nil {
+		return err
+	}
+	if m, _ := resp.(map[string]interface{}); m == nil || m["ack"] != chunk {
+		return errors.New("fluentd: server acknowledged another chunk")
+	}
+	return nil
+}
+
+// connect dials the server and, with a shared key, runs the handshake: the
+// server's HELO is answered with a PING proving the key, and its PONG must
+// prove it too.
+func (e *fluentdExporter) connect() error {
+	var conn net.Conn
+	var err error
+	if e.tls != nil {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: fluentdTimeout}, "tcp", e.address, e.tls)
+	} else {
+		conn, err = net.DialTimeout("tcp", e.address, fluentdTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	if e.sharedKey != "" {
+		conn.SetDeadline(time.Now().Add(fluentdTimeout))
+		if err := e.handshake(conn, r); err != nil {
+			conn.Close()
+			return err
+		}
+		conn.SetDeadline(time.Time{})
+	}
+	e.conn, e.r = conn, r
+	return nil
+}
+
+func (e *fluentdExporter) handshake(conn net.Conn, r *bufio.Reader) error {
+	v, err := readMsgpack(r)
+	if err != nil {
+		return err
+	}
+	helo, _ := v.([]interface{})
+	if len(helo) < 2 || helo[0] != "HELO" {
+		return errors.New("fluentd: expected HELO from the server, is shared key authentication enabled?")
+	}
+	options, _ := helo[1].(map[string]interface{})
+	nonce, authSalt := msgpackText(options["nonce"]), msgpackText(options["auth"])
+
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	sharedSalt := hex.EncodeToString(salt)
+	passwordDigest := ""
+	if authSalt != "" {
+		passwordDigest = sha512Hex(authSalt + e.username + e.password)
+	}
+	ping, err := marshalMsgpack([]string{"PING", e.hostname, sharedSalt, sha512Hex(sharedSalt + e.hostname + nonce + e.sharedKey), e.username, passwordDigest})
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(ping); err != nil {
+		return err
+	}
+
+	if v, err = readMsgpack(r); err != nil {
+		return err
+	}
+	pong, _ := v.([]interface{})
+	if len(pong) < 5 || pong[0] != "PONG" {
+		return errors.New("fluentd: malformed PONG")
+	}
+	if ok, _ := pong[1].(bool); !ok {
+		return fmt.Errorf("fluentd: authentication failed: %v", pong[2])
+	}
+	server := msgpackText(pong[3])
+	if msgpackText(pong[4]) != sha512Hex(sharedSalt+server+nonce+e.sharedKey) {
+		return errors.New("fluentd: server doesn't know the shared key")
+	}
+	return nil
+}
+
+// msgpackText reads a str or bin value as a string.
+func msgpackText(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case []byte:
+		return string(t)
+	}
+	return ""
+}
+
+func sha512Hex(s string) string {
+	sum := sha512.Sum512([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func (e *fluentdExporter) Check(ctx context.Context) error {
+	return dialCheck(ctx, e.address)
+}
+
+func (e *fluentdExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn != nil {
+		e.conn.Close()
+		e.conn = nil
+	}
+	return nil
+}
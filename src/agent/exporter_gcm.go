@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gcmAPI        = "https://monitoring.googleapis.com/v3/"
+	gcmMetricType = "custom.googleapis.com/docker/container/"
+	gcmBatch      = 200 // time series per CreateTimeSeries request
+)
+
+// gcmExporter writes every raw reading to Google Cloud Monitoring as a custom
+// gauge, custom.googleapis.com/docker/container/<reading>, creating the metric
+// descriptors on first use. The monitored resource is detected from the
+// metadata server: a k8s_node on GKE, a gce_instance on GCE and global
+// elsewhere.
+type gcmExporter struct {
+	project  string
+	resource gcmResource
+	client   *http.Client
+
+	mu          sync.Mutex
+	descriptors map[string]bool
+}
+
+type gcmResource struct {
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels"`
+}
+
+type gcmTimeSeries struct {
+	Metric struct {
+		Type   string            `json:"type"`
+		Labels map[string]string `json:"labels"`
+	} `json:"metric"`
+	Resource gcmResource `json:"resource"`
+	Points   []gcmPoint  `json:"points"`
+}
+
+type gcmPoint struct {
+	Interval struct {
+		EndTime string `json:"endTime"`
+	} `json:"interval"`
+	Value struct {
+		DoubleValue float64 `json:"doubleValue"`
+	} `json:"value"`
+}
+
+func newGCMExporter() (Exporter, error) {
+	e := &gcmExporter{
+		project:     os.Getenv("gcm_project"),
+		client:      &http.Client{Timeout: 10 * time.Second},
+		descriptors: map[string]bool{},
+	}
+	if e.project == "" {
+		if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+			if account, err := readServiceAccount(path); err == nil {
+				e.project = account.ProjectID
+			}
+		}
+	}
+	if e.project == "" {
+		e.project, _ = gcpMetadata("project/project-id")
+	}
+	if e.project == "" {
+		return nil, errors.New("gcm_project is required off Google Cloud")
+	}
+	e.resource = detectGCMResource(e.project)
+	return e, nil
+}
+
+// detectGCMResource describes the host from the metadata server.
+func detectGCMResource(project string) gcmResource {
+	id, err := gcpMetadata("instance/id")
+	if err != nil {
+		return gcmResource{Type: "global", Labels: map[string]string{"project_id": project}}
+	}
+	zone, _ := gcpMetadata("instance/zone")
+	zone = zone[strings.LastIndex(zone, "/")+1:]
+
+	if cluster, err := gcpMetadata("instance/attributes/cluster-name"); err == nil && cluster != "" {
+		location, err := gcpMetadata("instance/attributes/cluster-location")
+		if err != nil || location == "" {
+			location = zone
+		}
+		node, _ := gcpMetadata("instance/name")
+		return gcmResource{Type: "k8s_node", Labels: map[string]string{
+			"project_id": project, "location": location, "cluster_name": cluster, "node_name": node,
+		}}
+	}
+	return gcmResource{Type: "gce_instance", Labels: map[string]string{
+		"project_id": project, "instance_id": id, "zone": zone,
+	}}
+}
+
+func (e *gcmExporter) Name() string { return "gcm" }
+
+func (e *gcmExporter) Export(samples []sample) error {
+	token, err := gcpTokens.get()
+	if err != nil {
+		return err
+	}
+
+	end := time.Now().UTC().Format(time.RFC3339Nano)
+	var series []gcmTimeSeries
+	for _, s := range samples {
+		labels := containerLabels(s)
+		metricLabels := map[string]string{"container_id": containerKey(s), "container_name": labels["name"], "image": labels["image"]}
+		for _, r := range sortedReadings(s.Values) {
+			if !finite(s.Values[r]) {
+				continue
+			}
+			metricType := gcmMetricType + strings.ToLower(r)
+			if err := e.describe(token, metricType, r); err != nil {
+				return err
+			}
+			var ts gcmTimeSeries
+			ts.Metric.Type = metricType
+			ts.Metric.Labels = metricLabels
+			ts.Resource = e.resource
+			var p gcmPoint
+			p.Interval.EndTime = end
+			p.Value.DoubleValue = s.Values[r]
+			ts.Points = []gcmPoint{p}
+			series = append(series, ts)
+		}
+	}
+
+	for len(series) > 0 {
+		n := len(series)
+		if n > gcmBatch {
+			n = gcmBatch
+		}
+		if err := e.post(token, "timeSeries", map[string]interface{}{"timeSeries": series[:n]}); err != nil {
+			return err
+		}
+		series = series[n:]
+	}
+	return nil
+}
+
+// describe creates the descriptor of a metric type the first time it's seen.
+func (e *gcmExporter) describe(token, metricType, reading string) error {
+	e.mu.Lock()
+	done := e.descriptors[metricType]
+	e.mu.Unlock()
+	if done {
+		return nil
+	}
+
+	label := func(key string) map[string]string { return map[string]string{"key": key, "valueType": "STRING"} }
+	descriptor := map[string]interface{}{
+		"type":        metricType,
+		"metricKind":  "GAUGE",
+		"valueType":   "DOUBLE",
+		"displayName": "Container " + reading,
+		"description": "The " + reading + " reading of a container, as collected by docker-stats.",
+		"labels":      []map[string]string{label("container_id"), label("container_name"), label("image")},
+	}
+	if err := e.post(token, "metricDescriptors", descriptor); err != nil {
+		return fmt.Errorf("creating the descriptor of %s: %v", metricType, err)
+	}
+
+	e.mu.Lock()
+	e.descriptors[metricType] = true
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *gcmExporter) post(token, collection string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, gcmAPI+"projects/"+e.project+"/"+collection, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusConflict {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("cloud monitoring responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (e *gcmExporter) Check(ctx context.Context) error {
+	_, err := gcpTokens.get()
+	return err
+}
+
+func (e *gcmExporter) Close() error { return nil }
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gelfMaxChunks is the most chunks Graylog reassembles a UDP message from.
+const gelfMaxChunks = 128
+
+// gelfExporter sends one GELF 1.1 message per container to Graylog, with the
+// container and its stats as additional fields such as _container_name and
+// _cpu_pct. Over UDP messages are compressed and chunked; over TCP they're
+// null delimited and uncompressed, as Graylog expects.
+type gelfExporter struct {
+	network     string
+	address     string
+	compression string
+	chunkSize   int
+	host        string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newGELFExporter() (Exporter, error) {
+	raw := os.Getenv("gelf_address")
+	if raw == "" {
+		return nil, errors.New("gelf_address is required")
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "udp" && u.Scheme != "tcp") {
+		return nil, fmt.Errorf("invalid gelf_address %q, expected udp://host:port or tcp://host:port", raw)
+	}
+	e := &gelfExporter{network: u.Scheme, address: u.Host, compression: "gzip", chunkSize: 1420}
+	if u.Port() == "" {
+		e.address = net.JoinHostPort(u.Hostname(), "12201")
+	}
+	switch v := os.Getenv("gelf_compression"); v {
+	case "":
+	case "gzip", "zlib", "none":
+		e.compression = v
+	default:
+		return nil, fmt.Errorf("invalid gelf_compression %q", v)
+	}
+	if e.network == "tcp" {
+		e.compression = "none"
+	}
+	if v := os.Getenv("gelf_chunk_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 100 || n > 8192 {
+			return nil, fmt.Errorf("invalid gelf_chunk_size %q, expected 100 to 8192", v)
+		}
+		e.chunkSize = n
+	}
+	e.host, _ = os.Hostname()
+	return e, nil
+}
+
+func (e *gelfExporter) Name() string { return "gelf" }
+
+func (e *gelfExporter) Export(samples []sample) error {
+	now := float64(time.Now().UnixNano()/int64(time.Millisecond)) / 1000
+	messages := make([][]byte, 0, len(samples))
+	for _, s := range samples {
+		labels := containerLabels(s)
+		name := labels["name"]
+		if name == "" {
+			name = s.ID
+		}
+		m := map[string]interface{}{
+			"version":         "1.1",
+			"host":            e.host,
+			"short_message":   "stats of " + name,
+			"timestamp":       now,
+			"level":           6,
+			"_container_id":   containerKey(s),
+			"_container_name": labels["name"],
+			"_image":          labels["image"],
+		}
+		for k, v := range labels {
+			if strings.HasPrefix(k, "label_") {
+				m["_"+k] = v
+			}
+		}
+		for _, r := range sortedReadings(s.Values) {
+			if !finite(s.Values[r]) {
+				continue
+			}
+			m["_"+strings.ToLower(r)] = s.Values[r]
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		if b, err = e.compress(b); err != nil {
+			return err
+		}
+		messages = append(messages, b)
+	}
+	return e.send(messages)
+}
+
+func (e *gelfExporter) compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch e.compression {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "zlib":
+		w = zlib.NewWriter(&buf)
+	default:
+		return b, nil
+	}
+	w.Write(b)
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (e *gelfExporter) send(messages [][]byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn == nil {
+		conn, err := net.DialTimeout(e.network, e.address, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		e.conn = conn
+	}
+
+	e.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	for _, m := range messages {
+		var err error
+		if e.network == "tcp" {
+			_, err = e.conn.Write(append(m, 0))
+		} else {
+			err = e.writeChunked(m)
+		}
+		if err != nil {
+			e.conn.Close()
+			e.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// writeChunked sends a UDP message as is when it fits a chunk, otherwise as
+// GELF chunks sharing a random message ID.
+func (e *gelfExporter) writeChunked(m []byte) error {
+	if len(m) <= e.chunkSize {
+		_, err := e.conn.Write(m)
+		return err
+	}
+	const header = 12 // magic, message ID, sequence number and count
+	body := e.chunkSize - header
+	count := (len(m) + body - 1) / body
+	if count > gelfMaxChunks {
+		return fmt.Errorf("gelf message of %d bytes needs more than %d chunks", len(m), gelfMaxChunks)
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * body
+		if end > len(m) {
+			end = len(m)
+		}
+		chunk := append([]byte{0x1e, 0x0f}, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunk = append(chunk, m[i*body:end]...)
+		if _, err := e.conn.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *gelfExporter) Check(ctx context.Context) error {
+	if e.network == "udp" {
+		return nil
+	}
+	return dialCheck(ctx, e.address)
+}
+
+func (e *gelfExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn != nil {
+		e.conn.Close()
+		e.conn = nil
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// graphiteMaxBuffered bounds the lines kept while carbon is unreachable.
+const graphiteMaxBuffered = 100000
+
+// graphiteExporter writes <prefix>.<host>.<container>.<metric> value timestamp
+// lines to carbon over TCP. Lines are buffered and written every
+// graphite_flush_interval, or every tick when unset. When carbon goes away
+// the connection is dropped, lines stay buffered up to a bound and the next
+// flush reconnects.
+type graphiteExporter struct {
+	address  string
+	prefix   string
+	host     string
+	interval time.Duration
+
+	mu      sync.Mutex
+	conn    net.Conn
+	lines   []string
+	dropped int
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func newGraphiteExporter() (Exporter, error) {
+	address := os.Getenv("graphite_address")
+	if address == "" {
+		return nil, errors.New("graphite_address is required")
+	}
+	e := &graphiteExporter{address: address, prefix: os.Getenv("graphite_prefix")}
+	if e.prefix == "" {
+		e.prefix = "docker"
+	}
+	host, _ := os.Hostname()
+	e.host = graphiteNode(host)
+
+	if v := os.Getenv("graphite_flush_interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid graphite_flush_interval %q", v)
+		}
+		e.interval = d
+		e.stop, e.stopped = make(chan struct{}), make(chan struct{})
+		go e.run()
+	}
+	return e, nil
+}
+
+func (e *graphiteExporter) Name() string { return "graphite" }
+
+func (e *graphiteExporter) Export(samples []sample) error {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	e.mu.Lock()
+	for _, s := range samples {
+		labels := containerLabels(s)
+		name := labels["name"]
+		if name == "" {
+			name = s.ID
+		}
+		path := e.prefix + "." + e.host + "." + graphiteNode(name) + "."
+		for _, r := range sortedReadings(s.Values) {
+			e.lines = append(e.lines, path+strings.ToLower(r)+" "+strconv.FormatFloat(s.Values[r], 'f', -1, 64)+" "+ts+"\n")
+		}
+	}
+	if over := len(e.lines) - graphiteMaxBuffered; over > 0 {
+		e.lines = e.lines[over:]
+		e.dropped += over
+	}
+	e.mu.Unlock()
+
+	if e.interval == 0 {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *graphiteExporter) run() {
+	defer close(e.stopped)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+			if err := e.flush(); err != nil {
+				logErrorExporting(e.Name(), err)
+			}
+		}
+	}
+}
+
+// flush writes the buffered lines, keeping them for the next flush when carbon
+// can't be reached.
+func (e *graphiteExporter) flush() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.dropped > 0 {
+		logrus.WithFields(logrus.Fields{"output": e.Name(), "dropped": e.dropped}).Warn("graphite buffer full, dropped the oldest lines")
+		e.dropped = 0
+	}
+	if len(e.lines) == 0 {
+		return nil
+	}
+	if e.conn == nil {
+		conn, err := net.DialTimeout("tcp", e.address, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		e.conn = conn
+	}
+
+	var buf bytes.Buffer
+	for _, l := range e.lines {
+		buf.WriteString(l)
+	}
+	e.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := e.conn.Write(buf.Bytes()); err != nil {
+		e.conn.Close()
+		e.conn = nil
+		return err
+	}
+	e.lines = e.lines[:0]
+	return nil
+}
+
+func (e *graphiteExporter) Check(ctx context.Context) error {
+	return dialCheck(ctx, e.address)
+}
+
+func (e *graphiteExporter) Close() error {
+	if e.interval > 0 {
+		close(e.stop)
+		<-e.stopped
+	}
+	err := e.flush()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn != nil {
+		e.conn.Close()
+		e.conn = nil
+	}
+	return err
+}
+
+// graphiteNode keeps a name to a single node of the metric path.
+func graphiteNode(name string) string {
+	return strings.NewReplacer(".", "_", " ", "_", "/", "_").Replace(strings.TrimPrefix(name, "/"))
+}
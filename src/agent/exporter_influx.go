@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// influxExporter writes one line protocol point per container and tick to
+// InfluxDB. With influx_token set it talks to the v2 API (influx_org,
+// influx_bucket), otherwise to the v1 API (influx_database, and optionally
+// influx_username and influx_password). Container ID, name, image and labels
+// become tags; the raw readings become fields.
+type influxExporter struct {
+	url         string
+	token       string
+	measurement string
+	client      *http.Client
+}
+
+func newInfluxExporter() (Exporter, error) {
+	base := strings.TrimSuffix(os.Getenv("influx_url"), "/")
+	if base == "" {
+		return nil, errors.New("influx_url is required")
+	}
+	e := &influxExporter{
+		token:       os.Getenv("influx_token"),
+		measurement: os.Getenv("influx_measurement"),
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+	if e.measurement == "" {
+		e.measurement = "docker_container"
+	}
+
+	q := url.Values{"precision": {"ns"}}
+	if e.token != "" {
+		org, bucket := os.Getenv("influx_org"), os.Getenv("influx_bucket")
+		if org == "" || bucket == "" {
+			return nil, errors.New("influx_org and influx_bucket are required with influx_token")
+		}
+		q.Set("org", org)
+		q.Set("bucket", bucket)
+		e.url = base + "/api/v2/write?" + q.Encode()
+	} else {
+		db := os.Getenv("influx_database")
+		if db == "" {
+			return nil, errors.New("influx_database is required without influx_token")
+		}
+		q.Set("db", db)
+		if u := os.Getenv("influx_username"); u != "" {
+			q.Set("u", u)
+			q.Set("p", os.Getenv("influx_password"))
+		}
+		e.url = base + "/write?" + q.Encode()
+	}
+	return e, nil
+}
+
+func (e *influxExporter) Name() string { return "influx" }
+
+func (e *influxExporter) Export(samples []sample) error {
+	var body bytes.Buffer
+	now := time.Now()
+	for _, s := range samples {
+		writeInfluxPoint(&body, e.measurement, s, now)
+	}
+	if body.Len() == 0 {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if e.token != "" {
+		req.Header.Set("Authorization", "Token "+e.token)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("influx responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (e *influxExporter) Check(ctx context.Context) error {
+	address, err := urlAddress(e.url)
+	if err != nil {
+		return err
+	}
+	return dialCheck(ctx, address)
+}
+
+func (e *influxExporter) Close() error { return nil }
+
+// writeInfluxPoint appends the line protocol point of a sample, tags and
+// fields sorted by key. Line protocol has no NaN or infinity and InfluxDB
+// rejects a point without fields along with the rest of the write, so those
+// readings are left out and a sample with none left writes nothing.
+func writeInfluxPoint(buf *bytes.Buffer, measurement string, s sample, t time.Time) {
+	var fields []string
+	for _, k := range sortedReadings(s.Values) {
+		if finite(s.Values[k]) {
+			fields = append(fields, k)
+		}
+	}
+	if len(fields) == 0 {
+		return
+	}
+
+	tags := map[string]string{}
+	for k, v := range s.Labels {
+		tags[k] = v
+	}
+	for k, v := range containerLabels(s) {
+		if !strings.HasPrefix(k, "label_") {
+			tags[k] = v
+		}
+	}
+
+	buf.WriteString(influxEscaper.Replace(measurement))
+	for _, k := range sortedKeys(tags) {
+		if tags[k] == "" {
+			continue
+		}
+		buf.WriteByte(',')
+		buf.WriteString(influxEscaper.Replace(k))
+		buf.WriteByte('=')
+		buf.WriteString(influxEscaper.Replace(tags[k]))
+	}
+
+	for i, k := range fields {
+		if i == 0 {
+			buf.WriteByte(' ')
+		} else {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(influxEscaper.Replace(k))
+		buf.WriteByte('=')
+		buf.WriteString(strconv.FormatFloat(s.Values[k], 'f', -1, 64))
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.FormatInt(t.UnixNano(), 10))
+	buf.WriteByte('\n')
+}
+
+// influxEscaper escapes measurements, tag keys, tag values and field keys.
+var influxEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
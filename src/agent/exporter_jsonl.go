@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+// jsonlExporter appends the records to the JSON Lines file at jsonl_path, one
+// per line, apart from the agent's own logs so the file can be tailed or
+// shipped on its own. With jsonl_records=tick one line is written per tick.
+// The file is rotated as set by jsonl_max_size, jsonl_rotate_interval and
+// jsonl_max_files, and rotated files are gzipped with jsonl_compress=true.
+type jsonlExporter struct {
+	records string
+	schema  string
+
+	mu   sync.Mutex
+	file *rotatingFile
+}
+
+func newJSONLExporter() (Exporter, error) {
+	file, err := newRotatingFile("jsonl")
+	if err != nil {
+		return nil, err
+	}
+	records, err := outputRecords("jsonl")
+	if err != nil {
+		return nil, err
+	}
+	schema, err := outputSchema("jsonl")
+	if err != nil {
+		return nil, err
+	}
+	return &jsonlExporter{records: records, schema: schema, file: file}, nil
+}
+
+func (e *jsonlExporter) Name() string { return "jsonl" }
+
+func (e *jsonlExporter) exportsRecords() {}
+
+func (e *jsonlExporter) perTick() bool { return e.records == recordsTick }
+
+func (e *jsonlExporter) Export(samples []sample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+	payloads := make([]interface{}, 0, len(samples))
+	if e.records == recordsTick {
+		payloads = append(payloads, tickRecord(samples, e.schema))
+	} else {
+		for _, s := range samples {
+			payloads = append(payloads, s.record(e.schema))
+		}
+	}
+
+	var lines bytes.Buffer
+	for _, p := range payloads {
+		data, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		lines.Write(data)
+		lines.WriteByte('\n')
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.file.Write(lines.Bytes())
+	return err
+}
+
+func (e *jsonlExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
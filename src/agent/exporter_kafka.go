@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// kafkaMaxBuffered bounds the messages waiting for the next flush.
+const kafkaMaxBuffered = 10000
+
+// kafkaExporter publishes one message per record to kafka_topic, keyed by the
+// container ID so a container's records stay on one partition. Messages are
+// queued and produced in the background every kafka_flush_interval or once
+// kafka_batch_size are waiting; failed deliveries are logged and dropped.
+// With kafka_encoding=avro records are serialized for the Confluent Schema
+// Registry at kafka_schema_registry_url.
+type kafkaExporter struct {
+	brokers  []string
+	topic    string
+	acks     int16
+	tls      *tls.Config
+	user     string
+	password string
+	enc      encoder
+	avro     *avroEncoder
+	schema   string
+	batch    int
+	interval time.Duration
+
+	mu       sync.Mutex
+	queue    []kafkaMessage
+	dropped  int
+	flushNow chan struct{}
+	stop     chan struct{}
+	stopped  chan struct{}
+
+	// Only used by the flusher.
+	partitions kafkaTopic
+	conns      map[string]*kafkaConn
+}
+
+func newKafkaExporter() (Exporter, error) {
+	var brokers []string
+	for _, b := range strings.Split(os.Getenv("kafka_brokers"), ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			brokers = append(brokers, b)
+		}
+	}
+	if len(brokers) == 0 {
+		return nil, errors.New("kafka_brokers is required")
+	}
+	e := &kafkaExporter{
+		brokers:  brokers,
+		topic:    os.Getenv("kafka_topic"),
+		acks:     1,
+		user:     os.Getenv("kafka_sasl_username"),
+		batch:    500,
+		interval: time.Second,
+		flushNow: make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+		conns:    map[string]*kafkaConn{},
+	}
+	if e.topic == "" {
+		e.topic = "docker-stats"
+	}
+
+	switch v := os.Getenv("kafka_acks"); v {
+	case "", "1":
+	case "0":
+		e.acks = 0
+	case "all", "-1":
+		e.acks = -1
+	default:
+		return nil, fmt.Errorf("invalid kafka_acks %q", v)
+	}
+	if v := os.Getenv("kafka_batch_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid kafka_batch_size %q", v)
+		}
+		e.batch = n
+	}
+	if v := os.Getenv("kafka_flush_interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid kafka_flush_interval %q", v)
+		}
+		e.interval = d
+	}
+
+	var err error
+	if e.user != "" {
+		if e.password, err = secretSetting("kafka_sasl_password"); err != nil {
+			return nil, fmt.Errorf("reading kafka_sasl_password_file: %v", err)
+		}
+	}
+	if e.tls, err = outputTLS("kafka", ""); err != nil {
+		return nil, err
+	}
+	if os.Getenv("kafka_encoding") == "avro" {
+		if e.avro, err = newAvroEncoder("kafka", e.topic); err != nil {
+			return nil, err
+		}
+	} else if e.enc, err = outputEncoder("kafka"); err != nil {
+		return nil, err
+	}
+	if e.schema, err = outputSchema("kafka"); err != nil {
+		return nil, err
+	}
+	go e.run()
+	return e, nil
+}
+
+func (e *kafkaExporter) Name() string { return "kafka" }
+
+func (e *kafkaExporter) exportsRecords() {}
+
+// Export queues the records; they're produced by the flusher.
+func (e *kafkaExporter) Export(samples []sample) error {
+	now := time.Now()
+	messages := make([]kafkaMessage, 0, len(samples))
+	for _, s := range samples {
+		var value []byte
+		var err error
+		if e.avro != nil {
+			value, err = e.avro.encode(s, now)
+		} else {
+			value, err = e.enc.encode(s, e.schema)
+		}
+		if err != nil {
+			return err
+		}
+		messages = append(messages, kafkaMessage{key: []byte(containerKey(s)), value: value, time: now})
+	}
+
+	e.mu.Lock()
+	e.queue = append(e.queue, messages...)
+	if over := len(e.queue) - kafkaMaxBuffered; over > 0 {
+		e.queue = e.queue[over:]
+		e.dropped += over
+	}
+	full := len(e.queue) >= e.batch
+	e.mu.Unlock()
+
+	if full {
+		select {
+		case e.flushNow <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (e *kafkaExporter) run() {
+	defer close(e.stopped)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+		case <-e.flushNow:
+		}
+		e.flush()
+	}
+}
+
+// flush produces the queued messages, batch by batch.
+func (e *kafkaExporter) flush() {
+	for {
+		e.mu.Lock()
+		if e.dropped > 0 {
+			logrus.WithFields(logrus.Fields{"output": e.Name(), "dropped": e.dropped}).Warn("kafka queue full, dropped the oldest messages")
+			e.dropped = 0
+		}
+		n := len(e.queue)
+		if n > e.batch {
+			n = e.batch
+		}
+		messages := e.queue[:n:n]
+		e.queue = e.queue[n:]
+		e.mu.Unlock()
+
+		if len(messages) == 0 {
+			return
+		}
+		if undelivered, err := e.deliver(messages); err != nil {
+			logrus.WithFields(logrus.Fields{"output": e.Name(), "error": err, "messages": undelivered}).Error("failed to deliver stats to kafka")
+		}
+	}
+}
+
+// deliver produces the messages to the leaders of their partitions and
+// returns how many couldn't be delivered. Any failure drops the connections
+// and the partition leaders so the next flush starts afresh.
+func (e *kafkaExporter) deliver(messages []kafkaMessage) (int, error) {
+	if e.partitions.leaders == nil {
+		if err := e.refresh(); err != nil {
+			return len(messages), err
+		}
+	}
+
+	byPartition := map[int32][]kafkaMessage{}
+	for _, m := range messages {
+		p := kafkaPartition(m.key, len(e.partitions.leaders))
+		byPartition[p] = append(byPartition[p], m)
+	}
+	undelivered := len(messages)
+	for partition, batch := range byPartition {
+		conn, err := e.leader(partition)
+		if err == nil {
+			err = conn.produce(e.topic, partition, e.acks, batch)
+		}
+		if err != nil {
+			e.reset()
+			return undelivered, err
+		}
+		undelivered -= len(batch)
+	}
+	return 0, nil
+}
+
+// refresh looks up the partition leaders from the first bootstrap broker that
+// answers.
+func (e *kafkaExporter) refresh() error {
+	var err error
+	for _, address := range e.brokers {
+		var conn *kafkaConn
+		if conn, err = e.conn(address); err != nil {
+			continue
+		}
+		var t kafkaTopic
+		if t, err = conn.metadata(e.topic); err == nil {
+			e.partitions = t
+			return nil
+		}
+		e.reset()
+	}
+	return err
+}
+
+func (e *kafkaExporter) leader(partition int32) (*kafkaConn, error) {
+	address, ok := e.partitions.brokers[e.partitions.leaders[partition]]
+	if !ok {
+		return nil, kafkaError(5)
+	}
+	return e.conn(address)
+}
+
+func (e *kafkaExporter) conn(address string) (*kafkaConn, error) {
+	if c, ok := e.conns[address]; ok {
+		return c, nil
+	}
+	c, err := dialKafka(address, e.tls, e.user, e.password)
+	if err != nil {
+		return nil, err
+	}
+	e.conns[address] = c
+	return c, nil
+}
+
+func (e *kafkaExporter) reset() {
+	for address, c := range e.conns {
+		c.Close()
+		delete(e.conns, address)
+	}
+	e.partitions = kafkaTopic{}
+}
+
+func (e *kafkaExporter) Check(ctx context.Context) error {
+	var err error
+	for _, address := range e.brokers {
+		if err = dialCheck(ctx, address); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// Close produces what's still queued.
+func (e *kafkaExporter) Close() error {
+	close(e.stop)
+	<-e.stopped
+	e.flush()
+	e.reset()
+	return nil
+}
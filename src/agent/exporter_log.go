@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// logExporter writes one log line per container, the agent's original output.
+// On dense hosts log_sample_every and log_sample_percent thin out the lines;
+// other outputs still receive every sample. With log_records=tick the whole tick
+// is written as a single line instead and sampling doesn't apply.
+// log_max_field_length caps string fields such as labels so lines stay within
+// the limits of log pipelines; other outputs get the full values.
+type logExporter struct {
+	records  string
+	schema   string
+	maxField int
+	every    uint64
+	percent  float64
+
+	mu   sync.Mutex
+	seen uint64
+}
+
+func newLogExporter() (Exporter, error) {
+	records, err := outputRecords("log")
+	if err != nil {
+		return nil, err
+	}
+	schema, err := outputSchema("log")
+	if err != nil {
+		return nil, err
+	}
+	e := &logExporter{records: records, schema: schema, every: 1, percent: 100}
+	if v := os.Getenv("log_sample_every"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid log_sample_every %q", v)
+		}
+		e.every = n
+	}
+	if v := os.Getenv("log_max_field_length"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid log_max_field_length %q", v)
+		}
+		e.maxField = n
+	}
+	if v := os.Getenv("log_sample_percent"); v != "" {
+		p, err := strconv.ParseFloat(v, 64)
+		if err != nil || p < 0 || p > 100 {
+			return nil, fmt.Errorf("invalid log_sample_percent %q", v)
+		}
+		e.percent = p
+	}
+	return e, nil
+}
+
+func (e *logExporter) Name() string { return "log" }
+
+func (e *logExporter) exportsRecords() {}
+
+func (e *logExporter) perTick() bool { return e.records == recordsTick }
+
+func (e *logExporter) Export(samples []sample) error {
+	if e.records == recordsTick {
+		logrus.WithFields(e.truncate(tickRecord(samples, e.schema))).Info("tick")
+		return nil
+	}
+	for _, s := range samples {
+		if e.sampled() {
+			logrus.WithFields(e.truncate(s.record(e.schema))).Info("stats")
+		}
+	}
+	return nil
+}
+
+// sampled decides whether the next line is written: every Nth line is kept,
+// then of those the configured percentage at random.
+func (e *logExporter) sampled() bool {
+	e.mu.Lock()
+	n := e.seen
+	e.seen++
+	e.mu.Unlock()
+
+	if n%e.every != 0 {
+		return false
+	}
+	return e.percent >= 100 || rand.Float64()*100 < e.percent
+}
+
+func (e *logExporter) truncate(fields logrus.Fields) logrus.Fields {
+	if e.maxField == 0 {
+		return fields
+	}
+	return truncateFields(fields, e.maxField)
+}
+
+func (e *logExporter) Close() error { return nil }
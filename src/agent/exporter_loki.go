@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	lokiMaxBuffered = 100000 // lines kept while Loki is unreachable
+	lokiRetries     = 5
+)
+
+// lokiExporter pushes one log line per record to Loki, labelled with the job,
+// host, container name and image only, plus the Docker labels listed in
+// loki_labels, so the number of streams stays bounded; the container ID is
+// part of the line. Lines are batched and pushed in the background; pushes
+// refused with 429 or 503 are retried with a growing backoff.
+type lokiExporter struct {
+	url      string
+	username string
+	password string
+	tenant   string
+	labels   []string
+	host     string
+	schema   string
+	batch    int
+	interval time.Duration
+	client   *http.Client
+
+	mu       sync.Mutex
+	entries  []lokiEntry
+	dropped  int
+	flushNow chan struct{}
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+type lokiEntry struct {
+	labels map[string]string
+	ts     time.Time
+	line   string
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func newLokiExporter() (Exporter, error) {
+	url := strings.TrimRight(os.Getenv("loki_url"), "/")
+	if url == "" {
+		return nil, errors.New("loki_url is required")
+	}
+	e := &lokiExporter{
+		url:      url + "/loki/api/v1/push",
+		username: os.Getenv("loki_username"),
+		tenant:   os.Getenv("loki_tenant_id"),
+		batch:    1000,
+		interval: time.Second,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		flushNow: make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	for _, l := range strings.Split(os.Getenv("loki_labels"), ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			e.labels = append(e.labels, l)
+		}
+	}
+	if v := os.Getenv("loki_batch_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid loki_batch_size %q", v)
+		}
+		e.batch = n
+	}
+	if v := os.Getenv("loki_flush_interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid loki_flush_interval %q", v)
+		}
+		e.interval = d
+	}
+
+	var err error
+	if e.password, err = secretSetting("loki_password"); err != nil {
+		return nil, fmt.Errorf("reading loki_password_file: %v", err)
+	}
+	e.host, _ = os.Hostname()
+	if e.schema, err = outputSchema("loki"); err != nil {
+		return nil, err
+	}
+	go e.run()
+	return e, nil
+}
+
+func (e *lokiExporter) Name() string { return "loki" }
+
+func (e *lokiExporter) exportsRecords() {}
+
+// Export queues the lines; they're pushed by the flusher.
+func (e *lokiExporter) Export(samples []sample) error {
+	now := time.Now()
+	entries := make([]lokiEntry, 0, len(samples))
+	for _, s := range samples {
+		line, err := json.Marshal(s.record(e.schema))
+		if err != nil {
+			return err
+		}
+		entries = append(entries, lokiEntry{labels: e.streamLabels(s), ts: now, line: string(line)})
+	}
+
+	e.mu.Lock()
+	e.entries = append(e.entries, entries...)
+	if over := len(e.entries) - lokiMaxBuffered; over > 0 {
+		e.entries = e.entries[over:]
+		e.dropped += over
+	}
+	full := len(e.entries) >= e.batch
+	e.mu.Unlock()
+
+	if full {
+		select {
+		case e.flushNow <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// streamLabels is the bounded label set of a container's stream, or of a
+// kind of records.
+func (e *lokiExporter) streamLabels(s sample) map[string]string {
+	labels := containerLabels(s)
+	name := labels["name"]
+	if name == "" {
+		name = s.ID
+	}
+	stream := map[string]string{"job": "docker-stats", "host": e.host, "container": name}
+	if kind, ok := s.Fields["Record"].(string); ok {
+		// Volumes, images and the like get a stream per kind; only events
+		// name a container.
+		stream = map[string]string{"job": "docker-stats", "host": e.host, "record": kind}
+		if labels["name"] != "" {
+			stream["container"] = labels["name"]
+		}
+	}
+	if labels["image"] != "" {
+		stream["image"] = labels["image"]
+	}
+	for _, l := range e.labels {
+		if v, ok := s.Labels[l]; ok {
+			stream[sanitizeMetricName(l)] = v
+		}
+	}
+	return stream
+}
+
+func (e *lokiExporter) run() {
+	defer close(e.stopped)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+		case <-e.flushNow:
+		}
+		if err := e.flush(); err != nil {
+			logErrorExporting(e.Name(), err)
+		}
+	}
+}
+
+// flush pushes the queued lines batch by batch. A batch that can't be pushed
+// is dropped, the lines after it stay queued for the next flush.
+func (e *lokiExporter) flush() error {
+	for {
+		e.mu.Lock()
+		if e.dropped > 0 {
+			logrus.WithFields(logrus.Fields{"output": e.Name(), "dropped": e.dropped}).Warn("loki buffer full, dropped the oldest lines")
+			e.dropped = 0
+		}
+		n := len(e.entries)
+		if n > e.batch {
+			n = e.batch
+		}
+		batch := e.entries[:n:n]
+		e.entries = e.entries[n:]
+		e.mu.Unlock()
+
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := e.push(batch); err != nil {
+			return err
+		}
+	}
+}
+
+// push sends a batch, grouped by stream, retrying while Loki is overloaded.
+func (e *lokiExporter) push(batch []lokiEntry) error {
+	streams := map[string]*lokiStream{}
+	var order []string
+	for _, entry := range batch {
+		var key string
+		for _, k := range sortedKeys(entry.labels) {
+			key += k + "=" + entry.labels[k] + ","
+		}
+		st, ok := streams[key]
+		if !ok {
+			st = &lokiStream{Stream: entry.labels}
+			streams[key] = st
+			order = append(order, key)
+		}
+		st.Values = append(st.Values, [2]string{strconv.FormatInt(entry.ts.UnixNano(), 10), entry.line})
+	}
+	list := make([]*lokiStream, 0, len(order))
+	for _, key := range order {
+		list = append(list, streams[key])
+	}
+	body, err := json.Marshal(map[string]interface{}{"streams": list})
+	if err != nil {
+		return err
+	}
+
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		retry, wait, err := e.post(body)
+		if !retry || attempt == lokiRetries {
+			return err
+		}
+		if wait < backoff {
+			wait = backoff
+		}
+		select {
+		case <-e.stop:
+			return err
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+// post sends one push request, telling whether and when it may be retried.
+func (e *lokiExporter) post(body []byte) (bool, time.Duration, error) {
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return false, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.username != "" {
+		req.SetBasicAuth(e.username, e.password)
+	}
+	if e.tenant != "" {
+		req.Header.Set("X-Scope-OrgID", e.tenant)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return false, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return false, 0, nil
+	}
+	msg, _ := ioutil.ReadAll(resp.Body)
+	err = fmt.Errorf("loki responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return false, 0, err
+	}
+	seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+	return true, time.Duration(seconds) * time.Second, err
+}
+
+func (e *lokiExporter) Check(ctx context.Context) error {
+	address, err := urlAddress(e.url)
+	if err != nil {
+		return err
+	}
+	return dialCheck(ctx, address)
+}
+
+// Close pushes what's still queued.
+func (e *lokiExporter) Close() error {
+	close(e.stop)
+	<-e.stopped
+	return e.flush()
+}
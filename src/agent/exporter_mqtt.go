@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// mqttExporter publishes one message per record to a topic built from
+// mqtt_topic, docker-stats/{host}/{container} by default, with the QoS and
+// retain flag of mqtt_qos and mqtt_retain.
+type mqttExporter struct {
+	address string
+	opts    mqttOptions
+	topic   string
+	host    string
+	qos     byte
+	retain  bool
+	enc     encoder
+	schema  string
+
+	mu   sync.Mutex
+	conn *mqttConn
+}
+
+// mqttLevel keeps a value to a single topic level.
+var mqttLevel = strings.NewReplacer("/", "_", "+", "_", "#", "_")
+
+func newMQTTExporter() (Exporter, error) {
+	raw := os.Getenv("mqtt_broker")
+	if raw == "" {
+		return nil, errors.New("mqtt_broker is required")
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid mqtt_broker %q, expected tcp://host:port or ssl://host:port", raw)
+	}
+	e := &mqttExporter{address: u.Host, topic: os.Getenv("mqtt_topic")}
+	port := "1883"
+	switch u.Scheme {
+	case "tcp", "mqtt":
+	case "ssl", "tls", "mqtts":
+		port = "8883"
+	default:
+		return nil, fmt.Errorf("invalid mqtt_broker %q, expected tcp://host:port or ssl://host:port", raw)
+	}
+	if u.Port() == "" {
+		e.address = net.JoinHostPort(u.Hostname(), port)
+	}
+	if e.topic == "" {
+		e.topic = "docker-stats/{host}/{container}"
+	}
+	host, _ := os.Hostname()
+	e.host = mqttLevel.Replace(host)
+
+	switch v := os.Getenv("mqtt_version"); v {
+	case "", "3.1.1", "4":
+		e.opts.version = 4
+	case "5", "5.0":
+		e.opts.version = 5
+	default:
+		return nil, fmt.Errorf("invalid mqtt_version %q, expected 3.1.1 or 5", v)
+	}
+	switch v := os.Getenv("mqtt_qos"); v {
+	case "", "0":
+	case "1", "2":
+		e.qos = v[0] - '0'
+	default:
+		return nil, fmt.Errorf("invalid mqtt_qos %q", v)
+	}
+	if e.retain, err = boolSetting("mqtt_retain"); err != nil {
+		return nil, err
+	}
+
+	e.opts.clientID = os.Getenv("mqtt_client_id")
+	if e.opts.clientID == "" {
+		e.opts.clientID = "docker-stats-" + e.host
+	}
+	e.opts.username = os.Getenv("mqtt_username")
+	if e.opts.password, err = secretSetting("mqtt_password"); err != nil {
+		return nil, fmt.Errorf("reading mqtt_password_file: %v", err)
+	}
+	if e.opts.tls, err = outputTLS("mqtt", ""); err != nil {
+		return nil, err
+	}
+	if e.opts.tls == nil && port == "8883" {
+		e.opts.tls = &tls.Config{}
+	}
+	if e.enc, err = outputEncoder("mqtt"); err != nil {
+		return nil, err
+	}
+	if e.schema, err = outputSchema("mqtt"); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *mqttExporter) Name() string { return "mqtt" }
+
+func (e *mqttExporter) exportsRecords() {}
+
+func (e *mqttExporter) Export(samples []sample) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn == nil {
+		conn, err := dialMQTT(e.address, e.opts)
+		if err != nil {
+			return err
+		}
+		e.conn = conn
+	}
+	if err := e.publish(samples); err != nil {
+		e.conn.Close()
+		e.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (e *mqttExporter) publish(samples []sample) error {
+	var waits []func() error
+	for _, s := range samples {
+		payload, err := e.enc.encode(s, e.schema)
+		if err != nil {
+			return err
+		}
+		wait, err := e.conn.publish(e.topicOf(s), payload, e.qos, e.retain)
+		if err != nil {
+			return err
+		}
+		waits = append(waits, wait)
+	}
+	for _, wait := range waits {
+		if err := wait(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// topicOf expands the {host}, {container} and {id} of the topic template.
+func (e *mqttExporter) topicOf(s sample) string {
+	name := containerLabels(s)["name"]
+	if name == "" {
+		name = s.ID
+	}
+	return strings.NewReplacer(
+		"{host}", e.host,
+		"{container}", mqttLevel.Replace(name),
+		"{id}", containerKey(s),
+	).Replace(e.topic)
+}
+
+func (e *mqttExporter) Check(ctx context.Context) error {
+	return dialCheck(ctx, e.address)
+}
+
+func (e *mqttExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn != nil {
+		e.conn.Close()
+		e.conn = nil
+	}
+	return nil
+}
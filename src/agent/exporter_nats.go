@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// natsExporter publishes one message per record to a subject built from
+// nats_subject, docker.stats.{host}.{container} by default. With
+// nats_jetstream=true every message must be acknowledged by the JetStream
+// stream capturing the subject.
+type natsExporter struct {
+	address   string
+	opts      natsOptions
+	subject   string
+	host      string
+	jetstream bool
+	enc       encoder
+	schema    string
+
+	mu   sync.Mutex
+	conn *natsConn
+}
+
+// natsToken keeps a value to a single subject token.
+var natsToken = strings.NewReplacer(".", "_", " ", "_", "*", "_", ">", "_", "/", "_")
+
+func newNATSExporter() (Exporter, error) {
+	raw := os.Getenv("nats_url")
+	if raw == "" {
+		return nil, errors.New("nats_url is required")
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "nats" && u.Scheme != "tls") || u.Host == "" {
+		return nil, fmt.Errorf("invalid nats_url %q, expected nats://host:port or tls://host:port", raw)
+	}
+	e := &natsExporter{address: u.Host, subject: os.Getenv("nats_subject")}
+	if u.Port() == "" {
+		e.address = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if e.subject == "" {
+		e.subject = "docker.stats.{host}.{container}"
+	}
+	host, _ := os.Hostname()
+	e.host = natsToken.Replace(host)
+
+	e.opts.user = os.Getenv("nats_user")
+	if u.User != nil {
+		e.opts.user = u.User.Username()
+		e.opts.password, _ = u.User.Password()
+	}
+	if e.opts.password == "" {
+		if e.opts.password, err = secretSetting("nats_password"); err != nil {
+			return nil, fmt.Errorf("reading nats_password_file: %v", err)
+		}
+	}
+	if e.opts.token, err = secretSetting("nats_token"); err != nil {
+		return nil, fmt.Errorf("reading nats_token_file: %v", err)
+	}
+	if e.opts.tls, err = outputTLS("nats", ""); err != nil {
+		return nil, err
+	}
+	if e.opts.tls == nil && u.Scheme == "tls" {
+		e.opts.tls = &tls.Config{}
+	}
+	if e.jetstream, err = boolSetting("nats_jetstream"); err != nil {
+		return nil, err
+	}
+	if e.enc, err = outputEncoder("nats"); err != nil {
+		return nil, err
+	}
+	if e.schema, err = outputSchema("nats"); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *natsExporter) Name() string { return "nats" }
+
+func (e *natsExporter) exportsRecords() {}
+
+func (e *natsExporter) Export(samples []sample) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn == nil {
+		conn, err := dialNATS(e.address, e.opts)
+		if err != nil {
+			return err
+		}
+		e.conn = conn
+	}
+	if err := e.publish(samples); err != nil {
+		e.conn.Close()
+		e.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (e *natsExporter) publish(samples []sample) error {
+	var waits []func() error
+	for _, s := range samples {
+		payload, err := e.enc.encode(s, e.schema)
+		if err != nil {
+			return err
+		}
+		subject := e.subjectOf(s)
+		if !e.jetstream {
+			if err := e.conn.publish(subject, payload); err != nil {
+				return err
+			}
+			continue
+		}
+		wait, err := e.conn.publishAck(subject, payload)
+		if err != nil {
+			return err
+		}
+		waits = append(waits, wait)
+	}
+	if err := e.conn.flush(); err != nil {
+		return err
+	}
+	for _, wait := range waits {
+		if err := wait(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subjectOf expands the {host}, {container} and {id} of the subject pattern.
+func (e *natsExporter) subjectOf(s sample) string {
+	name := containerLabels(s)["name"]
+	if name == "" {
+		name = s.ID
+	}
+	return strings.NewReplacer(
+		"{host}", e.host,
+		"{container}", natsToken.Replace(name),
+		"{id}", containerKey(s),
+	).Replace(e.subject)
+}
+
+func (e *natsExporter) Check(ctx context.Context) error {
+	return dialCheck(ctx, e.address)
+}
+
+func (e *natsExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn != nil {
+		e.conn.Close()
+		e.conn = nil
+	}
+	return nil
+}
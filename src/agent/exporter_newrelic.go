@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Limits of the Metric API: a payload may be 1MB compressed, and attributes
+// have at most 255 byte names and 4096 byte values, 100 per metric.
+const (
+	newRelicMaxPayload   = 1 << 20
+	newRelicMaxName      = 255
+	newRelicMaxValue     = 4096
+	newRelicMaxAttribute = 100
+)
+
+// newRelicExporter submits every raw reading as a gauge such as
+// docker.container.cpu.pct to the New Relic Metric API, with the container's
+// ID, name, image and Docker labels as attributes. Metrics are split across
+// requests so each gzipped payload stays under the API's limit.
+type newRelicExporter struct {
+	url    string
+	key    string
+	host   string
+	client *http.Client
+}
+
+type newRelicMetric struct {
+	Name       string                 `json:"name"`
+	Type       string                 `json:"type"`
+	Value      float64                `json:"value"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+func newNewRelicExporter() (Exporter, error) {
+	key, err := secretSetting("newrelic_license_key")
+	if err != nil {
+		return nil, fmt.Errorf("reading newrelic_license_key_file: %v", err)
+	}
+	if key == "" {
+		return nil, errors.New("newrelic_license_key or newrelic_license_key_file is required")
+	}
+	e := &newRelicExporter{url: "https://metric-api.newrelic.com/metric/v1", key: key, client: &http.Client{Timeout: 10 * time.Second}}
+	switch region := os.Getenv("newrelic_region"); region {
+	case "", "us":
+	case "eu":
+		e.url = "https://metric-api.eu.newrelic.com/metric/v1"
+	default:
+		return nil, fmt.Errorf("unknown newrelic_region %q, expected us or eu", region)
+	}
+	e.host, _ = os.Hostname()
+	return e, nil
+}
+
+func (e *newRelicExporter) Name() string { return "newrelic" }
+
+func (e *newRelicExporter) Export(samples []sample) error {
+	var metrics []newRelicMetric
+	for _, s := range samples {
+		attributes := newRelicAttributes(s)
+		for _, r := range sortedReadings(s.Values) {
+			// Non-finite readings have no JSON encoding.
+			if !finite(s.Values[r]) {
+				continue
+			}
+			metrics = append(metrics, newRelicMetric{
+				Name:       "docker.container." + strings.ToLower(strings.Replace(r, "_", ".", -1)),
+				Type:       "gauge",
+				Value:      s.Values[r],
+				Attributes: attributes,
+			})
+		}
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+	return e.submit(metrics, time.Now().UnixNano()/int64(time.Millisecond))
+}
+
+// submit posts the metrics, halving the batch until its payload fits.
+func (e *newRelicExporter) submit(metrics []newRelicMetric, timestamp int64) error {
+	body, err := json.Marshal([]map[string]interface{}{{
+		"common":  map[string]interface{}{"timestamp": timestamp, "attributes": map[string]string{"host.name": e.host}},
+		"metrics": metrics,
+	}})
+	if err != nil {
+		return err
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(body)
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if gz.Len() > newRelicMaxPayload && len(metrics) > 1 {
+		half := len(metrics) / 2
+		if err := e.submit(metrics[:half], timestamp); err != nil {
+			return err
+		}
+		return e.submit(metrics[half:], timestamp)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.url, &gz)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Api-Key", e.key)
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("new relic responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (e *newRelicExporter) Check(ctx context.Context) error {
+	address, err := urlAddress(e.url)
+	if err != nil {
+		return err
+	}
+	return dialCheck(ctx, address)
+}
+
+func (e *newRelicExporter) Close() error { return nil }
+
+// newRelicAttributes describes a container, its Docker labels mapped to
+// label.<name> attributes within the API's limits.
+func newRelicAttributes(s sample) map[string]interface{} {
+	labels := containerLabels(s)
+	attributes := map[string]interface{}{"container.id": containerKey(s)}
+	if labels["name"] != "" {
+		attributes["container.name"] = labels["name"]
+	}
+	if labels["image"] != "" {
+		attributes["container.image.name"] = labels["image"]
+	}
+	for _, k := range sortedKeys(s.Labels) {
+		if len(attributes) == newRelicMaxAttribute {
+			break
+		}
+		name, value := "label."+k, s.Labels[k]
+		if len(name) > newRelicMaxName {
+			continue
+		}
+		if len(value) > newRelicMaxValue {
+			value = value[:newRelicMaxValue]
+		}
+		attributes[name] = value
+	}
+	return attributes
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	otlpHTTP = "http/protobuf"
+	otlpGRPC = "grpc"
+)
+
+// otlpExporter pushes the container metrics to an OpenTelemetry collector,
+// one resource per container. Over http/protobuf requests go to
+// <otlp_endpoint>/v1/metrics; over grpc the endpoint must be https since the
+// standard library only speaks HTTP/2 over TLS. With otlp_interval set the
+// latest samples are pushed on that interval instead of every tick.
+type otlpExporter struct {
+	url      string
+	protocol string
+	headers  map[string]string
+	host     string
+	client   *http.Client
+
+	interval time.Duration
+	mu       sync.Mutex
+	pending  map[string]sample
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+func newOTLPExporter() (Exporter, error) {
+	endpoint := strings.TrimSuffix(os.Getenv("otlp_endpoint"), "/")
+	if endpoint == "" {
+		return nil, errors.New("otlp_endpoint is required")
+	}
+	e := &otlpExporter{
+		protocol: otlpHTTP,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		pending:  map[string]sample{},
+	}
+	e.host, _ = os.Hostname()
+
+	switch v := os.Getenv("otlp_protocol"); v {
+	case "", otlpHTTP:
+		e.url = endpoint
+		if !strings.HasSuffix(e.url, "/v1/metrics") {
+			e.url += "/v1/metrics"
+		}
+	case otlpGRPC:
+		if !strings.HasPrefix(endpoint, "https://") {
+			return nil, errors.New("otlp_protocol=grpc needs an https otlp_endpoint")
+		}
+		e.protocol = otlpGRPC
+		e.url = endpoint + "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+	default:
+		return nil, fmt.Errorf("unknown otlp_protocol %q", v)
+	}
+
+	headers, err := parseHeaders(os.Getenv("otlp_headers"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid otlp_headers: %v", err)
+	}
+	e.headers = headers
+
+	if v := os.Getenv("otlp_interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid otlp_interval %q", v)
+		}
+		e.interval = d
+		e.stop, e.stopped = make(chan struct{}), make(chan struct{})
+		go e.run()
+	}
+	return e, nil
+}
+
+// parseHeaders reads a comma separated list of name=value pairs.
+func parseHeaders(v string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, pair := range splitList(v) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("%q is not name=value", pair)
+		}
+		headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return headers, nil
+}
+
+func (e *otlpExporter) Name() string { return "otlp" }
+
+func (e *otlpExporter) Export(samples []sample) error {
+	if e.interval == 0 {
+		return e.push(samples)
+	}
+	e.mu.Lock()
+	for _, s := range samples {
+		e.pending[s.ID] = s
+	}
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *otlpExporter) run() {
+	defer close(e.stopped)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+			if err := e.flush(); err != nil {
+				logErrorExporting(e.Name(), err)
+			}
+		}
+	}
+}
+
+func (e *otlpExporter) flush() error {
+	e.mu.Lock()
+	samples := make([]sample, 0, len(e.pending))
+	for _, s := range e.pending {
+		samples = append(samples, s)
+	}
+	e.pending = map[string]sample{}
+	e.mu.Unlock()
+
+	if len(samples) == 0 {
+		return nil
+	}
+	return e.push(samples)
+}
+
+func (e *otlpExporter) push(samples []sample) error {
+	body := otlpRequest(samples, e.host, time.Now())
+
+	contentType := "application/x-protobuf"
+	if e.protocol == otlpGRPC {
+		frame := make([]byte, 5, 5+len(body))
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(body)))
+		body = append(frame, body...)
+		contentType = "application/grpc"
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if e.protocol == otlpGRPC {
+		req.Header.Set("TE", "trailers")
+	}
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("otlp endpoint responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if e.protocol == otlpGRPC {
+		status := resp.Trailer.Get("Grpc-Status")
+		if status == "" {
+			status = resp.Header.Get("Grpc-Status")
+		}
+		if status != "" && status != "0" {
+			return fmt.Errorf("otlp export failed with grpc status %s: %s", status, resp.Trailer.Get("Grpc-Message"))
+		}
+	}
+	return nil
+}
+
+func (e *otlpExporter) Check(ctx context.Context) error {
+	address, err := urlAddress(e.url)
+	if err != nil {
+		return err
+	}
+	return dialCheck(ctx, address)
+}
+
+func (e *otlpExporter) Close() error {
+	if e.interval == 0 {
+		return nil
+	}
+	close(e.stop)
+	<-e.stopped
+	return e.flush()
+}
+
+// otlpRequest encodes an ExportMetricsServiceRequest with one resource per
+// container and a data point for each of its container metrics.
+func otlpRequest(samples []sample, host string, now time.Time) []byte {
+	var req protoWriter
+	for _, s := range samples {
+		var resource protoWriter
+		labels := containerLabels(s)
+		otlpAttribute(&resource, 1, "container.id", containerKey(s))
+		otlpAttribute(&resource, 1, "container.name", labels["name"])
+		otlpAttribute(&resource, 1, "container.image.name", labels["image"])
+		otlpAttribute(&resource, 1, "host.name", host)
+		otlpAttribute(&resource, 1, "service.name", "docker-stats")
+		for k, v := range labels {
+			if strings.HasPrefix(k, "label_") {
+				otlpAttribute(&resource, 1, "container.label."+strings.TrimPrefix(k, "label_"), v)
+			}
+		}
+
+		var scope, scopeInfo protoWriter
+		scopeInfo.string(1, "docker-stats")
+		scopeInfo.string(2, version)
+		scope.message(1, &scopeInfo)
+		for _, g := range containerGauges {
+			v, ok := s.Values[g.reading]
+			if !ok {
+				continue
+			}
+			var point protoWriter
+			point.fixed64(3, uint64(now.UnixNano()))
+			point.double(4, v)
+
+			var data protoWriter
+			data.message(1, &point)
+
+			var m protoWriter
+			m.string(1, otlpMetricName(g.name))
+			m.string(2, g.help)
+			if g.kind == "counter" {
+				data.varint(2, 2) // AGGREGATION_TEMPORALITY_CUMULATIVE
+				data.bool(3, true)
+				m.message(7, &data)
+			} else {
+				m.message(5, &data)
+			}
+			scope.message(2, &m)
+		}
+
+		var rm protoWriter
+		rm.message(1, &resource)
+		rm.message(2, &scope)
+		req.message(1, &rm)
+	}
+	return req.buf
+}
+
+func otlpAttribute(w *protoWriter, field int, key, value string) {
+	if value == "" {
+		return
+	}
+	var any, kv protoWriter
+	any.string(1, value)
+	kv.string(1, key)
+	kv.message(2, &any)
+	w.message(field, &kv)
+}
+
+// otlpMetricName turns docker_container_network_receive_bytes_total into
+// container.network.receive.bytes, following the OpenTelemetry style.
+func otlpMetricName(name string) string {
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "docker_"), "_total")
+	return strings.Replace(name, "_", ".", -1)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// redisExporter XADDs one entry per record to the stream redis_stream,
+// trimming it to about redis_maxlen entries. Entries have the container's
+// id and name fields and the encoded record under record.
+type redisExporter struct {
+	address  string
+	username string
+	password string
+	db       int
+	tls      *tls.Config
+	stream   string
+	maxLen   string
+	enc      encoder
+	schema   string
+
+	mu   sync.Mutex
+	conn *redisConn
+}
+
+func newRedisExporter() (Exporter, error) {
+	e := &redisExporter{
+		address:  os.Getenv("redis_address"),
+		username: os.Getenv("redis_username"),
+		stream:   os.Getenv("redis_stream"),
+		maxLen:   "10000",
+	}
+	if e.address == "" {
+		return nil, errors.New("redis_address is required")
+	}
+	if e.stream == "" {
+		e.stream = "docker-stats"
+	}
+	if v := os.Getenv("redis_maxlen"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid redis_maxlen %q", v)
+		}
+		e.maxLen = v
+	}
+	if v := os.Getenv("redis_db"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid redis_db %q", v)
+		}
+		e.db = n
+	}
+
+	var err error
+	if e.password, err = secretSetting("redis_password"); err != nil {
+		return nil, fmt.Errorf("reading redis_password_file: %v", err)
+	}
+	if e.tls, err = outputTLS("redis", ""); err != nil {
+		return nil, err
+	}
+	if e.enc, err = outputEncoder("redis"); err != nil {
+		return nil, err
+	}
+	if e.schema, err = outputSchema("redis"); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *redisExporter) Name() string { return "redis" }
+
+func (e *redisExporter) exportsRecords() {}
+
+func (e *redisExporter) Export(samples []sample) error {
+	commands := make([][]string, 0, len(samples))
+	for _, s := range samples {
+		record, err := e.enc.encode(s, e.schema)
+		if err != nil {
+			return err
+		}
+		command := []string{"XADD", e.stream}
+		if e.maxLen != "0" {
+			command = append(command, "MAXLEN", "~", e.maxLen)
+		}
+		commands = append(commands, append(command, "*",
+			"id", containerKey(s),
+			"name", containerLabels(s)["name"],
+			"record", string(record),
+		))
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn == nil {
+		conn, err := dialRedis(e.address, e.tls, e.username, e.password, e.db)
+		if err != nil {
+			return err
+		}
+		e.conn = conn
+	}
+	if err := e.conn.do(commands); err != nil {
+		if _, ok := err.(redisError); !ok {
+			e.conn.Close()
+			e.conn = nil
+		}
+		return err
+	}
+	return nil
+}
+
+func (e *redisExporter) Check(ctx context.Context) error {
+	return dialCheck(ctx, e.address)
+}
+
+func (e *redisExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn != nil {
+		e.conn.Close()
+		e.conn = nil
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// remoteWriteExporter pushes the container metrics of every tick with the
+// Prometheus remote_write protocol, so Mimir, Thanos or VictoriaMetrics can
+// receive them without scraping. Series have the names and labels of the
+// /metrics endpoint plus remote_write_external_labels.
+type remoteWriteExporter struct {
+	url      string
+	username string
+	password string
+	token    string
+	external map[string]string
+	client   *http.Client
+}
+
+func newRemoteWriteExporter() (Exporter, error) {
+	e := &remoteWriteExporter{
+		url:      os.Getenv("remote_write_url"),
+		username: os.Getenv("remote_write_username"),
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	if e.url == "" {
+		return nil, errors.New("remote_write_url is required")
+	}
+	var err error
+	if e.password, err = secretSetting("remote_write_password"); err != nil {
+		return nil, fmt.Errorf("reading remote_write_password_file: %v", err)
+	}
+	if e.token, err = secretSetting("remote_write_bearer_token"); err != nil {
+		return nil, fmt.Errorf("reading remote_write_bearer_token_file: %v", err)
+	}
+	if e.external, err = parseHeaders(os.Getenv("remote_write_external_labels")); err != nil {
+		return nil, fmt.Errorf("invalid remote_write_external_labels: %v", err)
+	}
+	return e, nil
+}
+
+func (e *remoteWriteExporter) Name() string { return "remote_write" }
+
+func (e *remoteWriteExporter) Export(samples []sample) error {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	var request protoWriter
+	for _, s := range samples {
+		labels := containerLabels(s)
+		for k, v := range e.external {
+			if _, ok := labels[k]; !ok {
+				labels[k] = v
+			}
+		}
+		for _, g := range containerGauges {
+			v, ok := s.Values[g.reading]
+			if !ok {
+				continue
+			}
+			labels["__name__"] = g.name
+			request.message(1, remoteWriteSeries(labels, v, now))
+		}
+	}
+	if len(request.buf) == 0 {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(snappyEncode(request.buf)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if e.token != "" {
+		req.Header.Set("Authorization", "Bearer "+e.token)
+	} else if e.username != "" {
+		req.SetBasicAuth(e.username, e.password)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("remote_write receiver responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// remoteWriteSeries encodes a prometheus.TimeSeries with one sample. Labels
+// must be sorted by name.
+func remoteWriteSeries(labels map[string]string, value float64, timestamp int64) *protoWriter {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var series protoWriter
+	for _, k := range names {
+		var label protoWriter
+		label.string(1, k)
+		label.string(2, labels[k])
+		series.message(1, &label)
+	}
+	var point protoWriter
+	point.double(1, value)
+	point.varint(2, uint64(timestamp))
+	series.message(2, &point)
+	return &series
+}
+
+func (e *remoteWriteExporter) Check(ctx context.Context) error {
+	address, err := urlAddress(e.url)
+	if err != nil {
+		return err
+	}
+	return dialCheck(ctx, address)
+}
+
+func (e *remoteWriteExporter) Close() error { return nil }
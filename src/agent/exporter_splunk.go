@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// splunkExporter sends one HTTP Event Collector event per record, batching
+// splunk_batch_size events per request and gzipping them with splunk_gzip.
+type splunkExporter struct {
+	url    string
+	token  string
+	meta   splunkEvent
+	batch  int
+	gzip   bool
+	schema string
+	client *http.Client
+}
+
+type splunkEvent struct {
+	Time       float64     `json:"time,omitempty"`
+	Host       string      `json:"host,omitempty"`
+	Source     string      `json:"source,omitempty"`
+	Sourcetype string      `json:"sourcetype,omitempty"`
+	Index      string      `json:"index,omitempty"`
+	Event      interface{} `json:"event,omitempty"`
+}
+
+func newSplunkExporter() (Exporter, error) {
+	url := strings.TrimRight(os.Getenv("splunk_url"), "/")
+	if url == "" {
+		return nil, errors.New("splunk_url is required")
+	}
+	token, err := secretSetting("splunk_token")
+	if err != nil {
+		return nil, fmt.Errorf("reading splunk_token_file: %v", err)
+	}
+	if token == "" {
+		return nil, errors.New("splunk_token or splunk_token_file is required")
+	}
+	e := &splunkExporter{
+		url:   url + "/services/collector/event",
+		token: token,
+		meta: splunkEvent{
+			Source:     os.Getenv("splunk_source"),
+			Sourcetype: os.Getenv("splunk_sourcetype"),
+			Index:      os.Getenv("splunk_index"),
+		},
+		batch:  100,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	if e.meta.Sourcetype == "" {
+		e.meta.Sourcetype = "docker:stats"
+	}
+	e.meta.Host, _ = os.Hostname()
+	if v := os.Getenv("splunk_batch_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid splunk_batch_size %q", v)
+		}
+		e.batch = n
+	}
+	if v := os.Getenv("splunk_gzip"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid splunk_gzip %q", v)
+		}
+		e.gzip = b
+	}
+	if e.schema, err = outputSchema("splunk"); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *splunkExporter) Name() string { return "splunk" }
+
+func (e *splunkExporter) exportsRecords() {}
+
+func (e *splunkExporter) Export(samples []sample) error {
+	records := serialize(samples, e.schema)
+	now := float64(time.Now().UnixNano()/int64(time.Millisecond)) / 1000
+	for len(records) > 0 {
+		n := len(records)
+		if n > e.batch {
+			n = e.batch
+		}
+		var body bytes.Buffer
+		for _, record := range records[:n] {
+			event := e.meta
+			event.Time = now
+			event.Event = record
+			b, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			body.Write(b)
+			body.WriteByte('\n')
+		}
+		if err := e.post(body.Bytes()); err != nil {
+			return err
+		}
+		records = records[n:]
+	}
+	return nil
+}
+
+func (e *splunkExporter) post(events []byte) error {
+	body := events
+	if e.gzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(events)
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Splunk "+e.token)
+	if e.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("splunk responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (e *splunkExporter) Check(ctx context.Context) error {
+	address, err := urlAddress(e.url)
+	if err != nil {
+		return err
+	}
+	return dialCheck(ctx, address)
+}
+
+func (e *splunkExporter) Close() error { return nil }
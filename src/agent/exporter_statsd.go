@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statsdMaxPacket keeps datagrams under the common 1500 byte MTU.
+const statsdMaxPacket = 1432
+
+// statsdExporter sends every raw reading as a gauge to statsd_address, a UDP
+// host:port or a unix:///path datagram socket. Plain StatsD has no tags, so
+// the container name is part of the metric name, e.g.
+// docker.container.web.cpu.pct; with statsd_flavor=dogstatsd the name stays
+// docker.container.cpu.pct and the container is described by tags.
+type statsdExporter struct {
+	network string
+	address string
+	prefix  string
+	dog     bool
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newStatsdExporter() (Exporter, error) {
+	address := os.Getenv("statsd_address")
+	if address == "" {
+		return nil, errors.New("statsd_address is required")
+	}
+	e := &statsdExporter{network: "udp", address: address, prefix: os.Getenv("statsd_prefix")}
+	if strings.HasPrefix(address, "unix://") {
+		e.network, e.address = "unixgram", strings.TrimPrefix(address, "unix://")
+	}
+	if e.prefix == "" {
+		e.prefix = "docker.container"
+	}
+	switch v := os.Getenv("statsd_flavor"); v {
+	case "", "statsd":
+	case "dogstatsd":
+		e.dog = true
+	default:
+		return nil, fmt.Errorf("unknown statsd_flavor %q", v)
+	}
+	return e, nil
+}
+
+func (e *statsdExporter) Name() string { return "statsd" }
+
+func (e *statsdExporter) Export(samples []sample) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.conn == nil {
+		conn, err := net.DialTimeout(e.network, e.address, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		e.conn = conn
+	}
+
+	var packet []byte
+	for _, s := range samples {
+		for _, line := range e.lines(s) {
+			if len(packet)+len(line)+1 > statsdMaxPacket && len(packet) > 0 {
+				if err := e.send(packet); err != nil {
+					return err
+				}
+				packet = packet[:0]
+			}
+			if len(packet) > 0 {
+				packet = append(packet, '\n')
+			}
+			packet = append(packet, line...)
+		}
+	}
+	if len(packet) > 0 {
+		return e.send(packet)
+	}
+	return nil
+}
+
+func (e *statsdExporter) send(packet []byte) error {
+	if _, err := e.conn.Write(packet); err != nil {
+		e.conn.Close()
+		e.conn = nil
+		return err
+	}
+	return nil
+}
+
+// lines returns the gauges of a sample, sorted by reading.
+func (e *statsdExporter) lines(s sample) []string {
+	labels := containerLabels(s)
+	prefix := e.prefix
+	var tags string
+	if e.dog {
+		pairs := []string{"container_id:" + containerKey(s)}
+		for _, k := range sortedKeys(labels) {
+			switch {
+			case k == "name":
+				pairs = append(pairs, "container_name:"+statsdTag(labels[k]))
+			case k == "image":
+				pairs = append(pairs, "image:"+statsdTag(labels[k]))
+			case strings.HasPrefix(k, "label_"):
+				pairs = append(pairs, strings.TrimPrefix(k, "label_")+":"+statsdTag(labels[k]))
+			}
+		}
+		tags = "|#" + strings.Join(pairs, ",")
+	} else {
+		name := labels["name"]
+		if name == "" {
+			name = s.ID
+		}
+		prefix += "." + statsdName(name)
+	}
+
+	lines := make([]string, 0, len(s.Values))
+	for _, r := range sortedReadings(s.Values) {
+		metric := prefix + "." + strings.ToLower(strings.Replace(r, "_", ".", -1))
+		v := s.Values[r]
+		if v < 0 {
+			// A signed gauge is read as a change to the current value, so
+			// reset it first to send an absolute negative value.
+			lines = append(lines, metric+":0|g"+tags)
+		}
+		lines = append(lines, metric+":"+strconv.FormatFloat(v, 'f', -1, 64)+"|g"+tags)
+	}
+	return lines
+}
+
+// statsdName keeps a container name from adding path levels or breaking the
+// line format.
+func statsdName(name string) string {
+	return strings.NewReplacer(".", "_", ":", "_", "|", "_", "@", "_", " ", "_", "/", "_").Replace(name)
+}
+
+func statsdTag(v string) string {
+	return strings.NewReplacer(",", "_", "|", "_", " ", "_").Replace(v)
+}
+
+func (e *statsdExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn == nil {
+		return nil
+	}
+	err := e.conn.Close()
+	e.conn = nil
+	return err
+}
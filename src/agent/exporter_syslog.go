@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// syslogEnterprise is the private enterprise number of the structured data
+// IDs, the one RFC 5612 reserves for examples and documentation.
+const syslogEnterprise = "32473"
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "daemon": 3, "auth": 4, "syslog": 5,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogExporter sends one RFC 5424 message per record to syslog_address over
+// UDP, TCP or TLS, the container in a container@32473 element and the stats in
+// a stats@32473 one. With syslog_logs=true the agent's own logs go there too.
+type syslogExporter struct {
+	network  string
+	address  string
+	tls      *tls.Config
+	facility int
+	appName  string
+	host     string
+	pid      string
+	schema   string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newSyslogExporter() (Exporter, error) {
+	raw := os.Getenv("syslog_address")
+	if raw == "" {
+		return nil, errors.New("syslog_address is required")
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid syslog_address %q, expected udp://, tcp:// or tls://host:port", raw)
+	}
+	e := &syslogExporter{network: u.Scheme, address: u.Host, facility: 16, appName: os.Getenv("syslog_app_name")}
+	port := "514"
+	switch u.Scheme {
+	case "udp", "tcp":
+	case "tls":
+		port = "6514"
+		e.network = "tcp"
+	default:
+		return nil, fmt.Errorf("invalid syslog_address %q, expected udp://, tcp:// or tls://host:port", raw)
+	}
+	if u.Port() == "" {
+		e.address = net.JoinHostPort(u.Hostname(), port)
+	}
+	if e.tls, err = outputTLS("syslog", ""); err != nil {
+		return nil, err
+	}
+	if e.tls == nil && u.Scheme == "tls" {
+		e.tls = &tls.Config{}
+	}
+	if v := os.Getenv("syslog_facility"); v != "" {
+		f, ok := syslogFacilities[v]
+		if !ok {
+			return nil, fmt.Errorf("unknown syslog_facility %q", v)
+		}
+		e.facility = f
+	}
+	if e.appName == "" {
+		e.appName = "docker-stats"
+	}
+	e.host, _ = os.Hostname()
+	e.pid = strconv.Itoa(os.Getpid())
+	if e.schema, err = outputSchema("syslog"); err != nil {
+		return nil, err
+	}
+
+	logs, err := boolSetting("syslog_logs")
+	if err != nil {
+		return nil, err
+	}
+	if logs {
+		logrus.AddHook(e)
+	}
+	return e, nil
+}
+
+func (e *syslogExporter) Name() string { return "syslog" }
+
+func (e *syslogExporter) Export(samples []sample) error {
+	now := time.Now()
+	messages := make([][]byte, 0, len(samples))
+	for _, s := range samples {
+		labels := containerLabels(s)
+		container := map[string]string{"id": containerKey(s), "name": labels["name"], "image": labels["image"]}
+		stats := map[string]string{}
+		if e.schema == schemaRaw {
+			for k, v := range s.Values {
+				stats[k] = strconv.FormatFloat(v, 'f', -1, 64)
+			}
+		} else if formatted, ok := s.Fields["Stats"].(map[string]interface{}); ok {
+			for k, v := range formatted {
+				stats[k] = fmt.Sprint(v)
+			}
+		}
+		sd := syslogElement("container@"+syslogEnterprise, container) + syslogElement("stats@"+syslogEnterprise, stats)
+		name := labels["name"]
+		if name == "" {
+			name = s.ID
+		}
+		messages = append(messages, e.message(6, now, "stats", sd, name))
+	}
+	return e.send(messages)
+}
+
+// message formats an RFC 5424 message.
+func (e *syslogExporter) message(severity int, t time.Time, msgID, sd, msg string) []byte {
+	if sd == "" {
+		sd = "-"
+	}
+	return []byte(fmt.Sprintf("<%d>1 %s %s %s %s %s %s %s",
+		e.facility*8+severity, t.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		e.host, e.appName, e.pid, msgID, sd, msg))
+}
+
+// send writes the messages, one datagram each over UDP and octet counted
+// (RFC 6587) over TCP and TLS, reconnecting when the connection broke.
+func (e *syslogExporter) send(messages [][]byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn == nil {
+		var conn net.Conn
+		var err error
+		dialer := &net.Dialer{Timeout: 10 * time.Second}
+		if e.tls != nil {
+			config := e.tls.Clone()
+			if config.ServerName == "" {
+				config.ServerName, _, _ = net.SplitHostPort(e.address)
+			}
+			conn, err = tls.DialWithDialer(dialer, e.network, e.address, config)
+		} else {
+			conn, err = dialer.Dial(e.network, e.address)
+		}
+		if err != nil {
+			return err
+		}
+		e.conn = conn
+	}
+
+	e.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	for _, m := range messages {
+		var err error
+		if e.network == "udp" {
+			_, err = e.conn.Write(m)
+		} else {
+			_, err = e.conn.Write(append([]byte(strconv.Itoa(len(m))+" "), m...))
+		}
+		if err != nil {
+			e.conn.Close()
+			e.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// Levels makes the exporter a logrus hook for every level that gets logged.
+func (e *syslogExporter) Levels() []logrus.Level { return logrus.AllLevels }
+
+// Fire forwards a log entry. It can't log its own failures, they would be
+// forwarded again.
+func (e *syslogExporter) Fire(entry *logrus.Entry) error {
+	fields := make(map[string]string, len(entry.Data))
+	for k, v := range entry.Data {
+		fields[k] = fmt.Sprint(v)
+	}
+	severity := map[logrus.Level]int{
+		logrus.PanicLevel: 0, logrus.FatalLevel: 2, logrus.ErrorLevel: 3,
+		logrus.WarnLevel: 4, logrus.InfoLevel: 6, logrus.DebugLevel: 7,
+	}[entry.Level]
+	sd := syslogElement("log@"+syslogEnterprise, fields)
+	e.send([][]byte{e.message(severity, entry.Time, "log", sd, entry.Message)})
+	return nil
+}
+
+func (e *syslogExporter) Check(ctx context.Context) error {
+	if e.network == "udp" {
+		return nil
+	}
+	return dialCheck(ctx, e.address)
+}
+
+func (e *syslogExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn != nil {
+		e.conn.Close()
+		e.conn = nil
+	}
+	return nil
+}
+
+// syslogElement formats a structured data element, or nothing when empty.
+func syslogElement(id string, params map[string]string) string {
+	if len(params) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	b.WriteString("[" + id)
+	for _, k := range keys {
+		b.WriteString(" " + syslogName(k) + `="` + syslogValue.Replace(params[k]) + `"`)
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+var syslogValue = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogName keeps a parameter name to the printable ASCII RFC 5424 allows.
+func syslogName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if c <= ' ' || c >= 127 || c == '=' || c == ']' || c == '"' {
+			b[i] = '_'
+		}
+	}
+	if len(b) > 32 {
+		b = b[:32]
+	}
+	return string(b)
+}
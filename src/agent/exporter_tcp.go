@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// tcpExporter streams records to a TCP collector. JSON records are newline
+// delimited; MessagePack records are self-delimiting and written back to back.
+// With tcp_records=tick one document per tick is written instead. The
+// connection is re-established on the next tick after a write fails.
+type tcpExporter struct {
+	address string
+	enc     encoder
+	records string
+	schema  string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newTCPExporter() (Exporter, error) {
+	address := os.Getenv("tcp_address")
+	if address == "" {
+		return nil, errors.New("tcp_address is required")
+	}
+	enc, err := outputEncoder("tcp")
+	if err != nil {
+		return nil, err
+	}
+	records, err := outputRecords("tcp")
+	if err != nil {
+		return nil, err
+	}
+	schema, err := outputSchema("tcp")
+	if err != nil {
+		return nil, err
+	}
+	return &tcpExporter{address: address, enc: enc, records: records, schema: schema}, nil
+}
+
+func (e *tcpExporter) Name() string { return "tcp" }
+
+func (e *tcpExporter) exportsRecords() {}
+
+func (e *tcpExporter) perTick() bool { return e.records == recordsTick }
+
+func (e *tcpExporter) Export(samples []sample) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.conn == nil {
+		conn, err := net.DialTimeout("tcp", e.address, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		e.conn = conn
+	}
+
+	payloads := make([][]byte, 0, len(samples))
+	if e.records == recordsTick {
+		data, err := e.enc.marshal(tickRecord(samples, e.schema))
+		if err != nil {
+			return err
+		}
+		payloads = append(payloads, data)
+	} else {
+		for _, s := range samples {
+			data, err := e.enc.encode(s, e.schema)
+			if err != nil {
+				return err
+			}
+			payloads = append(payloads, data)
+		}
+	}
+
+	for _, data := range payloads {
+		switch e.enc.name {
+		case "json":
+			data = append(data, '\n')
+		case "protobuf":
+			// Varint length delimited, as written by writeDelimitedTo.
+			var prefix protoWriter
+			prefix.rawVarint(uint64(len(data)))
+			data = append(prefix.buf, data...)
+		}
+		e.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if _, err := e.conn.Write(data); err != nil {
+			e.conn.Close()
+			e.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// Check dials the collector; the connection is kept for the first tick.
+func (e *tcpExporter) Check(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn != nil {
+		return nil
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", e.address)
+	if err != nil {
+		return err
+	}
+	e.conn = conn
+	return nil
+}
+
+func (e *tcpExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn == nil {
+		return nil
+	}
+	err := e.conn.Close()
+	e.conn = nil
+	return err
+}
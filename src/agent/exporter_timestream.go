@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timestreamBatch is the number of records WriteRecords accepts per request.
+const timestreamBatch = 100
+
+// timestreamExporter writes one multi-measure record per container and tick
+// to timestream_table of timestream_database in Amazon Timestream, with the
+// raw readings as measures such as cpu_pct and the host, container ID, name
+// and image as dimensions. The ingestion endpoint is discovered and cached
+// as the API requires, unless timestream_endpoint names one, e.g. a VPC
+// endpoint.
+type timestreamExporter struct {
+	database string
+	table    string
+	measure  string
+	region   string
+	host     string
+	client   *http.Client
+
+	mu       sync.Mutex
+	endpoint string
+	expires  time.Time
+	fixed    bool
+}
+
+type timestreamRecord struct {
+	Dimensions       []timestreamDimension `json:"Dimensions"`
+	MeasureName      string                `json:"MeasureName"`
+	MeasureValueType string                `json:"MeasureValueType"`
+	MeasureValues    []timestreamMeasure   `json:"MeasureValues"`
+}
+
+type timestreamDimension struct {
+	Name  string `json:"Name"`
+	Value string `json:"Value"`
+}
+
+type timestreamMeasure struct {
+	Name  string `json:"Name"`
+	Value string `json:"Value"`
+	Type  string `json:"Type"`
+}
+
+func newTimestreamExporter() (Exporter, error) {
+	e := &timestreamExporter{
+		database: os.Getenv("timestream_database"),
+		table:    os.Getenv("timestream_table"),
+		measure:  os.Getenv("timestream_measure_name"),
+		region:   awsRegion("timestream_region"),
+		endpoint: strings.TrimRight(os.Getenv("timestream_endpoint"), "/"),
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	if e.database == "" {
+		return nil, errors.New("timestream_database is required")
+	}
+	if e.region == "" {
+		return nil, errors.New("timestream_region or AWS_REGION is required")
+	}
+	if e.table == "" {
+		e.table = "docker_stats"
+	}
+	if e.measure == "" {
+		e.measure = "stats"
+	}
+	if e.endpoint != "" {
+		if !strings.Contains(e.endpoint, "://") {
+			e.endpoint = "https://" + e.endpoint
+		}
+		e.fixed = true
+	}
+	e.host, _ = os.Hostname()
+	return e, nil
+}
+
+func (e *timestreamExporter) Name() string { return "timestream" }
+
+func (e *timestreamExporter) Export(samples []sample) error {
+	var records []timestreamRecord
+	for _, s := range samples {
+		labels := containerLabels(s)
+		record := timestreamRecord{MeasureName: e.measure, MeasureValueType: "MULTI"}
+		for _, d := range [][2]string{{"host", e.host}, {"container_id", containerKey(s)}, {"container_name", labels["name"]}, {"image", labels["image"]}} {
+			if d[1] != "" {
+				record.Dimensions = append(record.Dimensions, timestreamDimension{Name: d[0], Value: d[1]})
+			}
+		}
+		for _, r := range sortedReadings(s.Values) {
+			v := s.Values[r]
+			if !finite(v) {
+				continue
+			}
+			record.MeasureValues = append(record.MeasureValues, timestreamMeasure{Name: strings.ToLower(r), Value: strconv.FormatFloat(v, 'f', -1, 64), Type: "DOUBLE"})
+		}
+		if len(record.MeasureValues) > 0 {
+			records = append(records, record)
+		}
+	}
+
+	now := time.Now()
+	common := map[string]string{"Time": strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10), "TimeUnit": "MILLISECONDS"}
+	for len(records) > 0 {
+		n := len(records)
+		if n > timestreamBatch {
+			n = timestreamBatch
+		}
+		request := map[string]interface{}{"DatabaseName": e.database, "TableName": e.table, "CommonAttributes": common, "Records": records[:n]}
+		if _, err := e.call("WriteRecords", request, now); err != nil {
+			return err
+		}
+		records = records[n:]
+	}
+	return nil
+}
+
+// call invokes an action on the ingestion endpoint.
+func (e *timestreamExporter) call(action string, request interface{}, now time.Time) ([]byte, error) {
+	endpoint, err := e.ingestEndpoint(now)
+	if err != nil {
+		return nil, err
+	}
+	return e.post(endpoint, action, request, now)
+}
+
+// ingestEndpoint returns the discovered endpoint, discovering it again once
+// its cache period ended.
+func (e *timestreamExporter) ingestEndpoint(now time.Time) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.fixed || (e.endpoint != "" && now.Before(e.expires)) {
+		return e.endpoint, nil
+	}
+	body, err := e.post("https://ingest.timestream."+e.region+".amazonaws.com", "DescribeEndpoints", map[string]string{}, now)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Endpoints []struct {
+			Address              string
+			CachePeriodInMinutes int64
+		}
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || len(resp.Endpoints) == 0 {
+		return "", errors.New("timestream returned no ingestion endpoint")
+	}
+	e.endpoint = "https://" + resp.Endpoints[0].Address
+	e.expires = now.Add(time.Duration(resp.Endpoints[0].CachePeriodInMinutes) * time.Minute)
+	return e.endpoint, nil
+}
+
+func (e *timestreamExporter) post(endpoint, action string, request interface{}, now time.Time) ([]byte, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := awsCreds.get()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "Timestream_20181101."+action)
+	signAWSv4(req, body, "timestream", e.region, creds, now)
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("timestream %s responded %s: %s", action, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return msg, nil
+}
+
+func (e *timestreamExporter) Check(ctx context.Context) error {
+	if _, err := awsCreds.get(); err != nil {
+		return err
+	}
+	endpoint := "https://ingest.timestream." + e.region + ".amazonaws.com"
+	if e.fixed {
+		endpoint = e.endpoint
+	}
+	address, err := urlAddress(endpoint)
+	if err != nil {
+		return err
+	}
+	return dialCheck(ctx, address)
+}
+
+func (e *timestreamExporter) Close() error { return nil }
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	wavefrontBatch     = 10000 // points per direct ingestion request
+	wavefrontMaxTagLen = 254   // of a point tag's key and value together
+)
+
+// wavefrontTagKey matches the characters allowed in point tag keys.
+var wavefrontTagKey = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// wavefrontExporter sends every raw reading as a point in the Wavefront data
+// format, e.g. docker.container.cpu.pct 12.5 1700000000 source=host
+// container_name="web", either to a Wavefront proxy at wavefront_proxy or
+// straight to the cluster at wavefront_url with wavefront_token. Containers
+// are described by point tags, their Docker labels included.
+type wavefrontExporter struct {
+	proxy  string
+	url    string
+	token  string
+	source string
+	prefix string
+	client *http.Client
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newWavefrontExporter() (Exporter, error) {
+	e := &wavefrontExporter{proxy: os.Getenv("wavefront_proxy"), source: os.Getenv("wavefront_source"), prefix: os.Getenv("wavefront_prefix")}
+	url := strings.TrimRight(os.Getenv("wavefront_url"), "/")
+	switch {
+	case e.proxy != "" && url != "":
+		return nil, errors.New("set either wavefront_proxy or wavefront_url, not both")
+	case e.proxy != "":
+		if _, _, err := net.SplitHostPort(e.proxy); err != nil {
+			e.proxy = net.JoinHostPort(e.proxy, "2878")
+		}
+	case url != "":
+		var err error
+		if e.token, err = secretSetting("wavefront_token"); err != nil {
+			return nil, fmt.Errorf("reading wavefront_token_file: %v", err)
+		}
+		if e.token == "" {
+			return nil, errors.New("wavefront_url needs wavefront_token or wavefront_token_file")
+		}
+		e.url = url + "/report?f=wavefront"
+		e.client = &http.Client{Timeout: 10 * time.Second}
+	default:
+		return nil, errors.New("wavefront_proxy or wavefront_url is required")
+	}
+	if e.source == "" {
+		e.source, _ = os.Hostname()
+	}
+	if e.prefix == "" {
+		e.prefix = "docker.container"
+	}
+	return e, nil
+}
+
+func (e *wavefrontExporter) Name() string { return "wavefront" }
+
+func (e *wavefrontExporter) Export(samples []sample) error {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	source := " source=" + wavefrontQuote(e.source)
+	var lines []string
+	for _, s := range samples {
+		tags := wavefrontTags(s)
+		for _, r := range sortedReadings(s.Values) {
+			v := s.Values[r]
+			if !finite(v) {
+				continue
+			}
+			name := e.prefix + "." + strings.ToLower(strings.Replace(r, "_", ".", -1))
+			lines = append(lines, wavefrontQuote(name)+" "+strconv.FormatFloat(v, 'g', -1, 64)+" "+now+source+tags+"\n")
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	if e.proxy != "" {
+		return e.write(strings.Join(lines, ""))
+	}
+	for len(lines) > 0 {
+		n := len(lines)
+		if n > wavefrontBatch {
+			n = wavefrontBatch
+		}
+		if err := e.post(strings.Join(lines[:n], "")); err != nil {
+			return err
+		}
+		lines = lines[n:]
+	}
+	return nil
+}
+
+// write sends the lines to the proxy, reconnecting on the next tick after a
+// failure.
+func (e *wavefrontExporter) write(data string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn == nil {
+		conn, err := net.DialTimeout("tcp", e.proxy, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		e.conn = conn
+	}
+	e.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := e.conn.Write([]byte(data)); err != nil {
+		e.conn.Close()
+		e.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (e *wavefrontExporter) post(data string) error {
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewBufferString(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Authorization", "Bearer "+e.token)
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("wavefront responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (e *wavefrontExporter) Check(ctx context.Context) error {
+	if e.proxy != "" {
+		return dialCheck(ctx, e.proxy)
+	}
+	address, err := urlAddress(e.url)
+	if err != nil {
+		return err
+	}
+	return dialCheck(ctx, address)
+}
+
+func (e *wavefrontExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn != nil {
+		e.conn.Close()
+		e.conn = nil
+	}
+	return nil
+}
+
+// wavefrontTags describes a container with point tags, each starting with a
+// space. Docker labels too long for a point tag are left out.
+func wavefrontTags(s sample) string {
+	labels := containerLabels(s)
+	tags := []string{"container_id", containerKey(s)}
+	if labels["name"] != "" {
+		tags = append(tags, "container_name", labels["name"])
+	}
+	if labels["image"] != "" {
+		tags = append(tags, "image", labels["image"])
+	}
+	for _, k := range sortedKeys(s.Labels) {
+		tags = append(tags, wavefrontTagKey.ReplaceAllString(k, "_"), s.Labels[k])
+	}
+	var b strings.Builder
+	for i := 0; i < len(tags); i += 2 {
+		if len(tags[i])+len(tags[i+1]) > wavefrontMaxTagLen || tags[i+1] == "" {
+			continue
+		}
+		b.WriteString(" " + wavefrontQuote(tags[i]) + "=" + wavefrontQuote(tags[i+1]))
+	}
+	return b.String()
+}
+
+// wavefrontQuote double quotes a name or value, escaping quotes and dropping
+// line breaks that would end the point.
+func wavefrontQuote(s string) string {
+	s = strings.NewReplacer(`"`, `\"`, "\n", " ", "\r", " ").Replace(s)
+	return `"` + s + `"`
+}
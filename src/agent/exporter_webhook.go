@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"text/template"
+	"time"
+)
+
+// webhookExporter POSTs the samples of a tick as an array, encoded as JSON or
+// MessagePack according to webhook_encoding, or as one tick document with
+// webhook_records=tick. With webhook_template the body is rendered from a Go
+// template instead, so any collector's format can be produced.
+type webhookExporter struct {
+	url         string
+	enc         encoder
+	records     string
+	schema      string
+	headers     map[string]string
+	template    *template.Template
+	contentType string
+	host        string
+	client      *http.Client
+}
+
+// webhookTemplateData is what webhook_template is executed with. Records is
+// the payload that would be posted without a template.
+type webhookTemplateData struct {
+	Time       string
+	Host       string
+	Records    interface{}
+	Containers []webhookContainer
+}
+
+type webhookContainer struct {
+	ID     string
+	Name   string
+	Image  string
+	Labels map[string]string
+	Values map[string]float64
+}
+
+var webhookTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func newWebhookExporter() (Exporter, error) {
+	url := os.Getenv("webhook_url")
+	if url == "" {
+		return nil, errors.New("webhook_url is required")
+	}
+	enc, err := outputEncoder("webhook")
+	if err != nil {
+		return nil, err
+	}
+	records, err := outputRecords("webhook")
+	if err != nil {
+		return nil, err
+	}
+	schema, err := outputSchema("webhook")
+	if err != nil {
+		return nil, err
+	}
+	e := &webhookExporter{url: url, enc: enc, records: records, schema: schema, contentType: enc.contentType, client: &http.Client{Timeout: 10 * time.Second}}
+
+	headers, err := secretSetting("webhook_headers")
+	if err != nil {
+		return nil, fmt.Errorf("reading webhook_headers_file: %v", err)
+	}
+	if e.headers, err = parseHeaders(headers); err != nil {
+		return nil, fmt.Errorf("invalid webhook_headers: %v", err)
+	}
+
+	text := os.Getenv("webhook_template")
+	if path := os.Getenv("webhook_template_file"); path != "" && text == "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading webhook_template_file: %v", err)
+		}
+		text = string(data)
+	}
+	if text != "" {
+		if e.template, err = template.New("webhook").Funcs(webhookTemplateFuncs).Parse(text); err != nil {
+			return nil, fmt.Errorf("invalid webhook_template: %v", err)
+		}
+		e.contentType = "application/json"
+	}
+	if v := os.Getenv("webhook_content_type"); v != "" {
+		e.contentType = v
+	}
+	e.host, _ = os.Hostname()
+	return e, nil
+}
+
+func (e *webhookExporter) Name() string { return "webhook" }
+
+func (e *webhookExporter) exportsRecords() {}
+
+func (e *webhookExporter) perTick() bool { return e.records == recordsTick }
+
+func (e *webhookExporter) Export(samples []sample) error {
+	var payload interface{} = serialize(samples, e.schema)
+	if e.records == recordsTick {
+		payload = tickRecord(samples, e.schema)
+	}
+	body, err := e.body(samples, payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", e.contentType)
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// body encodes the payload, or renders webhook_template when set.
+func (e *webhookExporter) body(samples []sample, payload interface{}) ([]byte, error) {
+	if e.template == nil {
+		if e.enc.name == "protobuf" {
+			return protoSamples(samples), nil
+		}
+		return e.enc.marshal(payload)
+	}
+	data := webhookTemplateData{Time: time.Now().UTC().Format(time.RFC3339), Host: e.host, Records: payload}
+	for _, s := range samples {
+		labels := containerLabels(s)
+		data.Containers = append(data.Containers, webhookContainer{ID: containerKey(s), Name: labels["name"], Image: labels["image"], Labels: s.Labels, Values: s.Values})
+	}
+	var buf bytes.Buffer
+	if err := e.template.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("rendering webhook_template: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Check only opens a connection to the webhook host so nothing is posted.
+func (e *webhookExporter) Check(ctx context.Context) error {
+	address, err := urlAddress(e.url)
+	if err != nil {
+		return err
+	}
+	return dialCheck(ctx, address)
+}
+
+func (e *webhookExporter) Close() error { return nil }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	collectFDs bool
+	hostProc   = "/proc"
+
+	fdWarnOnce sync.Once
+)
+
+// countFDs returns the number of open file descriptors of the container's
+// main process. It needs the host PID namespace (or host /proc mounted at
+// host_proc) and enough privilege to read other processes' fd tables.
+func countFDs(ctx context.Context, id string) (int, error) {
+	dir, err := containerProc(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	fds, err := readDirNames(filepath.Join(dir, "fd"))
+	if os.IsNotExist(err) {
+		// The cached PID is gone, most likely because the container restarted.
+		inspects.invalidate(id)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(fds), nil
+}
+
+// containerProc returns the /proc directory of the container's main process
+// under host_proc.
+func containerProc(ctx context.Context, id string) (string, error) {
+	inspect, err := inspects.get(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if inspect.ContainerJSONBase == nil || inspect.State == nil || inspect.State.Pid == 0 {
+		return "", fmt.Errorf("container %s has no running process", id)
+	}
+	return filepath.Join(hostProc, fmt.Sprint(inspect.State.Pid)), nil
+}
+
+func readDirNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
+
+// warnFDs reports the first failure loudly and the rest at debug level, since
+// a missing privilege fails identically for every container on every tick.
+func warnFDs(err error) {
+	logged := false
+	fdWarnOnce.Do(func() {
+		logrus.WithFields(logrus.Fields{"error": err}).Warn("cannot count container file descriptors; does the agent run with --pid=host and CAP_SYS_PTRACE?")
+		logged = true
+	})
+	if !logged {
+		logrus.WithFields(logrus.Fields{"error": err}).Debug("cannot count container file descriptors")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Rounding modes for the two decimals of formatted stats.
+const (
+	roundTruncate = "truncate"
+	roundHalfUp   = "half_up"
+	roundHalfEven = "half_even"
+)
+
+var roundingMode = roundHalfUp
+
+// roundUp reports whether a quotient q with remainder rem out of div rounds
+// up to q+1 under the rounding mode.
+func roundUp(q, rem, div uint64) bool {
+	switch roundingMode {
+	case roundTruncate:
+		return false
+	case roundHalfEven:
+		return rem*2 > div || rem*2 == div && q%2 == 1
+	default:
+		return rem*2 >= div
+	}
+}
+
+// formatMB renders a byte count as megabytes with two decimals. The division is
+// done on integers so large cumulative counters don't lose precision in float64.
+func formatMB(bytes uint64) string {
+	const mb = 1024 * 1024
+	whole, rem := bytes/mb, bytes%mb*100
+	frac := rem / mb
+	if roundUp(frac, rem%mb, mb) {
+		frac++
+	}
+	if frac == 100 {
+		whole, frac = whole+1, 0
+	}
+	return fmt.Sprintf("%d.%02d", whole, frac)
+}
+
+// formatDecimal renders a float, such as a percentage, with two decimals.
+func formatDecimal(v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Sprintf("%.2f", v)
+	}
+	// Snap to a millionth of a hundredth first so binary representation error,
+	// e.g. 9.765 stored as 9.76499..., doesn't decide the rounding.
+	scaled := math.Round(math.Abs(v)*100*1e6) / 1e6
+	whole := math.Floor(scaled)
+	switch roundingMode {
+	case roundTruncate:
+	case roundHalfEven:
+		whole = math.RoundToEven(scaled)
+	default:
+		whole = math.Floor(scaled + 0.5)
+	}
+	return fmt.Sprintf("%.2f", math.Copysign(whole/100, v))
+}
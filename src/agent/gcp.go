@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gcpMetadataURL = "http://metadata.google.internal/computeMetadata/v1/"
+	gcpScope       = "https://www.googleapis.com/auth/monitoring.write"
+)
+
+// gcpTokenSource hands out OAuth2 access tokens, from the service account
+// key named by GOOGLE_APPLICATION_CREDENTIALS or else from the metadata
+// server of the GCE or GKE host.
+type gcpTokenSource struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+	client  *http.Client
+}
+
+var gcpTokens = &gcpTokenSource{client: &http.Client{Timeout: 10 * time.Second}}
+
+type gcpServiceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+	ProjectID   string `json:"project_id"`
+}
+
+type gcpToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+func (s *gcpTokenSource) get() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Now().Add(time.Minute).Before(s.expires) {
+		return s.token, nil
+	}
+
+	var (
+		tok gcpToken
+		err error
+	)
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		tok, err = s.fromKey(path)
+	} else {
+		tok, err = s.fromMetadata()
+	}
+	if err != nil {
+		return "", err
+	}
+	s.token = tok.AccessToken
+	s.expires = time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)
+	return s.token, nil
+}
+
+func (s *gcpTokenSource) fromMetadata() (gcpToken, error) {
+	var tok gcpToken
+	body, err := gcpMetadata("instance/service-accounts/default/token")
+	if err != nil {
+		return tok, err
+	}
+	return tok, json.Unmarshal([]byte(body), &tok)
+}
+
+// fromKey signs a JWT with the service account key and trades it for a token.
+func (s *gcpTokenSource) fromKey(path string) (gcpToken, error) {
+	var tok gcpToken
+	account, err := readServiceAccount(path)
+	if err != nil {
+		return tok, err
+	}
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return tok, errors.New("no private key in " + path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return tok, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return tok, errors.New("the private key of " + path + " is not RSA")
+	}
+
+	now := time.Now().Unix()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   account.ClientEmail,
+		"scope": gcpScope,
+		"aud":   account.TokenURI,
+		"iat":   now,
+		"exp":   now + 3600,
+	})
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return tok, err
+	}
+	assertion := unsigned + "." + base64.RawURLEncoding.EncodeToString(sig)
+
+	resp, err := s.client.PostForm(account.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return tok, err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return tok, fmt.Errorf("token endpoint responded %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return tok, json.Unmarshal(body, &tok)
+}
+
+func readServiceAccount(path string) (gcpServiceAccount, error) {
+	var account gcpServiceAccount
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return account, err
+	}
+	if err := json.Unmarshal(data, &account); err != nil {
+		return account, err
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return account, nil
+}
+
+var gcpMetadataClient = &http.Client{Timeout: 2 * time.Second}
+
+// gcpMetadata reads a value from the metadata server.
+func gcpMetadata(path string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, gcpMetadataURL+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := gcpMetadataClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server responded %s for %s", resp.Status, path)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
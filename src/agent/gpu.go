@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	collectGPU bool
+	nvidiaSMI  = "nvidia-smi"
+
+	gpuWarnOnce sync.Once
+)
+
+type gpuDevice struct {
+	index    int
+	uuid     string
+	util     float64
+	hasUtil  bool
+	memUsed  uint64
+	memTotal uint64
+}
+
+type gpuProcess struct {
+	pid  int
+	uuid string
+	mem  uint64
+}
+
+// gpuState is what nvidia-smi reported for the host's NVIDIA GPUs, shared by
+// the containers of a tick: it's queried again at most once a second.
+type gpuState struct {
+	mu        sync.Mutex
+	queriedAt time.Time
+	devices   []gpuDevice
+	processes []gpuProcess
+	err       error
+}
+
+var gpus = &gpuState{}
+
+func (g *gpuState) query(ctx context.Context) ([]gpuDevice, []gpuProcess, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if time.Since(g.queriedAt) < time.Second {
+		return g.devices, g.processes, g.err
+	}
+	g.queriedAt = time.Now()
+	g.devices, g.processes, g.err = nil, nil, nil
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	rows, err := nvidiaQuery(ctx, "--query-gpu=index,uuid,utilization.gpu,memory.used,memory.total")
+	if err != nil {
+		g.err = err
+		return nil, nil, err
+	}
+	for _, row := range rows {
+		if len(row) < 5 {
+			continue
+		}
+		index, err := strconv.Atoi(row[0])
+		if err != nil {
+			continue
+		}
+		d := gpuDevice{index: index, uuid: row[1], memUsed: mib(row[3]), memTotal: mib(row[4])}
+		if util, err := strconv.ParseFloat(row[2], 64); err == nil {
+			d.util, d.hasUtil = util, true
+		}
+		g.devices = append(g.devices, d)
+	}
+	// Processes are optional: some GPUs don't support the query.
+	if rows, err = nvidiaQuery(ctx, "--query-compute-apps=pid,gpu_uuid,used_memory"); err == nil {
+		for _, row := range rows {
+			if len(row) < 3 {
+				continue
+			}
+			if pid, err := strconv.Atoi(row[0]); err == nil {
+				g.processes = append(g.processes, gpuProcess{pid: pid, uuid: row[1], mem: mib(row[2])})
+			}
+		}
+	}
+	return g.devices, g.processes, nil
+}
+
+// nvidiaQuery runs nvidia-smi with a query and returns the CSV rows.
+func nvidiaQuery(ctx context.Context, query string) ([][]string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, nvidiaSMI, query, "--format=csv,noheader,nounits")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if msg := strings.TrimSpace(stderr.String()); err != nil && msg != "" {
+		return nil, fmt.Errorf("%s %s: %v: %s", nvidiaSMI, query, err, msg)
+	} else if err != nil {
+		return nil, fmt.Errorf("%s %s: %v", nvidiaSMI, query, err)
+	}
+	r := csv.NewReader(bytes.NewReader(out))
+	r.TrimLeadingSpace = true
+	r.FieldsPerRecord = -1
+	return r.ReadAll()
+}
+
+// mib converts a nvidia-smi memory amount in MiB to bytes, 0 when it's N/A.
+func mib(v string) uint64 {
+	n, err := strconv.ParseUint(strings.TrimSpace(v), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n * 1024 * 1024
+}
+
+// gpuFields attributes GPUs to the container and adds GPU_COUNT, the mean
+// GPU_UTIL_PCT and GPU<n>_UTIL_PCT of its GPUs, GPU_MEM_TOTAL_BYTES of its
+// GPUs and GPU_MEM_USED_BYTES, the memory its own processes use on them.
+// Utilization is a property of the whole device, so containers sharing a GPU
+// all report its full utilization.
+func gpuFields(ctx context.Context, c types.Container, fields logrus.Fields, readings map[string]float64, values map[string]interface{}) {
+	devices, processes, err := gpus.query(ctx)
+	if err != nil {
+		logged := false
+		gpuWarnOnce.Do(func() {
+			logrus.WithFields(logrus.Fields{"error": err}).Warn("cannot query NVIDIA GPUs; is nvidia-smi available to the agent?")
+			logged = true
+		})
+		if !logged {
+			logrus.WithFields(logrus.Fields{"error": err}).Debug("cannot query NVIDIA GPUs")
+		}
+		return
+	}
+	if len(devices) == 0 {
+		return
+	}
+
+	var memUsed uint64
+	running := map[string]bool{}
+	for _, p := range processes {
+		if pidInContainer(p.pid, c.ID) {
+			memUsed += p.mem
+			running[p.uuid] = true
+		}
+	}
+	assigned := assignedGPUs(ctx, c.ID, devices, running)
+	if len(assigned) == 0 {
+		return
+	}
+
+	var util, memTotal float64
+	var withUtil int
+	for _, d := range assigned {
+		memTotal += float64(d.memTotal)
+		if d.hasUtil {
+			util += d.util
+			withUtil++
+			name := "GPU" + strconv.Itoa(d.index) + "_UTIL_PCT"
+			readings[name] = d.util
+			values[name] = formatDecimal(d.util)
+		}
+	}
+	readings["GPU_COUNT"] = float64(len(assigned))
+	readings["GPU_MEM_USED_BYTES"] = float64(memUsed)
+	readings["GPU_MEM_TOTAL_BYTES"] = memTotal
+	values["GPU_COUNT"] = len(assigned)
+	values["GPU_MEM_USED_MB"] = formatMB(memUsed)
+	values["GPU_MEM_TOTAL_MB"] = formatMB(uint64(memTotal))
+	if withUtil > 0 {
+		readings["GPU_UTIL_PCT"] = util / float64(withUtil)
+		values["GPU_UTIL_PCT"] = formatDecimal(util / float64(withUtil))
+	}
+}
+
+// assignedGPUs returns the GPUs given to the container: the /dev/nvidia<n>
+// devices mapped into it, else those NVIDIA_VISIBLE_DEVICES names, else the
+// ones its processes run on.
+func assignedGPUs(ctx context.Context, id string, devices []gpuDevice, running map[string]bool) []gpuDevice {
+	var wanted func(d gpuDevice) bool
+	if inspect, err := inspects.get(ctx, id); err == nil && inspect.ContainerJSONBase != nil {
+		if inspect.HostConfig != nil {
+			mapped := map[int]bool{}
+			for _, m := range inspect.HostConfig.Devices {
+				if n, err := strconv.Atoi(strings.TrimPrefix(m.PathOnHost, "/dev/nvidia")); err == nil && strings.HasPrefix(m.PathOnHost, "/dev/nvidia") {
+					mapped[n] = true
+				}
+			}
+			if len(mapped) > 0 {
+				wanted = func(d gpuDevice) bool { return mapped[d.index] }
+			}
+		}
+		if wanted == nil && inspect.Config != nil {
+			for _, env := range inspect.Config.Env {
+				if !strings.HasPrefix(env, "NVIDIA_VISIBLE_DEVICES=") {
+					continue
+				}
+				visible := strings.TrimPrefix(env, "NVIDIA_VISIBLE_DEVICES=")
+				switch visible {
+				case "", "none", "void":
+					return nil
+				case "all":
+					wanted = func(gpuDevice) bool { return true }
+				default:
+					names := map[string]bool{}
+					for _, v := range splitList(visible) {
+						names[v] = true
+					}
+					wanted = func(d gpuDevice) bool { return names[strconv.Itoa(d.index)] || names[d.uuid] }
+				}
+			}
+		}
+	}
+	if wanted == nil {
+		wanted = func(d gpuDevice) bool { return running[d.uuid] }
+	}
+	var assigned []gpuDevice
+	for _, d := range devices {
+		if wanted(d) {
+			assigned = append(assigned, d)
+		}
+	}
+	return assigned
+}
+
+// pidInContainer reports whether a host PID belongs to the container, going
+// by the container ID in its cgroup path. It needs the host PID namespace
+// (or host /proc mounted at host_proc).
+func pidInContainer(pid int, id string) bool {
+	data, err := ioutil.ReadFile(filepath.Join(hostProc, strconv.Itoa(pid), "cgroup"))
+	return err == nil && bytes.Contains(data, []byte(id))
+}
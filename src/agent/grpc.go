@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The Stats service of proto/stats.proto, served on grpc_listen. net/http
+// speaks HTTP/2 only over TLS, so grpc_tls_cert and grpc_tls_key are
+// required; gRPC framing is done by hand like the otlp output does.
+var (
+	grpcListen string
+	grpcCert   string
+	grpcKey    string
+	grpcServer *http.Server
+
+	streams = &sampleHub{subscribers: map[chan []sample]struct{}{}}
+)
+
+const grpcSubscribe = "/dockerstats.v1.Stats/Subscribe"
+
+// grpcMaxRequest bounds the request message a client may send, the same 4 MiB
+// limit gRPC servers default to. A subscription request is a few bytes.
+const grpcMaxRequest = 4 << 20
+
+// gRPC status codes used by the service.
+const (
+	grpcInvalid           = 3
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcUnavailable       = 14
+)
+
+// sampleHub hands the samples of every tick to the subscribed streams. A
+// stream that falls a few ticks behind misses ticks rather than holding up
+// collection.
+type sampleHub struct {
+	mu          sync.Mutex
+	subscribers map[chan []sample]struct{}
+	closed      bool
+}
+
+func (h *sampleHub) subscribe() (chan []sample, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, false
+	}
+	ch := make(chan []sample, 8)
+	h.subscribers[ch] = struct{}{}
+	return ch, true
+}
+
+func (h *sampleHub) unsubscribe(ch chan []sample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[ch]; ok {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (h *sampleHub) publish(samples []sample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- samples:
+		default:
+			logrus.Warn("grpc subscriber too slow, dropped a tick")
+		}
+	}
+}
+
+// close ends every stream, on shutdown.
+func (h *sampleHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for ch := range h.subscribers {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// startGRPC serves the Stats service in the background when grpc_listen is set.
+func startGRPC() error {
+	if grpcListen == "" {
+		return nil
+	}
+	if grpcCert == "" || grpcKey == "" {
+		return errors.New("grpc_listen needs grpc_tls_cert and grpc_tls_key")
+	}
+	// Fail at startup rather than in the background on an unreadable pair.
+	for _, path := range []string{grpcCert, grpcKey} {
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+	}
+	grpcServer = &http.Server{Addr: grpcListen, Handler: http.HandlerFunc(serveGRPC)}
+	go func() {
+		if err := grpcServer.ListenAndServeTLS(grpcCert, grpcKey); err != nil && err != http.ErrServerClosed {
+			logrus.WithFields(logrus.Fields{"grpc_listen": grpcListen, "error": err}).Error("grpc server stopped")
+		}
+	}()
+	return nil
+}
+
+func serveGRPC(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	if r.URL.Path != grpcSubscribe {
+		grpcStatus(w, grpcUnimplemented, "unknown method "+r.URL.Path)
+		return
+	}
+
+	var header [5]byte
+	if _, err := io.ReadFull(r.Body, header[:]); err != nil {
+		grpcStatus(w, grpcInvalid, "missing request message")
+		return
+	}
+	if header[0] != 0 {
+		grpcStatus(w, grpcUnimplemented, "compressed messages are not supported")
+		return
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > grpcMaxRequest {
+		grpcStatus(w, grpcResourceExhausted, fmt.Sprintf("request message of %d bytes exceeds the limit of %d", size, grpcMaxRequest))
+		return
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r.Body, body); err != nil {
+		grpcStatus(w, grpcInvalid, "truncated request message")
+		return
+	}
+	var ids, names []string
+	err := protoScan(body, func(field, wire int, _ uint64, b []byte) {
+		switch {
+		case field == 1 && wire == wireBytes:
+			ids = append(ids, string(b))
+		case field == 2 && wire == wireBytes:
+			names = append(names, strings.TrimPrefix(string(b), "/"))
+		}
+	})
+	if err != nil {
+		grpcStatus(w, grpcInvalid, err.Error())
+		return
+	}
+
+	ch, ok := streams.subscribe()
+	if !ok {
+		grpcStatus(w, grpcUnavailable, "shutting down")
+		return
+	}
+	defer streams.unsubscribe(ch)
+
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	host, _ := os.Hostname()
+	for {
+		var samples []sample
+		select {
+		case <-r.Context().Done():
+			return
+		case samples, ok = <-ch:
+		}
+		if !ok {
+			w.Header().Set("Grpc-Status", strconv.Itoa(grpcUnavailable))
+			w.Header().Set("Grpc-Message", "shutting down")
+			return
+		}
+		now := time.Now().UnixNano()
+		var frames []byte
+		for _, s := range samples {
+			if !subscribed(s, ids, names) {
+				continue
+			}
+			msg := protoSample(s, host, now)
+			var prefix [5]byte
+			binary.BigEndian.PutUint32(prefix[1:], uint32(len(msg)))
+			frames = append(append(frames, prefix[:]...), msg...)
+		}
+		if len(frames) == 0 {
+			continue
+		}
+		if _, err := w.Write(frames); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// grpcStatus ends a call that didn't stream anything, with the status in
+// the headers.
+func grpcStatus(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", message)
+	w.WriteHeader(http.StatusOK)
+}
+
+func subscribed(s sample, ids, names []string) bool {
+	if len(ids) == 0 && len(names) == 0 {
+		return true
+	}
+	for _, id := range ids {
+		if strings.HasPrefix(s.ID, id) {
+			return true
+		}
+	}
+	name := containerLabels(s)["name"]
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// healthVersion is bumped whenever the JSON body of /health changes incompatibly.
+const healthVersion = 1
+
+// pingTimeout bounds the Ping of a /health request, so a hung daemon fails
+// the probe rather than holding it open.
+const pingTimeout = 5 * time.Second
+
+// lastScrape holds the time.Time of the last tick that listed containers successfully.
+var lastScrape atomic.Value
+
+type healthStatus struct {
+	Version    int    `json:"version"`
+	Status     string `json:"status"`
+	Docker     string `json:"docker"`
+	Error      string `json:"error,omitempty"`
+	LastScrape string `json:"last_scrape,omitempty"`
+}
+
+// health reports whether the Docker daemon is reachable. It answers with JSON
+// unless the caller asks for plain text via ?format=text or Accept: text/plain.
+func health(w http.ResponseWriter, r *http.Request) {
+	status, code := healthStatus{Version: healthVersion, Status: "ok", Docker: "reachable"}, http.StatusOK
+	ctx, cancel := context.WithTimeout(r.Context(), pingTimeout)
+	defer cancel()
+	if !dockerConnected() {
+		status.Status, status.Docker, status.Error = "degraded", "connecting", "not connected to docker yet"
+		code = http.StatusServiceUnavailable
+	} else if _, err := dockerClient.Ping(ctx); err != nil {
+		status.Status, status.Docker, status.Error = "error", "unreachable", err.Error()
+		code = http.StatusServiceUnavailable
+	}
+	if t, ok := lastScrape.Load().(time.Time); ok {
+		status.LastScrape = t.UTC().Format(time.RFC3339)
+	}
+
+	if r.URL.Query().Get("format") == "text" || strings.HasPrefix(r.Header.Get("Accept"), "text/plain") {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(code)
+		if status.Error != "" {
+			fmt.Fprintln(w, status.Error)
+			return
+		}
+		fmt.Fprint(w, "OK")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// ready is set once a tick listed the containers and exported what it
+// collected, none on an empty host.
+var ready int32
+
+func markReady() {
+	if atomic.CompareAndSwapInt32(&ready, 0, 1) {
+		logrus.Info("first tick collected, ready")
+	}
+}
+
+type readyStatus struct {
+	Status string `json:"status"`
+}
+
+// readiness answers 503 until the first tick collected, so orchestrators don't
+// route to an agent whose /stats isn't filled in yet.
+func readiness(w http.ResponseWriter, r *http.Request) {
+	status, code := readyStatus{Status: "ready"}, http.StatusOK
+	if atomic.LoadInt32(&ready) == 0 {
+		status.Status, code = "not_ready", http.StatusServiceUnavailable
+	}
+
+	if r.URL.Query().Get("format") == "text" || strings.HasPrefix(r.Header.Get("Accept"), "text/plain") {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(code)
+		fmt.Fprint(w, status.Status)
+		return
+	}
+	writeJSON(w, code, status)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// historyStore keeps every record in an embedded bbolt database at
+// history_path, so /history can answer for past ticks even across restarts.
+// Records are keyed by collection time, so queries and pruning walk a range
+// of keys, and records older than the retention window are pruned in the
+// background.
+type historyStore struct {
+	path      string
+	retention time.Duration
+
+	db *bolt.DB
+}
+
+type historyEntry struct {
+	Collected time.Time              `json:"collected"`
+	ID        string                 `json:"id"`
+	Record    map[string]interface{} `json:"record"`
+}
+
+var history = &historyStore{retention: 24 * time.Hour}
+
+var historyBucket = []byte("history")
+
+// historyPruneBatch is how many records one prune transaction deletes, so a
+// large backlog doesn't hold up the writes of the ticks.
+const historyPruneBatch = 1000
+
+// open opens the database, creating it when missing, prunes what a previous
+// run left past the retention and keeps pruning until shutdown.
+func (h *historyStore) open() error {
+	if h.path == "" {
+		return nil
+	}
+	db, err := bolt.Open(h.path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(historyBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return err
+	}
+	h.db = db
+	if err := h.prune(time.Now()); err != nil {
+		return err
+	}
+	go h.pruneEvery(background.Done())
+	return nil
+}
+
+// close closes the database on shutdown.
+func (h *historyStore) close() {
+	if h.db != nil {
+		h.db.Close()
+	}
+}
+
+// historyKey orders records by collection time; the sequence tells apart
+// those collected at the same instant.
+func historyKey(at time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(at.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+func (h *historyStore) update(samples []sample) {
+	if h.db == nil || len(samples) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(historyBucket)
+		for _, s := range samples {
+			value, err := json.Marshal(historyEntry{Collected: now, ID: s.ID, Record: s.Fields})
+			if err != nil {
+				continue
+			}
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			if err := b.Put(historyKey(now, seq), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"history_path": h.path, "error": err}).Error("error writing history")
+	}
+}
+
+// pruneEvery prunes about every 1/24th of the retention, at most once a
+// minute, until done is closed.
+func (h *historyStore) pruneEvery(done <-chan struct{}) {
+	every := h.retention / 24
+	if every < time.Minute {
+		every = time.Minute
+	}
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if err := h.prune(now); err != nil {
+				logrus.WithFields(logrus.Fields{"history_path": h.path, "error": err}).Error("error pruning history")
+			}
+		}
+	}
+}
+
+// prune deletes the records past the retention, a batch per transaction.
+func (h *historyStore) prune(now time.Time) error {
+	cutoff := historyKey(now.Add(-h.retention), 0)
+	for {
+		deleted := 0
+		err := h.db.Update(func(tx *bolt.Tx) error {
+			c := tx.Bucket(historyBucket).Cursor()
+			for k, _ := c.First(); k != nil && bytes.Compare(k, cutoff) < 0 && deleted < historyPruneBatch; k, _ = c.Next() {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+				deleted++
+			}
+			return nil
+		})
+		if err != nil || deleted < historyPruneBatch {
+			return err
+		}
+	}
+}
+
+// query returns the entries matching hq, oldest first, keeping the latest
+// limit of them. It reads a snapshot of the database, so writes go on.
+func (h *historyStore) query(hq historyQuery) ([]historyEntry, error) {
+	list := []historyEntry{}
+	until := historyKey(hq.until, ^uint64(0))
+	err := h.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(historyBucket).Cursor()
+		for k, v := c.Seek(historyKey(hq.since, 0)); k != nil && bytes.Compare(k, until) <= 0; k, v = c.Next() {
+			var e historyEntry
+			if json.Unmarshal(v, &e) != nil || !hq.match(e) {
+				continue
+			}
+			if list = append(list, e); len(list) > hq.limit {
+				list = list[1:]
+			}
+		}
+		return nil
+	})
+	return list, err
+}
+
+// historyQuery selects entries for /history: id, name, since, until and limit.
+type historyQuery struct {
+	id, name     string
+	since, until time.Time
+	limit        int
+}
+
+func parseHistoryQuery(q url.Values, now time.Time) (historyQuery, error) {
+	hq := historyQuery{id: q.Get("id"), name: strings.TrimPrefix(q.Get("name"), "/"), since: now.Add(-time.Hour), until: now, limit: 1000}
+	for param, t := range map[string]*time.Time{"since": &hq.since, "until": &hq.until} {
+		v := q.Get(param)
+		if v == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(v); err == nil {
+			*t = now.Add(-d)
+		} else if *t, err = time.Parse(time.RFC3339, v); err != nil {
+			return hq, fmt.Errorf("invalid %s %q, expected a duration such as 30m or an RFC 3339 time", param, v)
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return hq, fmt.Errorf("invalid limit %q", v)
+		}
+		hq.limit = n
+	}
+	return hq, nil
+}
+
+func (hq historyQuery) match(e historyEntry) bool {
+	if e.Collected.Before(hq.since) || e.Collected.After(hq.until) {
+		return false
+	}
+	if hq.id != "" && !strings.HasPrefix(e.ID, hq.id) {
+		return false
+	}
+	if hq.name != "" {
+		names, _ := e.Record["Names"].([]interface{})
+		for _, n := range names {
+			if s, _ := n.(string); strings.TrimPrefix(s, "/") == hq.name {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// serveHistory returns the stored records, oldest first, keeping the latest
+// limit of them.
+func serveHistory(w http.ResponseWriter, r *http.Request) {
+	if history.path == "" {
+		http.Error(w, "history is off, set history_path", http.StatusNotFound)
+		return
+	}
+	hq, err := parseHistoryQuery(r.URL.Query(), time.Now().UTC())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Records past the retention remain until the next prune.
+	if cutoff := time.Now().UTC().Add(-history.retention); hq.since.Before(cutoff) {
+		hq.since = cutoff
+	}
+	list, err := history.query(hq)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	collectHost bool
+	// hostDataRoot is where the disk usage of the Docker data root is read,
+	// the daemon's own DockerRootDir when empty. An agent in a container
+	// needs the host directory mounted and pointed at.
+	hostDataRoot string
+
+	hostWarnOnce sync.Once
+	lastHost     = &recordList{}
+)
+
+// hostGauges are the host readings exposed on /metrics.
+var hostGauges = []struct {
+	reading, name, help, kind string
+}{
+	{"LOAD1", "docker_host_load1", "Host load average over 1 minute.", "gauge"},
+	{"LOAD5", "docker_host_load5", "Host load average over 5 minutes.", "gauge"},
+	{"LOAD15", "docker_host_load15", "Host load average over 15 minutes.", "gauge"},
+	{"MEM_TOTAL_BYTES", "docker_host_memory_total_bytes", "Host memory.", "gauge"},
+	{"MEM_AVAILABLE_BYTES", "docker_host_memory_available_bytes", "Host memory available without swapping.", "gauge"},
+	{"SWAP_TOTAL_BYTES", "docker_host_swap_total_bytes", "Host swap space.", "gauge"},
+	{"SWAP_USED_BYTES", "docker_host_swap_used_bytes", "Host swap space in use.", "gauge"},
+	{"DISK_TOTAL_BYTES", "docker_host_data_root_size_bytes", "Size of the filesystem of the Docker data root.", "gauge"},
+	{"DISK_FREE_BYTES", "docker_host_data_root_free_bytes", "Space left to unprivileged users on the filesystem of the Docker data root.", "gauge"},
+	{"NET_RX_BYTES", "docker_host_network_receive_bytes_total", "Bytes received by the host's interfaces.", "counter"},
+	{"NET_TX_BYTES", "docker_host_network_transmit_bytes_total", "Bytes sent by the host's interfaces.", "counter"},
+	{"NET_RX_PACKETS", "docker_host_network_receive_packets_total", "Packets received by the host's interfaces.", "counter"},
+	{"NET_TX_PACKETS", "docker_host_network_transmit_packets_total", "Packets sent by the host's interfaces.", "counter"},
+}
+
+func init() {
+	registerMetrics(hostMetrics)
+}
+
+// hostTick exports a record of the host: its load average, memory, the disk
+// usage of the Docker data root and the traffic of its interfaces. A part
+// that can't be read is left out.
+func hostTick(ctx context.Context) {
+	host, _ := os.Hostname()
+	readings := map[string]float64{}
+	values := map[string]interface{}{}
+	fields := logrus.Fields{"Record": recordHost, "Host": host, "Stats": values}
+	for _, part := range []struct {
+		name string
+		read func(map[string]float64, map[string]interface{}) error
+	}{
+		{"load average", hostLoad},
+		{"memory", hostMemory},
+		{"data root", func(r map[string]float64, v map[string]interface{}) error {
+			root, err := hostDisk(ctx, r, v)
+			if err == nil {
+				fields["DataRoot"] = root
+			}
+			return err
+		}},
+		{"network", hostNetwork},
+	} {
+		if err := part.read(readings, values); err != nil {
+			logged := false
+			hostWarnOnce.Do(func() {
+				logrus.WithFields(logrus.Fields{"part": part.name, "error": err}).Warn("cannot read host metrics")
+				logged = true
+			})
+			if !logged {
+				logrus.WithFields(logrus.Fields{"part": part.name, "error": err}).Debug("cannot read host metrics")
+			}
+		}
+	}
+
+	record := sample{ID: host, Fields: fields, Values: readings}
+	lastHost.set([]sample{record})
+	exportRecords([]sample{record})
+}
+
+// hostLoad adds LOAD1, LOAD5 and LOAD15 from loadavg.
+func hostLoad(readings map[string]float64, values map[string]interface{}) error {
+	data, err := ioutil.ReadFile(filepath.Join(hostProc, "loadavg"))
+	if err != nil {
+		return err
+	}
+	cols := strings.Fields(string(data))
+	if len(cols) < 3 {
+		return fmt.Errorf("unexpected loadavg %q", strings.TrimSpace(string(data)))
+	}
+	for i, name := range []string{"LOAD1", "LOAD5", "LOAD15"} {
+		v, err := strconv.ParseFloat(cols[i], 64)
+		if err != nil {
+			return err
+		}
+		readings[name] = v
+		values[name] = formatDecimal(v)
+	}
+	return nil
+}
+
+// hostMemory adds the host's memory and swap from meminfo. Used memory is
+// what isn't available, so the page cache doesn't count.
+func hostMemory(readings map[string]float64, values map[string]interface{}) error {
+	f, err := os.Open(filepath.Join(hostProc, "meminfo"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	kb := map[string]uint64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// MemTotal:       16310112 kB
+		cols := strings.Fields(scanner.Text())
+		if len(cols) < 2 {
+			continue
+		}
+		if v, err := strconv.ParseUint(cols[1], 10, 64); err == nil {
+			kb[strings.TrimSuffix(cols[0], ":")] = v
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	total, ok := kb["MemTotal"]
+	if !ok {
+		return fmt.Errorf("no MemTotal in meminfo")
+	}
+	available, ok := kb["MemAvailable"]
+	if !ok {
+		// Kernels before 3.14 don't estimate it.
+		available = kb["MemFree"] + kb["Buffers"] + kb["Cached"]
+	}
+	used := total - available
+	swapUsed := kb["SwapTotal"] - kb["SwapFree"]
+
+	readings["MEM_TOTAL_BYTES"] = float64(total * 1024)
+	readings["MEM_AVAILABLE_BYTES"] = float64(available * 1024)
+	readings["MEM_USED_BYTES"] = float64(used * 1024)
+	readings["SWAP_TOTAL_BYTES"] = float64(kb["SwapTotal"] * 1024)
+	readings["SWAP_USED_BYTES"] = float64(swapUsed * 1024)
+	values["MEM_TOTAL_MB"] = formatMB(total * 1024)
+	values["MEM_AVAILABLE_MB"] = formatMB(available * 1024)
+	values["MEM_USED_MB"] = formatMB(used * 1024)
+	values["SWAP_TOTAL_MB"] = formatMB(kb["SwapTotal"] * 1024)
+	values["SWAP_USED_MB"] = formatMB(swapUsed * 1024)
+	if total > 0 {
+		readings["MEM_PCT"] = float64(used) / float64(total) * 100
+		values["MEM_PCT"] = formatDecimal(float64(used) / float64(total) * 100)
+	}
+	return nil
+}
+
+// dataRoot is the daemon's DockerRootDir, asked once.
+var dataRoot struct {
+	sync.Mutex
+	path string
+}
+
+// hostDisk adds the size, used and free space of the filesystem holding the
+// Docker data root, and returns the data root.
+func hostDisk(ctx context.Context, readings map[string]float64, values map[string]interface{}) (string, error) {
+	path := hostDataRoot
+	if path == "" {
+		dataRoot.Lock()
+		if dataRoot.path == "" {
+			info, err := dockerClient.Info(ctx)
+			if err != nil {
+				dataRoot.Unlock()
+				return "", err
+			}
+			dataRoot.path = info.DockerRootDir
+		}
+		path = dataRoot.path
+		dataRoot.Unlock()
+	}
+	if path == "" {
+		return "", fmt.Errorf("the daemon didn't report its data root, set host_data_root")
+	}
+
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return "", err
+	}
+	bsize := uint64(fs.Bsize)
+	total := fs.Blocks * bsize
+	free := fs.Bavail * bsize
+	used := total - fs.Bfree*bsize
+	readings["DISK_TOTAL_BYTES"] = float64(total)
+	readings["DISK_USED_BYTES"] = float64(used)
+	readings["DISK_FREE_BYTES"] = float64(free)
+	values["DISK_TOTAL_MB"] = formatMB(total)
+	values["DISK_USED_MB"] = formatMB(used)
+	values["DISK_FREE_MB"] = formatMB(free)
+	// Like df, the share of what users can have, leaving the root reserve out.
+	if used+free > 0 {
+		readings["DISK_PCT"] = float64(used) / float64(used+free) * 100
+		values["DISK_PCT"] = formatDecimal(float64(used) / float64(used+free) * 100)
+	}
+	return path, nil
+}
+
+// hostNetwork adds the traffic of the host's interfaces from the network
+// namespace of PID 1. The loopback and the interfaces Docker creates for
+// containers are left out, their traffic crosses a host interface anyway or
+// never leaves the host.
+func hostNetwork(readings map[string]float64, values map[string]interface{}) error {
+	f, err := os.Open(filepath.Join(hostProc, "1", "net", "dev"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var rxBytes, rxPackets, txBytes, txPackets uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// eth0: rx bytes packets errs drop fifo frame compressed multicast tx bytes packets ...
+		line := scanner.Text()
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		name := strings.TrimSpace(line[:i])
+		if name == "lo" || strings.HasPrefix(name, "veth") || strings.HasPrefix(name, "docker") || strings.HasPrefix(name, "br-") {
+			continue
+		}
+		cols := strings.Fields(line[i+1:])
+		if len(cols) < 10 {
+			continue
+		}
+		rxBytes += parseCounter(cols[0])
+		rxPackets += parseCounter(cols[1])
+		txBytes += parseCounter(cols[8])
+		txPackets += parseCounter(cols[9])
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	readings["NET_RX_BYTES"] = float64(rxBytes)
+	readings["NET_TX_BYTES"] = float64(txBytes)
+	readings["NET_RX_PACKETS"] = float64(rxPackets)
+	readings["NET_TX_PACKETS"] = float64(txPackets)
+	values["NET_RX_MB"] = formatMB(rxBytes)
+	values["NET_TX_MB"] = formatMB(txBytes)
+	values["NET_RX_PACKETS"] = rxPackets
+	values["NET_TX_PACKETS"] = txPackets
+	return nil
+}
+
+func parseCounter(v string) uint64 {
+	n, _ := strconv.ParseUint(v, 10, 64)
+	return n
+}
+
+// hostMetrics exposes the last host record.
+func hostMetrics() []metric {
+	records := lastHost.get()
+	if !collectHost || len(records) == 0 {
+		return nil
+	}
+	r := records[0]
+	families := make([]metric, 0, len(hostGauges))
+	for _, g := range hostGauges {
+		if v, ok := r.Values[g.reading]; ok {
+			families = append(families, metric{name: g.name, help: g.help, kind: g.kind, samples: []metricSample{{labels: map[string]string{}, value: v}}})
+		}
+	}
+	return families
+}
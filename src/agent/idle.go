@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// cumulativeReadings are counters; a container is idle on them when they grew
+// by no more than the threshold since the last tick.
+var cumulativeReadings = map[string]bool{
+	"NET_READ_BYTES":  true,
+	"NET_WRITE_BYTES": true,
+	"BLK_READ_BYTES":  true,
+	"BLK_WRITE_BYTES": true,
+	"BLK_SERVICE_NS":  true,
+	"BLK_OPS":         true,
+}
+
+type idleState struct {
+	last    map[string]float64
+	skipped int
+}
+
+// idleFilter holds back the records of containers that stayed at or under
+// every threshold this tick, still letting one through every heartbeat ticks
+// so consumers can tell an idle container from a vanished one.
+type idleFilter struct {
+	mu         sync.Mutex
+	thresholds map[string]float64
+	heartbeat  int
+	state      map[string]*idleState
+}
+
+var idle = &idleFilter{heartbeat: 10, state: map[string]*idleState{}}
+
+// parseIdleThresholds reads the readings that count towards idleness and their
+// thresholds from JSON such as {"CPU_PCT":0.5,"NET_READ_BYTES":0}.
+func parseIdleThresholds(v string) (map[string]float64, error) {
+	var thresholds map[string]float64
+	if err := json.Unmarshal([]byte(v), &thresholds); err != nil {
+		return nil, err
+	}
+	if len(thresholds) == 0 {
+		return nil, fmt.Errorf("no readings given")
+	}
+	for metric, t := range thresholds {
+		if t < 0 {
+			return nil, fmt.Errorf("negative threshold for %s", metric)
+		}
+	}
+	return thresholds, nil
+}
+
+// filter drops the samples of idle containers.
+func (f *idleFilter) filter(samples []sample) []sample {
+	if len(f.thresholds) == 0 {
+		return samples
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	kept := samples[:0:0]
+	for _, s := range samples {
+		st, ok := f.state[s.ID]
+		if !ok {
+			st = &idleState{}
+			f.state[s.ID] = st
+		}
+		isIdle := ok && f.idle(st, s.Values)
+		st.last = s.Values
+
+		if isIdle && st.skipped+1 < f.heartbeat {
+			st.skipped++
+			continue
+		}
+		st.skipped = 0
+		kept = append(kept, s)
+	}
+	return kept
+}
+
+func (f *idleFilter) idle(st *idleState, values map[string]float64) bool {
+	for metric, threshold := range f.thresholds {
+		v, ok := values[metric]
+		if !ok {
+			continue
+		}
+		if cumulativeReadings[metric] {
+			v -= st.last[metric]
+		}
+		if v > threshold {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *idleFilter) forget(id string) {
+	f.mu.Lock()
+	delete(f.state, id)
+	f.mu.Unlock()
+}
+
+func (f *idleFilter) size() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.state)
+}
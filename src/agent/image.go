@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+)
+
+var collectImageAge bool
+
+// imageCache remembers image creation times by image ID. An ID names immutable
+// content, so entries never go stale.
+type imageCache struct {
+	mu      sync.Mutex
+	created map[string]time.Time
+}
+
+var images = &imageCache{created: map[string]time.Time{}}
+
+func (c *imageCache) createdAt(ctx context.Context, imageID string) (time.Time, error) {
+	c.mu.Lock()
+	t, ok := c.created[imageID]
+	c.mu.Unlock()
+	if ok {
+		return t, nil
+	}
+
+	if err := enrichPool.acquire(ctx); err != nil {
+		return time.Time{}, err
+	}
+	inspect, _, err := dockerClient.ImageInspectWithRaw(ctx, imageID)
+	enrichPool.release()
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err = time.Parse(time.RFC3339Nano, inspect.Created)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	c.mu.Lock()
+	c.created[imageID] = t
+	c.mu.Unlock()
+	return t, nil
+}
+
+// imageFields reports when the image of a container was built, a rough hint
+// for containers running on stale images.
+func imageFields(ctx context.Context, c types.Container, fields logrus.Fields) {
+	if c.ImageID == "" {
+		return
+	}
+	created, err := images.createdAt(ctx, c.ImageID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ImageID": c.ImageID, "error": err}).Debug("error inspecting image")
+		return
+	}
+	fields["ImageCreated"] = created.UTC().Format(time.RFC3339)
+	fields["ImageAgeDays"] = int(time.Since(created).Hours() / 24)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	collectImages bool
+	// imagesInterval is how often the images are listed with their sizes,
+	// which the daemon computes by walking every layer.
+	imagesInterval = 5 * time.Minute
+)
+
+var lastImages = &recordList{}
+
+func init() {
+	registerMetrics(imageMetrics)
+}
+
+// imageRecords returns a summary record of the host's images, with how many
+// there are, how many are dangling and the disk space all of them take, and
+// a record per image with its size.
+func imageRecords(ctx context.Context) ([]sample, error) {
+	du, err := dockerClient.DiskUsage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var dangling int
+	var danglingSize int64
+	records := make([]sample, 0, len(du.Images)+1)
+	for _, img := range du.Images {
+		tags := imageTags(img)
+		// Layers shared with other images stay when a dangling one goes.
+		unique := img.Size
+		if img.SharedSize > 0 {
+			unique -= img.SharedSize
+		}
+		if len(tags) == 0 {
+			dangling++
+			danglingSize += unique
+		}
+		name := "<none>"
+		if len(tags) > 0 {
+			name = tags[0]
+		}
+		readings := map[string]float64{
+			"IMAGE_SIZE_BYTES":        float64(img.Size),
+			"IMAGE_UNIQUE_SIZE_BYTES": float64(unique),
+		}
+		values := map[string]interface{}{
+			"IMAGE_SIZE_MB":        formatMB(uint64(img.Size)),
+			"IMAGE_UNIQUE_SIZE_MB": formatMB(uint64(unique)),
+		}
+		// The daemon reports -1 for what it didn't count.
+		if img.Containers >= 0 {
+			readings["IMAGE_CONTAINERS"] = float64(img.Containers)
+			values["IMAGE_CONTAINERS"] = img.Containers
+		}
+		records = append(records, sample{
+			ID:     img.ID,
+			Labels: img.Labels,
+			Fields: logrus.Fields{
+				"Record":   recordImage,
+				"ImageID":  img.ID,
+				"Image":    name,
+				"Tags":     tags,
+				"Created":  time.Unix(img.Created, 0).UTC().Format(time.RFC3339),
+				"Dangling": len(tags) == 0,
+				"Stats":    values,
+			},
+			Values: readings,
+		})
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+
+	summary := sample{
+		ID: recordImages,
+		Fields: logrus.Fields{
+			"Record": recordImages,
+			"Stats": map[string]interface{}{
+				"IMAGES":                  len(du.Images),
+				"IMAGES_DANGLING":         dangling,
+				"IMAGES_SIZE_MB":          formatMB(uint64(du.LayersSize)),
+				"IMAGES_DANGLING_SIZE_MB": formatMB(uint64(danglingSize)),
+			},
+		},
+		Values: map[string]float64{
+			"IMAGES":                     float64(len(du.Images)),
+			"IMAGES_DANGLING":            float64(dangling),
+			"IMAGES_SIZE_BYTES":          float64(du.LayersSize),
+			"IMAGES_DANGLING_SIZE_BYTES": float64(danglingSize),
+		},
+	}
+	return append([]sample{summary}, records...), nil
+}
+
+// imageTags returns the tags of an image, none when it's dangling.
+func imageTags(img *types.ImageSummary) []string {
+	tags := []string{}
+	for _, tag := range img.RepoTags {
+		if tag != "<none>:<none>" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// imageMetrics exposes the last image records, the per-image sizes labeled
+// by image ID and name.
+func imageMetrics() []metric {
+	if !collectImages {
+		return nil
+	}
+	totals := []struct {
+		reading, name, help string
+	}{
+		{"IMAGES", "docker_images", "Images on the host."},
+		{"IMAGES_DANGLING", "docker_images_dangling", "Images without a tag."},
+		{"IMAGES_SIZE_BYTES", "docker_images_disk_usage_bytes", "Disk space taken by the layers of all images."},
+		{"IMAGES_DANGLING_SIZE_BYTES", "docker_images_dangling_bytes", "Disk space removing the dangling images would free."},
+	}
+	families := make([]metric, 0, len(totals)+1)
+	records := lastImages.get()
+	for _, t := range totals {
+		m := metric{name: t.name, help: t.help, kind: "gauge"}
+		for _, r := range records {
+			if v, ok := r.Values[t.reading]; ok && r.ID == recordImages {
+				m.samples = append(m.samples, metricSample{labels: map[string]string{}, value: v})
+			}
+		}
+		families = append(families, m)
+	}
+	size := metric{name: "docker_image_size_bytes", help: "Size of an image, layers shared with other images included.", kind: "gauge"}
+	for _, r := range records {
+		v, ok := r.Values["IMAGE_SIZE_BYTES"]
+		if !ok {
+			continue
+		}
+		name, _ := r.Fields["Image"].(string)
+		id := strings.TrimPrefix(r.ID, "sha256:")
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		size.samples = append(size.samples, metricSample{labels: map[string]string{"image_id": id, "image": name}, value: v})
+	}
+	return append(families, size)
+}
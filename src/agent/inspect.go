@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+type inspectEntry struct {
+	info      types.ContainerJSON
+	inspected time.Time
+}
+
+// inspectCache remembers ContainerInspect results so enrichment doesn't cost a
+// daemon round trip per container per tick. Entries older than ttl are
+// inspected again so metadata such as health follows the container; a ttl of
+// zero keeps them until the container goes away.
+type inspectCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]inspectEntry
+	hits    uint64
+	misses  uint64
+}
+
+var inspects = &inspectCache{ttl: 5 * time.Minute, entries: map[string]inspectEntry{}}
+
+func init() {
+	registerMetrics(inspects.metrics)
+}
+
+// get returns the cached inspect result for id, inspecting the container on a
+// miss or when the entry expired.
+func (c *inspectCache) get(ctx context.Context, id string) (types.ContainerJSON, error) {
+	now := time.Now()
+	c.mu.Lock()
+	e, ok := c.entries[id]
+	if ok && (c.ttl == 0 || now.Sub(e.inspected) < c.ttl) {
+		c.hits++
+		c.mu.Unlock()
+		return e.info, nil
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	if err := enrichPool.acquire(ctx); err != nil {
+		return types.ContainerJSON{}, err
+	}
+	info, err := dockerClient.ContainerInspect(ctx, id)
+	enrichPool.release()
+	if err != nil {
+		return info, err
+	}
+
+	c.mu.Lock()
+	c.entries[id] = inspectEntry{info: info, inspected: now}
+	c.mu.Unlock()
+	return info, nil
+}
+
+// invalidate drops the cached result for id so the next get inspects again.
+func (c *inspectCache) invalidate(id string) {
+	c.mu.Lock()
+	delete(c.entries, id)
+	c.mu.Unlock()
+}
+
+func (c *inspectCache) forget(id string) { c.invalidate(id) }
+
+func (c *inspectCache) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+func (c *inspectCache) metrics() []metric {
+	c.mu.Lock()
+	hits, misses := c.hits, c.misses
+	c.mu.Unlock()
+	return []metric{
+		counter("docker_stats_inspect_cache_hits_total", "Inspect results served from the cache.", float64(hits)),
+		counter("docker_stats_inspect_cache_misses_total", "Inspect results fetched from the daemon, including expired entries.", float64(misses)),
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+)
+
+// stateFields adds what docker inspect says about the container's life:
+// Created and StartedAt, UPTIME_SECONDS while it runs, RESTART_COUNT and the
+// EXIT_CODE of its last exit, with OOMKilled when the OOM killer ended it.
+// The daemon clears both when the container starts again, so they mostly
+// show on restarting containers; RESTART_COUNT keeps growing.
+func stateFields(ctx context.Context, c types.Container, fields logrus.Fields, readings map[string]float64, values map[string]interface{}) {
+	inspect, err := inspects.get(ctx, c.ID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err}).Warn("error inspecting container")
+		return
+	}
+	if inspect.ContainerJSONBase == nil {
+		return
+	}
+	if created, err := time.Parse(time.RFC3339Nano, inspect.Created); err == nil {
+		fields["Created"] = created.UTC().Format(time.RFC3339)
+	}
+	readings["RESTART_COUNT"] = float64(inspect.RestartCount)
+	values["RESTART_COUNT"] = inspect.RestartCount
+	state := inspect.State
+	if state == nil {
+		return
+	}
+	readings["EXIT_CODE"] = float64(state.ExitCode)
+	values["EXIT_CODE"] = state.ExitCode
+	if state.OOMKilled {
+		fields["OOMKilled"] = true
+	}
+	healthFields(state.Health, fields, readings, values)
+	started, err := time.Parse(time.RFC3339Nano, state.StartedAt)
+	if err != nil || started.Year() < 2 {
+		return
+	}
+	fields["StartedAt"] = started.UTC().Format(time.RFC3339)
+	if state.Running {
+		uptime := time.Since(started).Seconds()
+		if uptime < 0 {
+			uptime = 0
+		}
+		readings["UPTIME_SECONDS"] = uptime
+		values["UPTIME_SECONDS"] = int64(uptime)
+	}
+}
+
+// healthOutputMax bounds the probe output reported in Health.
+const healthOutputMax = 256
+
+// healthFields reports the healthcheck of containers that define one: Health
+// with its Status (starting, healthy or unhealthy), FailingStreak, and the
+// ExitCode and Output of the last probe, the output cut at healthOutputMax
+// bytes. HEALTHY is 1 while healthy and 0 otherwise.
+func healthFields(health *types.Health, fields logrus.Fields, readings map[string]float64, values map[string]interface{}) {
+	if health == nil || health.Status == "" || health.Status == types.NoHealthcheck {
+		return
+	}
+	h := map[string]interface{}{"Status": health.Status, "FailingStreak": health.FailingStreak}
+	if n := len(health.Log); n > 0 && health.Log[n-1] != nil {
+		last := health.Log[n-1]
+		h["ExitCode"] = last.ExitCode
+		h["Output"] = truncateString(strings.TrimSpace(last.Output), healthOutputMax)
+	}
+	fields["Health"] = h
+
+	healthy := 0
+	if health.Status == types.Healthy {
+		healthy = 1
+	}
+	readings["HEALTHY"] = float64(healthy)
+	readings["HEALTH_FAILING_STREAK"] = float64(health.FailingStreak)
+	values["HEALTHY"] = healthy
+	values["HEALTH_FAILING_STREAK"] = health.FailingStreak
+}
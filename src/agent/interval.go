@@ -0,0 +1,156 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+)
+
+// intervalLabel lets a container ask for its own collection cadence, e.g.
+// docker-stats.interval=10s.
+const intervalLabel = "docker-stats.interval"
+
+const minInterval = time.Second
+
+// intervalScheduler runs a dedicated ticker for every container carrying a
+// valid interval label. Those containers are left out of the global tick.
+type intervalScheduler struct {
+	mu      sync.Mutex
+	running map[string]*labelledTicker
+	held    map[string]sample // latest sample of each, for the next tick document
+}
+
+type labelledTicker struct {
+	interval time.Duration
+	stop     chan struct{}
+
+	mu        sync.Mutex
+	container types.Container
+}
+
+var intervals = &intervalScheduler{running: map[string]*labelledTicker{}, held: map[string]sample{}}
+
+// schedule starts or stops per-container tickers to match the listed
+// containers and returns those that should be collected on the global tick.
+func (s *intervalScheduler) schedule(containers []types.Container) []types.Container {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	global := make([]types.Container, 0, len(containers))
+	listed := make(map[string]bool, len(containers))
+	for _, c := range containers {
+		listed[c.ID] = true
+
+		interval, ok := labelInterval(c)
+		if !ok {
+			s.stopLocked(c.ID)
+			global = append(global, c)
+			continue
+		}
+
+		if t, ok := s.running[c.ID]; ok {
+			if t.interval == interval {
+				t.update(c)
+				continue
+			}
+			s.stopLocked(c.ID)
+		}
+		t := &labelledTicker{interval: interval, stop: make(chan struct{}), container: c}
+		s.running[c.ID] = t
+		go t.run()
+	}
+
+	for id := range s.running {
+		if !listed[id] {
+			s.stopLocked(id)
+		}
+	}
+	return global
+}
+
+// stopAll stops every per-container ticker.
+func (s *intervalScheduler) stopAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id := range s.running {
+		s.stopLocked(id)
+	}
+}
+
+func (s *intervalScheduler) stopLocked(id string) {
+	if t, ok := s.running[id]; ok {
+		close(t.stop)
+		delete(s.running, id)
+	}
+}
+
+// hold keeps the latest samples of labelled containers until the next tick.
+func (s *intervalScheduler) hold(samples []sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, smp := range samples {
+		s.held[smp.ID] = smp
+	}
+}
+
+// drain returns the samples held since the previous tick.
+func (s *intervalScheduler) drain() []sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.held) == 0 {
+		return nil
+	}
+	samples := make([]sample, 0, len(s.held))
+	for id, smp := range s.held {
+		samples = append(samples, smp)
+		delete(s.held, id)
+	}
+	return samples
+}
+
+// labelInterval parses the interval label of a container, rejecting absent,
+// malformed or too short values so they fall back to the global interval.
+func labelInterval(c types.Container) (time.Duration, bool) {
+	v, ok := c.Labels[intervalLabel]
+	if !ok {
+		return 0, false
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < minInterval {
+		logrus.WithFields(logrus.Fields{"Names": c.Names, intervalLabel: v}).Debug("ignoring invalid interval label")
+		return 0, false
+	}
+	return d, true
+}
+
+// update refreshes the listing (state, status) reported for the container.
+func (t *labelledTicker) update(c types.Container) {
+	t.mu.Lock()
+	t.container = c
+	t.mu.Unlock()
+}
+
+func (t *labelledTicker) run() {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		t.mu.Lock()
+		c := t.container
+		t.mu.Unlock()
+
+		if !beginTick() {
+			return
+		}
+		ctx, cancel := tickContext(t.interval)
+		exportLabelled(collect(collectionContext(ctx, collectLabelled), []types.Container{c}))
+		cancel()
+		inFlight.Done()
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
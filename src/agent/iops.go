@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// calculateBlockIOOps adds up the read and write operations serviced on all
+// devices, ok is false when the kernel didn't report them.
+func calculateBlockIOOps(blkio types.BlkioStats) (reads uint64, writes uint64, ok bool) {
+	for _, e := range blkio.IoServicedRecursive {
+		switch strings.ToLower(e.Op) {
+		case "read":
+			reads += e.Value
+		case "write":
+			writes += e.Value
+		}
+	}
+	return reads, writes, len(blkio.IoServicedRecursive) > 0
+}
+
+type opsReading struct {
+	at            time.Time
+	reads, writes uint64
+}
+
+// opsRate keeps the last operation counts of every container to turn them
+// into operations per second.
+type opsRate struct {
+	mu   sync.Mutex
+	last map[string]opsReading
+}
+
+var iops = &opsRate{last: map[string]opsReading{}}
+
+// observe returns the read and write operations per second since the
+// previous reading, recording the counts read at the given time as the next
+// one when keep is set. ok is false on the first reading and when the
+// counters went back, e.g. on a restart.
+func (r *opsRate) observe(id string, reads, writes uint64, at time.Time, keep bool) (readRate, writeRate float64, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	prev, seen := r.last[id]
+	if keep {
+		r.last[id] = opsReading{at: at, reads: reads, writes: writes}
+	}
+	elapsed := at.Sub(prev.at).Seconds()
+	if !seen || elapsed <= 0 || reads < prev.reads || writes < prev.writes {
+		return 0, 0, false
+	}
+	return float64(reads-prev.reads) / elapsed, float64(writes-prev.writes) / elapsed, true
+}
+
+func (r *opsRate) forget(id string) {
+	r.mu.Lock()
+	delete(r.last, id)
+	r.mu.Unlock()
+}
+
+func (r *opsRate) size() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.last)
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"time"
+)
+
+// Just enough of the Kafka protocol to produce: Metadata v1 to find the
+// partition leaders, Produce v3 with v2 record batches, and SaslHandshake v1
+// plus SaslAuthenticate v0 for SASL/PLAIN. Kafka 1.0 or later is required.
+const (
+	kafkaProduce          = 0
+	kafkaMetadata         = 3
+	kafkaSaslHandshake    = 17
+	kafkaSaslAuthenticate = 36
+
+	kafkaClientID = "docker-stats"
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// kafkaError is an error code of a Kafka response.
+type kafkaError int16
+
+func (e kafkaError) Error() string {
+	switch e {
+	case 3:
+		return "kafka: unknown topic or partition"
+	case 5:
+		return "kafka: leader not available"
+	case 6:
+		return "kafka: not leader for partition"
+	case 7:
+		return "kafka: request timed out"
+	case 10:
+		return "kafka: message too large"
+	case 29:
+		return "kafka: topic authorization failed"
+	case 58:
+		return "kafka: SASL authentication failed"
+	default:
+		return fmt.Sprintf("kafka: error code %d", int16(e))
+	}
+}
+
+type kafkaMessage struct {
+	key, value []byte
+	time       time.Time
+}
+
+// kafkaConn is a connection to one broker.
+type kafkaConn struct {
+	conn        net.Conn
+	correlation int32
+}
+
+func dialKafka(address string, tlsConfig *tls.Config, user, password string) (*kafkaConn, error) {
+	conn, err := net.DialTimeout("tcp", address, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		config := tlsConfig.Clone()
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(address)
+		}
+		conn = tls.Client(conn, config)
+	}
+	c := &kafkaConn{conn: conn}
+	if user != "" {
+		if err := c.authenticate(user, password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *kafkaConn) authenticate(user, password string) error {
+	var w kafkaWriter
+	w.string("PLAIN")
+	r, err := c.request(kafkaSaslHandshake, 1, w.buf)
+	if err != nil {
+		return err
+	}
+	if code := r.int16(); code != 0 {
+		return fmt.Errorf("kafka broker doesn't offer SASL/PLAIN: %v", kafkaError(code))
+	}
+
+	w = kafkaWriter{}
+	w.bytes([]byte("\x00" + user + "\x00" + password))
+	if r, err = c.request(kafkaSaslAuthenticate, 0, w.buf); err != nil {
+		return err
+	}
+	if code := r.int16(); code != 0 {
+		if msg := r.nullableString(); msg != "" {
+			return errors.New("kafka: " + msg)
+		}
+		return kafkaError(code)
+	}
+	return r.err
+}
+
+// request sends a request and reads its response. Produce requests with
+// acks=0 have none, so noResponse skips the read.
+func (c *kafkaConn) request(apiKey, version int16, body []byte) (*kafkaReader, error) {
+	return c.roundTrip(apiKey, version, body, false)
+}
+
+func (c *kafkaConn) roundTrip(apiKey, version int16, body []byte, noResponse bool) (*kafkaReader, error) {
+	c.correlation++
+	var w kafkaWriter
+	w.int32(0)
+	w.int16(apiKey)
+	w.int16(version)
+	w.int32(c.correlation)
+	w.string(kafkaClientID)
+	w.buf = append(w.buf, body...)
+	binary.BigEndian.PutUint32(w.buf, uint32(len(w.buf)-4))
+
+	c.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	if _, err := c.conn.Write(w.buf); err != nil {
+		return nil, err
+	}
+	if noResponse {
+		return nil, nil
+	}
+	var size [4]byte
+	if _, err := io.ReadFull(c.conn, size[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(c.conn, resp); err != nil {
+		return nil, err
+	}
+	r := &kafkaReader{buf: resp}
+	if id := r.int32(); id != c.correlation {
+		return nil, fmt.Errorf("kafka: response %d to request %d", id, c.correlation)
+	}
+	return r, nil
+}
+
+func (c *kafkaConn) Close() error { return c.conn.Close() }
+
+// kafkaTopic lists the leader of every partition of a topic.
+type kafkaTopic struct {
+	brokers map[int32]string
+	leaders []int32
+}
+
+func (c *kafkaConn) metadata(topic string) (kafkaTopic, error) {
+	var w kafkaWriter
+	w.int32(1)
+	w.string(topic)
+	r, err := c.request(kafkaMetadata, 1, w.buf)
+	if err != nil {
+		return kafkaTopic{}, err
+	}
+
+	t := kafkaTopic{brokers: map[int32]string{}}
+	for n := r.int32(); n > 0 && r.err == nil; n-- {
+		id := r.int32()
+		host := r.string()
+		port := r.int32()
+		r.nullableString() // rack
+		t.brokers[id] = net.JoinHostPort(host, fmt.Sprint(port))
+	}
+	r.int32() // controller
+	for n := r.int32(); n > 0 && r.err == nil; n-- {
+		code := r.int16()
+		name := r.string()
+		r.int8() // internal
+		partitions := r.int32()
+		if name == topic && code != 0 {
+			return kafkaTopic{}, kafkaError(code)
+		}
+		for ; partitions > 0 && r.err == nil; partitions-- {
+			r.int16()
+			id := r.int32()
+			leader := r.int32()
+			r.skipInt32s() // replicas
+			r.skipInt32s() // isr
+			if name != topic {
+				continue
+			}
+			for int(id) >= len(t.leaders) {
+				t.leaders = append(t.leaders, -1)
+			}
+			t.leaders[id] = leader
+		}
+	}
+	if r.err != nil {
+		return kafkaTopic{}, r.err
+	}
+	if len(t.leaders) == 0 {
+		return kafkaTopic{}, kafkaError(3)
+	}
+	return t, nil
+}
+
+// produce writes the messages to one partition as a single record batch.
+func (c *kafkaConn) produce(topic string, partition int32, acks int16, messages []kafkaMessage) error {
+	var w kafkaWriter
+	w.int16(-1) // transactional id
+	w.int16(acks)
+	w.int32(30000)
+	w.int32(1)
+	w.string(topic)
+	w.int32(1)
+	w.int32(partition)
+	w.bytes(kafkaRecordBatch(messages))
+	r, err := c.roundTrip(kafkaProduce, 3, w.buf, acks == 0)
+	if err != nil || r == nil {
+		return err
+	}
+	for topics := r.int32(); topics > 0 && r.err == nil; topics-- {
+		r.string()
+		for partitions := r.int32(); partitions > 0 && r.err == nil; partitions-- {
+			r.int32()
+			if code := r.int16(); code != 0 {
+				return kafkaError(code)
+			}
+			r.int64() // base offset
+			r.int64() // log append time
+		}
+	}
+	return r.err
+}
+
+// kafkaRecordBatch encodes messages in the v2 (magic 2) record batch format.
+func kafkaRecordBatch(messages []kafkaMessage) []byte {
+	first := messages[0].time.UnixNano() / int64(time.Millisecond)
+	last := first
+	var records kafkaWriter
+	for i, m := range messages {
+		ts := m.time.UnixNano() / int64(time.Millisecond)
+		if ts > last {
+			last = ts
+		}
+		var rec kafkaWriter
+		rec.int8(0)
+		rec.varint(ts - first)
+		rec.varint(int64(i))
+		rec.varbytes(m.key)
+		rec.varbytes(m.value)
+		rec.varint(0) // headers
+		records.varint(int64(len(rec.buf)))
+		records.buf = append(records.buf, rec.buf...)
+	}
+
+	// Everything from the attributes on is covered by the CRC.
+	var body kafkaWriter
+	body.int16(0) // attributes: no compression
+	body.int32(int32(len(messages) - 1))
+	body.int64(first)
+	body.int64(last)
+	body.int64(-1) // producer id
+	body.int16(-1) // producer epoch
+	body.int32(-1) // base sequence
+	body.int32(int32(len(messages)))
+	body.buf = append(body.buf, records.buf...)
+
+	var w kafkaWriter
+	w.int64(0)
+	w.int32(int32(4 + 1 + 4 + len(body.buf))) // leader epoch, magic, crc
+	w.int32(-1)
+	w.int8(2)
+	w.int32(int32(crc32.Checksum(body.buf, crc32c)))
+	w.buf = append(w.buf, body.buf...)
+	return w.buf
+}
+
+// kafkaPartition picks the partition of a key like the Java client's default
+// partitioner, so consumers see the same key-to-partition mapping.
+func kafkaPartition(key []byte, partitions int) int32 {
+	return int32((murmur2(key) & 0x7fffffff) % uint32(partitions))
+}
+
+func murmur2(data []byte) uint32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+	h := uint32(seed) ^ uint32(len(data))
+	for ; len(data) >= 4; data = data[4:] {
+		k := binary.LittleEndian.Uint32(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	switch len(data) {
+	case 3:
+		h ^= uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
+
+type kafkaWriter struct{ buf []byte }
+
+func (w *kafkaWriter) int8(v int8) { w.buf = append(w.buf, byte(v)) }
+
+func (w *kafkaWriter) int16(v int16) {
+	w.buf = append(w.buf, byte(v>>8), byte(v))
+}
+
+func (w *kafkaWriter) int32(v int32) {
+	w.buf = append(w.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (w *kafkaWriter) int64(v int64) {
+	w.int32(int32(v >> 32))
+	w.int32(int32(v))
+}
+
+func (w *kafkaWriter) string(s string) {
+	w.int16(int16(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *kafkaWriter) bytes(b []byte) {
+	w.int32(int32(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+// varint writes a zigzag encoded varint, as used inside record batches.
+func (w *kafkaWriter) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf = append(w.buf, b[:binary.PutVarint(b[:], v)]...)
+}
+
+func (w *kafkaWriter) varbytes(b []byte) {
+	if b == nil {
+		w.varint(-1)
+		return
+	}
+	w.varint(int64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+// kafkaReader decodes a response, remembering the first short read.
+type kafkaReader struct {
+	buf []byte
+	err error
+}
+
+func (r *kafkaReader) next(n int) []byte {
+	if r.err != nil {
+		return make([]byte, n)
+	}
+	if len(r.buf) < n {
+		r.err = errors.New("kafka: short response")
+		return make([]byte, n)
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *kafkaReader) int8() int8   { return int8(r.next(1)[0]) }
+func (r *kafkaReader) int16() int16 { return int16(binary.BigEndian.Uint16(r.next(2))) }
+func (r *kafkaReader) int32() int32 { return int32(binary.BigEndian.Uint32(r.next(4))) }
+func (r *kafkaReader) int64() int64 { return int64(binary.BigEndian.Uint64(r.next(8))) }
+
+func (r *kafkaReader) string() string {
+	n := r.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.next(int(n)))
+}
+
+func (r *kafkaReader) nullableString() string { return r.string() }
+
+func (r *kafkaReader) skipInt32s() {
+	for n := r.int32(); n > 0 && r.err == nil; n-- {
+		r.int32()
+	}
+}
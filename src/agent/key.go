@@ -0,0 +1,21 @@
+package main
+
+import "strings"
+
+// Record keys: the full container ID is stable and unique, a name is readable
+// but is reused when a container is recreated under the same name.
+const (
+	keyByID   = "id"
+	keyByName = "name"
+)
+
+var keyBy = keyByID
+
+// containerKey returns the key outputs file a container's records under, and
+// the value of the id label or tag of its series.
+func containerKey(s sample) string {
+	if names, ok := s.Fields["Names"].([]string); ok && keyBy == keyByName && len(names) > 0 {
+		return strings.TrimPrefix(names[0], "/")
+	}
+	return s.ID
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	labelsMap     = "map"
+	labelsNested  = "nested"
+	labelsDedoted = "dedotted"
+)
+
+var (
+	labelsFormat = labelsMap
+	labelsDedot  = "_"
+
+	// labelRenames lifts labels out of the label map into fields of their own,
+	// keyed by label.
+	labelRenames map[string]string
+)
+
+// reservedFields can't be the target of a label rename.
+var reservedFields = map[string]bool{
+	"Key": true, "ID": true, "Names": true, "Image": true, "ImageID": true,
+	"State": true, "Status": true, "OS": true, "Stats": true, "Labels": true, "labels": true,
+}
+
+// parseLabelRenames reads JSON such as {"com.docker.compose.service":"service"}.
+func parseLabelRenames(v string) (map[string]string, error) {
+	var renames map[string]string
+	if err := json.Unmarshal([]byte(v), &renames); err != nil {
+		return nil, err
+	}
+	seen := map[string]string{}
+	for label, field := range renames {
+		if field == "" {
+			return nil, fmt.Errorf("label %s has no field name", label)
+		}
+		if reservedFields[field] {
+			return nil, fmt.Errorf("label %s can't be renamed to the reserved field %s", label, field)
+		}
+		if other, ok := seen[field]; ok {
+			return nil, fmt.Errorf("labels %s and %s are both renamed to %s", other, label, field)
+		}
+		seen[field] = label
+	}
+	return renames, nil
+}
+
+// labelFields adds a container's labels to a record in the configured shape:
+//
+//	map       Labels: {"com.docker.compose.project": "web"} (default)
+//	nested    labels: {"com": {"docker": {"compose": {"project": "web"}}}}
+//	dedotted  labels: {"com_docker_compose_project": "web"}
+//
+// Labels named in label_fields become fields of their own instead.
+func labelFields(fields map[string]interface{}, labels map[string]string) {
+	if len(labelRenames) > 0 {
+		rest := make(map[string]string, len(labels))
+		for k, v := range labels {
+			if field, ok := labelRenames[k]; ok {
+				fields[field] = v
+			} else {
+				rest[k] = v
+			}
+		}
+		labels = rest
+	}
+
+	switch labelsFormat {
+	case labelsNested:
+		fields["labels"] = nestLabels(labels)
+	case labelsDedoted:
+		dedotted := make(map[string]string, len(labels))
+		for k, v := range labels {
+			dedotted[strings.Replace(k, ".", labelsDedot, -1)] = v
+		}
+		fields["labels"] = dedotted
+	default:
+		fields["Labels"] = labels
+	}
+}
+
+// nestLabels splits label keys on dots into nested objects. When a key is also
+// the prefix of another key (a and a.b), its own value is kept under "_value".
+func nestLabels(labels map[string]string) map[string]interface{} {
+	root := map[string]interface{}{}
+	for key, value := range labels {
+		node := root
+		parts := strings.Split(key, ".")
+		for _, part := range parts[:len(parts)-1] {
+			switch child := node[part].(type) {
+			case map[string]interface{}:
+				node = child
+			case string:
+				next := map[string]interface{}{"_value": child}
+				node[part] = next
+				node = next
+			default:
+				next := map[string]interface{}{}
+				node[part] = next
+				node = next
+			}
+		}
+		leaf := parts[len(parts)-1]
+		if child, ok := node[leaf].(map[string]interface{}); ok {
+			child["_value"] = value
+		} else {
+			node[leaf] = value
+		}
+	}
+	return root
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type lifespan struct {
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// lifespanTracker remembers when each container was first listed and when its
+// stats were last collected.
+type lifespanTracker struct {
+	mu    sync.Mutex
+	spans map[string]lifespan
+}
+
+var lifespans = &lifespanTracker{spans: map[string]lifespan{}}
+
+// listed records the first sighting of the given containers.
+func (t *lifespanTracker) listed(ids []string, now time.Time) {
+	t.mu.Lock()
+	for _, id := range ids {
+		if _, ok := t.spans[id]; !ok {
+			t.spans[id] = lifespan{firstSeen: now}
+		}
+	}
+	t.mu.Unlock()
+}
+
+// scraped marks a successful collection, remembered when keep is set, and
+// adds both timestamps to fields.
+func (t *lifespanTracker) scraped(id string, now time.Time, fields logrus.Fields, keep bool) {
+	t.mu.Lock()
+	span, ok := t.spans[id]
+	if !ok {
+		span.firstSeen = now
+	}
+	span.lastSeen = now
+	if keep {
+		t.spans[id] = span
+	}
+	t.mu.Unlock()
+
+	fields["FirstSeen"] = span.firstSeen.UTC().Format(time.RFC3339)
+	fields["LastSeen"] = span.lastSeen.UTC().Format(time.RFC3339)
+}
+
+func (t *lifespanTracker) forget(id string) {
+	t.mu.Lock()
+	delete(t.spans, id)
+	t.mu.Unlock()
+}
+
+func (t *lifespanTracker) size() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.spans)
+}
@@ -2,23 +2,37 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
+	"math"
 	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/client"
 	"github.com/robfig/cron"
 	"github.com/sirupsen/logrus"
 )
 
 var (
-	dockerClient  *client.Client
+	dockerClient  dockerAPI
 	statsInterval = os.Getenv("stats_interval")
 	logFormat     = os.Getenv("log_format")
 	logLevel      = os.Getenv("log_level")
+	replayDir     = os.Getenv("replay_dir")
+	outputs       = os.Getenv("outputs")
+	dockerConnect = os.Getenv("docker_connect")
+
+	// hostCPUs is the daemon's CPU count, captured when connecting.
+	hostCPUs int
+
+	maxContainers     = 0
+	containerPriority = os.Getenv("container_priority")
 )
 
 func init() {
@@ -35,6 +49,95 @@ func init() {
 		statsInterval = "@every 1m"
 	}
 
+	if outputs == "" {
+		outputs = "log"
+	}
+
+	if v := os.Getenv("metrics_precision"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < -1 {
+			logrus.WithFields(logrus.Fields{"metrics_precision": v}).Warn("invalid metrics_precision, using full precision")
+		} else {
+			metricsPrecision = n
+		}
+	}
+
+	if v := os.Getenv("metrics_labels"); v != "" {
+		metricsLabels = splitList(v)
+	}
+
+	if v := os.Getenv("rounding_mode"); v != "" {
+		switch v {
+		case roundTruncate, roundHalfUp, roundHalfEven:
+			roundingMode = v
+		default:
+			logrus.WithFields(logrus.Fields{"rounding_mode": v}).Warn("invalid rounding_mode, using half_up")
+		}
+	}
+
+	if v := os.Getenv("tick_deadline"); v != "" {
+		d, fraction, err := parseTickDeadline(v)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"tick_deadline": v, "error": err}).Warn("invalid tick_deadline, using default")
+		} else {
+			tickDeadline, tickDeadlineFraction = d, fraction
+		}
+	}
+
+	if v := os.Getenv("tick_drift_warn"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			logrus.WithFields(logrus.Fields{"tick_drift_warn": v}).Warn("invalid tick_drift_warn, using default")
+		} else {
+			drift.warn = d
+		}
+	}
+
+	if v := os.Getenv("shutdown_timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			logrus.WithFields(logrus.Fields{"shutdown_timeout": v}).Warn("invalid shutdown_timeout, using default")
+		} else {
+			shutdownTimeout = d
+		}
+	}
+
+	switch dockerConnect {
+	case connectFailFast, connectRetry:
+	case "":
+		dockerConnect = connectFailFast
+	default:
+		logrus.WithFields(logrus.Fields{"docker_connect": dockerConnect}).Warn("invalid docker_connect, using fail_fast")
+		dockerConnect = connectFailFast
+	}
+
+	if v := os.Getenv("stats_mode"); v != "" {
+		switch v {
+		case statsModeOneshot, statsModeBurst, statsModeStream:
+			statsMode = v
+		default:
+			logrus.WithFields(logrus.Fields{"stats_mode": v}).Warn("invalid stats_mode, using oneshot")
+		}
+	}
+
+	if v := os.Getenv("key_by"); v != "" {
+		switch v {
+		case keyByID, keyByName:
+			keyBy = v
+		default:
+			logrus.WithFields(logrus.Fields{"key_by": v}).Warn("invalid key_by, using id")
+		}
+	}
+
+	if v := os.Getenv("output_check"); v != "" {
+		switch v {
+		case outputCheckWarn, outputCheckFail, outputCheckRetry:
+			outputCheck = v
+		default:
+			logrus.WithFields(logrus.Fields{"output_check": v}).Warn("invalid output_check, using warn")
+		}
+	}
+
 	switch logFormat {
 	case "text":
 		logrus.SetFormatter(&logrus.TextFormatter{})
@@ -48,6 +151,289 @@ func init() {
 	case "info":
 		logrus.SetLevel(logrus.InfoLevel)
 	}
+
+	if v := os.Getenv("max_containers"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			logrus.WithFields(logrus.Fields{"max_containers": v}).Warn("invalid max_containers, collecting all containers")
+		} else {
+			maxContainers = n
+		}
+	}
+
+	if v := os.Getenv("env_allowlist"); v != "" {
+		envAllowlist = splitList(v)
+	}
+
+	if v := os.Getenv("env_redact_pattern"); v != "" {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"env_redact_pattern": v, "error": err}).Warn("invalid env_redact_pattern, using default")
+		} else {
+			envRedact = re
+		}
+	}
+
+	if v := os.Getenv("alert_rules"); v != "" {
+		rules, err := parseAlertRules(v)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"alert_rules": v, "error": err}).Warn("invalid alert_rules, alerting disabled")
+		} else {
+			alerts.rules = rules
+		}
+	}
+
+	if v := os.Getenv("idle_thresholds"); v != "" {
+		thresholds, err := parseIdleThresholds(v)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"idle_thresholds": v, "error": err}).Warn("invalid idle_thresholds, idle containers are kept")
+		} else {
+			idle.thresholds = thresholds
+		}
+	}
+
+	if v := os.Getenv("idle_heartbeat_ticks"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			logrus.WithFields(logrus.Fields{"idle_heartbeat_ticks": v}).Warn("invalid idle_heartbeat_ticks, using default")
+		} else {
+			idle.heartbeat = n
+		}
+	}
+
+	if v := os.Getenv("inspect_cache_ttl"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			logrus.WithFields(logrus.Fields{"inspect_cache_ttl": v}).Warn("invalid inspect_cache_ttl, using default")
+		} else {
+			inspects.ttl = d
+		}
+	}
+
+	if v := os.Getenv("collect_disk_usage"); v != "" {
+		collectDiskUsage, _ = strconv.ParseBool(v)
+	}
+
+	if v := os.Getenv("disk_usage_interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			logrus.WithFields(logrus.Fields{"disk_usage_interval": v}).Warn("invalid disk_usage_interval, using default")
+		} else {
+			diskUsage.interval = d
+		}
+	}
+
+	if v := os.Getenv("collect_volumes"); v != "" {
+		collectVolumes, _ = strconv.ParseBool(v)
+	}
+
+	if v := os.Getenv("volumes_interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			logrus.WithFields(logrus.Fields{"volumes_interval": v}).Warn("invalid volumes_interval, using default")
+		} else {
+			volumesInterval = d
+		}
+	}
+
+	if v := os.Getenv("collect_images"); v != "" {
+		collectImages, _ = strconv.ParseBool(v)
+	}
+
+	if v := os.Getenv("images_interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			logrus.WithFields(logrus.Fields{"images_interval": v}).Warn("invalid images_interval, using default")
+		} else {
+			imagesInterval = d
+		}
+	}
+
+	if v := os.Getenv("collect_daemon"); v != "" {
+		collectDaemon, _ = strconv.ParseBool(v)
+	}
+
+	if v := os.Getenv("collect_host"); v != "" {
+		collectHost, _ = strconv.ParseBool(v)
+	}
+
+	hostDataRoot = os.Getenv("host_data_root")
+
+	if v := os.Getenv("collect_events"); v != "" {
+		collectEvents, _ = strconv.ParseBool(v)
+	}
+
+	if v := os.Getenv("event_actions"); v != "" {
+		eventActions = map[string]bool{}
+		for _, action := range splitList(v) {
+			eventActions[action] = true
+		}
+	}
+
+	if v := os.Getenv("collect_sockets"); v != "" {
+		collectSockets, _ = strconv.ParseBool(v)
+	}
+
+	if v := os.Getenv("collect_psi"); v != "" {
+		collectPSI, _ = strconv.ParseBool(v)
+	}
+
+	if v := os.Getenv("cgroup_root"); v != "" {
+		cgroupRoot = v
+	}
+
+	if v := os.Getenv("pid_trend_window"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 2 {
+			logrus.WithFields(logrus.Fields{"pid_trend_window": v}).Warn("invalid pid_trend_window, using default")
+		} else {
+			pidTrends.window = n
+		}
+	}
+
+	if v := os.Getenv("pid_growth_warn"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate < 0 {
+			logrus.WithFields(logrus.Fields{"pid_growth_warn": v}).Warn("invalid pid_growth_warn, PID leak warnings disabled")
+		} else {
+			pidTrends.warnRate = rate
+		}
+	}
+
+	if v := os.Getenv("collect_memory_breakdown"); v != "" {
+		collectMemoryBreakdown, _ = strconv.ParseBool(v)
+	}
+
+	for _, p := range pools {
+		env := p.name + "_workers"
+		if v := os.Getenv(env); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				logrus.WithFields(logrus.Fields{env: v}).Warn("invalid " + env + ", using default")
+			} else {
+				p.size = n
+			}
+		}
+		p.start()
+	}
+
+	if v := os.Getenv("collect_percpu"); v != "" {
+		collectPerCPU, _ = strconv.ParseBool(v)
+	}
+
+	if v := os.Getenv("collect_network_interfaces"); v != "" {
+		collectNetworkInterfaces, _ = strconv.ParseBool(v)
+	}
+
+	if v := os.Getenv("collect_block_devices"); v != "" {
+		collectBlockDevices, _ = strconv.ParseBool(v)
+	}
+
+	if v := os.Getenv("collect_processes"); v != "" {
+		collectProcesses, _ = strconv.ParseBool(v)
+	}
+
+	if v := os.Getenv("processes_top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			logrus.WithFields(logrus.Fields{"processes_top": v}).Warn("invalid processes_top, listing no processes")
+		} else {
+			processesTop = n
+		}
+	}
+
+	if v := os.Getenv("collect_gpu"); v != "" {
+		collectGPU, _ = strconv.ParseBool(v)
+	}
+
+	if v := os.Getenv("nvidia_smi"); v != "" {
+		nvidiaSMI = v
+	}
+
+	if v := os.Getenv("collect_fds"); v != "" {
+		collectFDs, _ = strconv.ParseBool(v)
+	}
+
+	if v := os.Getenv("host_proc"); v != "" {
+		hostProc = v
+	}
+
+	if v := os.Getenv("state_retention_ticks"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			logrus.WithFields(logrus.Fields{"state_retention_ticks": v}).Warn("invalid state_retention_ticks, using default")
+		} else {
+			retention.retention = n
+		}
+	}
+
+	switch v := os.Getenv("labels_format"); v {
+	case "", labelsMap, labelsNested, labelsDedoted:
+		if v != "" {
+			labelsFormat = v
+		}
+	default:
+		logrus.WithFields(logrus.Fields{"labels_format": v}).Warn("invalid labels_format, using map")
+	}
+
+	if v := os.Getenv("labels_dedot"); v != "" {
+		labelsDedot = v
+	}
+
+	if v := os.Getenv("label_fields"); v != "" {
+		renames, err := parseLabelRenames(v)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"label_fields": v, "error": err}).Warn("invalid label_fields, labels are not renamed")
+		} else {
+			labelRenames = renames
+		}
+	}
+
+	if v := os.Getenv("exclude_pause"); v != "" {
+		excludePause, _ = strconv.ParseBool(v)
+	}
+
+	if v := os.Getenv("pause_image_pattern"); v != "" {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"pause_image_pattern": v, "error": err}).Warn("invalid pause_image_pattern, using default")
+		} else {
+			pauseImage = re
+		}
+	}
+
+	if v := os.Getenv("swarm_mode"); v != "" {
+		swarmMode, _ = strconv.ParseBool(v)
+	}
+
+	swarmNodeHost = os.Getenv("swarm_node_host")
+
+	if v := os.Getenv("collect_image_age"); v != "" {
+		collectImageAge, _ = strconv.ParseBool(v)
+	}
+
+	history.path = os.Getenv("history_path")
+	if v := os.Getenv("history_retention"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			logrus.WithFields(logrus.Fields{"history_retention": v}).Warn("invalid history_retention, using default")
+		} else {
+			history.retention = d
+		}
+	}
+
+	grpcListen = os.Getenv("grpc_listen")
+	grpcCert = os.Getenv("grpc_tls_cert")
+	grpcKey = os.Getenv("grpc_tls_key")
+
+	switch containerPriority {
+	case priorityRoundRobin, priorityBusiest:
+	case "":
+		containerPriority = priorityRoundRobin
+	default:
+		logrus.WithFields(logrus.Fields{"container_priority": containerPriority}).Warn("invalid container_priority, using round_robin")
+		containerPriority = priorityRoundRobin
+	}
 }
 
 func main() {
@@ -57,90 +443,542 @@ func main() {
 			"log_format":     logFormat,
 			"log_level":      logLevel,
 			"stats_interval": statsInterval,
+			"stats_mode":     statsMode,
+			"replay_dir":     replayDir,
+			"docker_connect": dockerConnect,
+			"outputs":        outputs,
+			"output_check":   outputCheck,
+			"key_by":         keyBy,
+			"routes":         os.Getenv("routes"),
+
+			"max_containers":             maxContainers,
+			"container_priority":         containerPriority,
+			"env_allowlist":              envAllowlist,
+			"env_redact_pattern":         envRedact.String(),
+			"alert_rules":                alerts.rules,
+			"idle_thresholds":            idle.thresholds,
+			"idle_heartbeat_ticks":       idle.heartbeat,
+			"collect_fds":                collectFDs,
+			"host_proc":                  hostProc,
+			"inspect_cache_ttl":          inspects.ttl.String(),
+			"stats_workers":              statsPool.size,
+			"enrich_workers":             enrichPool.size,
+			"pid_trend_window":           pidTrends.window,
+			"pid_growth_warn":            pidTrends.warnRate,
+			"collect_memory_breakdown":   collectMemoryBreakdown,
+			"collect_percpu":             collectPerCPU,
+			"collect_network_interfaces": collectNetworkInterfaces,
+			"collect_block_devices":      collectBlockDevices,
+			"collect_processes":          collectProcesses,
+			"processes_top":              processesTop,
+			"collect_gpu":                collectGPU,
+			"collect_disk_usage":         collectDiskUsage,
+			"disk_usage_interval":        diskUsage.interval.String(),
+			"collect_volumes":            collectVolumes,
+			"volumes_interval":           volumesInterval.String(),
+			"collect_images":             collectImages,
+			"images_interval":            imagesInterval.String(),
+			"collect_daemon":             collectDaemon,
+			"collect_host":               collectHost,
+			"host_data_root":             hostDataRoot,
+			"collect_events":             collectEvents,
+			"event_actions":              describeEventActions(),
+			"collect_sockets":            collectSockets,
+			"collect_psi":                collectPSI,
+			"cgroup_root":                cgroupRoot,
+			"nvidia_smi":                 nvidiaSMI,
+
+			"state_retention_ticks": retention.retention,
+			"labels_format":         labelsFormat,
+			"label_fields":          labelRenames,
+			"shutdown_timeout":      shutdownTimeout.String(),
+			"tick_drift_warn":       drift.warn.String(),
+			"tick_deadline":         describeTickDeadline(),
+			"metrics_precision":     metricsPrecision,
+			"metrics_labels":        metricsLabels,
+			"rounding_mode":         roundingMode,
+			"exclude_pause":         excludePause,
+			"pause_image_pattern":   pauseImage.String(),
+			"swarm_mode":            swarmMode,
+			"swarm_node_host":       swarmNodeHost,
+			"collect_image_age":     collectImageAge,
+			"history_path":          history.path,
+			"history_retention":     history.retention.String(),
+			"grpc_listen":           grpcListen,
 		},
 	}).Info("starting up...")
 
 	var err error
-	dockerClient, err = client.NewEnvClient()
-	if err != nil {
-		logrus.Error(err.Error())
-		return
+	if tickSchedule, err = cron.Parse(statsInterval); err != nil {
+		logrus.WithFields(logrus.Fields{"stats_interval": statsInterval, "error": err}).Error("invalid stats_interval")
+		os.Exit(exitInvalidConfig)
 	}
 
-	stats()
-	c := cron.New()
-	c.AddFunc(statsInterval, stats)
-	c.Start()
+	if err := setupExporters(splitList(outputs)); err != nil {
+		logrus.Error(err.Error())
+		os.Exit(exitInvalidConfig)
+	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		_, err := dockerClient.Ping(context.Background())
+	if v := os.Getenv("routes"); v != "" {
+		if routes, err = parseRoutes(v); err == nil {
+			err = checkRoutes(routes, exporters)
+		}
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			logrus.WithFields(logrus.Fields{"error": err}).Error("invalid routes")
+			os.Exit(exitInvalidConfig)
 		}
-		fmt.Fprint(w, "OK")
-	})
+	}
+
+	if !checkExporters() {
+		os.Exit(exitOutputUnreachable)
+	}
+
+	if err := history.open(); err != nil {
+		logrus.WithFields(logrus.Fields{"history_path": history.path, "error": err}).Error("cannot open history")
+		os.Exit(exitInvalidConfig)
+	}
+
+	if err := startGRPC(); err != nil {
+		logrus.WithFields(logrus.Fields{"grpc_listen": grpcListen, "error": err}).Error("cannot serve grpc")
+		os.Exit(exitInvalidConfig)
+	}
+
+	if dockerConnect == connectRetry {
+		go func() {
+			if connectWithRetry() {
+				startCollection()
+			}
+		}()
+	} else {
+		if err := connectDocker(); err != nil {
+			logrus.WithFields(logrus.Fields{"error": err}).Error("cannot connect to docker")
+			os.Exit(exitDockerUnreachable)
+		}
+		startCollection()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", health)
+	mux.HandleFunc("/ready", readiness)
+	mux.HandleFunc("/metrics", serveMetrics)
+	mux.HandleFunc("/containers", serveContainers)
+	mux.HandleFunc("/stats", serveStats)
+	mux.HandleFunc("/scrape", serveScrape)
+	mux.HandleFunc("/history", serveHistory)
+	mux.HandleFunc("/version", serveVersion)
 
 	server := &http.Server{
 		Addr:    ":80",
 		Handler: mux,
 	}
 
+	done := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		logrus.WithFields(logrus.Fields{"signal": (<-sig).String()}).Info("shutting down")
+		shutdown(server)
+		close(done)
+	}()
+
 	// Start the server and handle errors. ErrServerClosed will ocurr when we call shutdown above.
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		logrus.WithFields(logrus.Fields{"error": err}).Error("shutting down")
 	} else {
-		logrus.Info("shutting down")
+		<-done
+		logrus.Info("shut down")
 	}
 }
 
+// startCollection runs a first tick and schedules the following ones.
+func startCollection() {
+	startEvents()
+	startRecords()
+	stats()
+	c := cron.New()
+	c.Schedule(tickSchedule, cron.FuncJob(stats))
+
+	collectorMu.Lock()
+	defer collectorMu.Unlock()
+	if stopping {
+		return
+	}
+	collector = c
+	c.Start()
+}
+
 // Collect stats from Docker API and log it. This is used to create das
 func stats() {
-	containers, err := dockerClient.ContainerList(context.Background(), types.ContainerListOptions{})
+	if !beginTick() {
+		return
+	}
+	defer inFlight.Done()
+	drift.tick(time.Now())
+
+	tick()
+}
+
+// tick lists the containers, collects the ones due on this tick and exports
+// their samples.
+func tick() []sample {
+	ctx, cancel := tickContext(expectedInterval(time.Now()))
+	defer cancel()
+
+	containers, err := listContainers(ctx)
 	if err != nil {
-		logrus.WithFields(logrus.Fields{"error": err}).Error("error getting container list")
+		category := collectErrors.record("list", err)
+		logrus.WithFields(logrus.Fields{"error": err, "category": category}).Error("error getting container list")
+	} else {
+		lastScrape.Store(time.Now())
+
+		if swarmMode {
+			containers = cluster.expand(ctx, containers)
+		}
+
+		ids := make([]string, 0, len(containers))
+		for _, c := range containers {
+			ids = append(ids, c.ID)
+		}
+		retention.observe(ids)
+		lifespans.listed(ids, time.Now())
+	}
+
+	// Containers on their own interval keep their tickers whatever the cap;
+	// max_containers only bounds the ones collected on the global tick.
+	containers = intervals.schedule(containers)
+	if maxContainers > 0 && len(containers) > maxContainers {
+		logrus.WithFields(logrus.Fields{
+			"containers":         len(containers),
+			"max_containers":     maxContainers,
+			"container_priority": containerPriority,
+		}).Debug("capping containers collected this tick")
 	}
+	containers = tickScheduler.pick(containers, maxContainers, containerPriority)
+
+	samples := collect(ctx, containers)
+	// The daemon and host records go first, to join this tick's document.
+	if collectDaemon {
+		daemonTick(ctx)
+	}
+	if collectHost {
+		hostTick(ctx)
+	}
+	export(samples)
+	if err == nil {
+		markReady()
+	}
+	return samples
+}
 
+// collect fetches the stats of every container concurrently and returns the
+// samples of those that succeeded. When ctx is done before all of them
+// answered, the tick goes on with the samples collected so far and the late
+// ones are dropped.
+func collect(ctx context.Context, containers []types.Container) []sample {
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		samples = make([]sample, 0, len(containers))
+		late    bool
+	)
 	for _, container := range containers {
+		wg.Add(1)
 		go func(container types.Container) {
-			stats, err := dockerClient.ContainerStats(context.Background(), container.ID, false)
-			if err != nil {
-				logrus.WithFields(logrus.Fields{"error": err}).Error("error getting container stats")
+			defer wg.Done()
+			if err := statsPool.acquire(ctx); err != nil {
 				return
 			}
-			defer stats.Body.Close()
-
-			var info *types.StatsJSON
-			if err := json.NewDecoder(stats.Body).Decode(&info); err != nil {
-				logrus.WithFields(logrus.Fields{"error": err}).Error("error decoding stats")
-				return
+			defer statsPool.release()
+			if s, ok := collectContainer(ctx, container); ok {
+				mu.Lock()
+				if !late {
+					samples = append(samples, s)
+				}
+				mu.Unlock()
 			}
-
-			netRead, netWrite := calculateNetwork(info.Networks)
-
-			blkRead, blkWrite := calculateBlockIO(info.BlkioStats)
-
-			logrus.WithFields(logrus.Fields{
-				"Names":   container.Names,
-				"Image":   container.Image,
-				"ImageID": container.ImageID,
-				"Labels":  container.Labels,
-				"State":   container.State,
-				"Status":  container.Status,
-				"OS":      stats.OSType,
-				"Stats": map[string]interface{}{
-					"CPU_PCT":      fmt.Sprintf("%.2f", calculateCPUPercent(info)),
-					"MEM_MB":       fmt.Sprintf("%.2f", float64(info.MemoryStats.Usage)/1024/1024),
-					"MEM_PCT":      fmt.Sprintf("%.2f", 100.0*float64(info.MemoryStats.Usage)/float64(info.MemoryStats.Limit)),
-					"NET_READ_MB":  fmt.Sprintf("%.2f", netRead/1024/1024),
-					"NET_WRITE_MB": fmt.Sprintf("%.2f", netWrite/1024/1024),
-					"BLK_READ_MB":  fmt.Sprintf("%.2f", blkRead/1024/1024),
-					"BLK_WRITE_MB": fmt.Sprintf("%.2f", blkWrite/1024/1024),
-					"PIDS":         info.PidsStats.Current,
-				},
-			}).Info("stats")
 		}(container)
 	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return samples
+	case <-ctx.Done():
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	late = true
+	logrus.WithFields(logrus.Fields{
+		"collected":     len(samples),
+		"containers":    len(containers),
+		"tick_deadline": describeTickDeadline(),
+	}).Warn("tick deadline reached, exporting a partial tick")
+	return samples
+}
+
+// Collections besides the global tick's: a container on its own interval
+// stays out of the state counting global ticks, and a /scrape leaves every
+// piece of state alone so the next tick reports the same.
+const (
+	collectTick = iota
+	collectLabelled
+	collectScrape
+)
+
+type collectionKey struct{}
+
+// collectionContext marks the kind of collection ctx is for.
+func collectionContext(ctx context.Context, kind int) context.Context {
+	return context.WithValue(ctx, collectionKey{}, kind)
+}
+
+// collection returns the kind of collection ctx is for, collectTick unless
+// marked otherwise.
+func collection(ctx context.Context) int {
+	kind, _ := ctx.Value(collectionKey{}).(int)
+	return kind
+}
+
+func collectContainer(ctx context.Context, container types.Container) (sample, bool) {
+	source, local := cluster.source(container.ID)
+	info, osType, err := readStats(ctx, source, container.ID)
+	if err != nil {
+		category := collectErrors.record("stats", err)
+		fields := logrus.Fields{"ID": container.ID, "error": err, "category": category}
+		if category == errContainerGone {
+			logrus.WithFields(fields).Warn("container went away before its stats were read")
+		} else {
+			logrus.WithFields(fields).Error("error getting container stats")
+		}
+		return sample{}, false
+	}
+
+	netRead, netWrite := calculateNetwork(info.Networks)
+	netErrors, hasNetErrors := calculateNetworkErrors(info.Networks)
+
+	blkRead, blkWrite := calculateBlockIO(info.BlkioStats)
+
+	cpuPercent := calculateCPUPercent(info)
+	// Without a limit (stopped containers, some cgroup v2 setups) there's no
+	// percentage to report.
+	hasMemPercent := info.MemoryStats.Limit > 0
+	var memPercent float64
+	if hasMemPercent {
+		memPercent = 100.0 * float64(info.MemoryStats.Usage) / float64(info.MemoryStats.Limit)
+	}
+	workingSet := memoryWorkingSet(info.MemoryStats)
+	kind := collection(ctx)
+	// Only the global tick advances the state counting ticks, and a scrape
+	// keeps no state at all.
+	counted, kept := kind == collectTick, kind != collectScrape
+	if kept {
+		tickScheduler.observe(container.ID, cpuPercent)
+	}
+	readings := map[string]float64{
+		"CPU_PCT":               cpuPercent,
+		"MEM_MB":                float64(info.MemoryStats.Usage) / 1024 / 1024,
+		"PIDS":                  float64(info.PidsStats.Current),
+		"MEM_BYTES":             float64(info.MemoryStats.Usage),
+		"MEM_LIMIT_BYTES":       float64(info.MemoryStats.Limit),
+		"MEM_WORKING_SET_BYTES": float64(workingSet),
+		"NET_READ_BYTES":        float64(netRead),
+		"NET_WRITE_BYTES":       float64(netWrite),
+		"BLK_READ_BYTES":        float64(blkRead),
+		"BLK_WRITE_BYTES":       float64(blkWrite),
+	}
+
+	serviceNs, ops, hasServiceTime := calculateBlockIOTime(info.BlkioStats)
+	var latencyMs float64
+	if hasServiceTime {
+		readings["BLK_SERVICE_NS"] = float64(serviceNs)
+		readings["BLK_OPS"] = float64(ops)
+		if ops > 0 {
+			latencyMs = float64(serviceNs) / float64(ops) / 1e6
+		}
+		readings["BLK_LATENCY_MS"] = latencyMs
+	}
+	readOps, writeOps, hasOps := calculateBlockIOOps(info.BlkioStats)
+	var readIOPS, writeIOPS float64
+	var hasIOPS bool
+	if hasOps {
+		at := info.Read
+		if at.IsZero() {
+			at = time.Now()
+		}
+		readings["BLK_READ_OPS"] = float64(readOps)
+		readings["BLK_WRITE_OPS"] = float64(writeOps)
+		if readIOPS, writeIOPS, hasIOPS = iops.observe(container.ID, readOps, writeOps, at, kept); hasIOPS {
+			readings["BLK_READ_IOPS"] = readIOPS
+			readings["BLK_WRITE_IOPS"] = writeIOPS
+		}
+	}
+	if hasNetErrors {
+		readings["NET_RX_ERRORS"] = float64(netErrors.rxErrors)
+		readings["NET_TX_ERRORS"] = float64(netErrors.txErrors)
+		readings["NET_RX_DROPPED"] = float64(netErrors.rxDropped)
+		readings["NET_TX_DROPPED"] = float64(netErrors.txDropped)
+	}
+	var memParts map[string]uint64
+	if collectMemoryBreakdown {
+		memParts = memoryParts(info.MemoryStats)
+		for name, v := range memParts {
+			readings[name+"_BYTES"] = float64(v)
+		}
+	}
+
+	pidsLimit := info.PidsStats.Limit
+	// Unlimited containers report no limit or, on some kernels, the maximum.
+	hasPidsLimit := pidsLimit > 0 && pidsLimit < math.MaxInt64
+	var pidsPercent float64
+	if hasPidsLimit {
+		pidsPercent = 100.0 * float64(info.PidsStats.Current) / float64(pidsLimit)
+		readings["PIDS_LIMIT"] = float64(pidsLimit)
+		readings["PIDS_PCT"] = pidsPercent
+	}
+
+	oomCount := oomKills.get(container.ID)
+	readings["OOM_KILLS"] = float64(oomCount)
+	_, memV2 := info.MemoryStats.Stats["anon"]
+	hasFailcnt := len(info.MemoryStats.Stats) > 0 && !memV2
+	if hasFailcnt {
+		readings["MEM_FAILCNT"] = float64(info.MemoryStats.Failcnt)
+	}
+
+	if hasMemPercent {
+		readings["MEM_PCT"] = memPercent
+	}
+
+	var pidsDelta int64
+	var pidsGrowth float64
+	if counted {
+		pidsDelta, pidsGrowth = pidTrends.observe(container.ID, container.Names, info.PidsStats.Current, time.Now())
+		readings["PIDS_DELTA"] = float64(pidsDelta)
+		readings["PIDS_GROWTH"] = pidsGrowth
+		alerts.evaluate(container.ID, container.Names, readings)
+	}
+
+	values := map[string]interface{}{
+		"CPU_PCT":            formatDecimal(cpuPercent),
+		"MEM_MB":             formatMB(info.MemoryStats.Usage),
+		"MEM_WORKING_SET_MB": formatMB(workingSet),
+		"NET_READ_MB":        formatMB(netRead),
+		"NET_WRITE_MB":       formatMB(netWrite),
+		"BLK_READ_MB":        formatMB(blkRead),
+		"BLK_WRITE_MB":       formatMB(blkWrite),
+		"PIDS":               info.PidsStats.Current,
+		"OOM_KILLS":          oomCount,
+	}
+	if counted {
+		values["PIDS_DELTA"] = pidsDelta
+		values["PIDS_GROWTH"] = formatDecimal(pidsGrowth)
+	}
+	if hasMemPercent {
+		values["MEM_PCT"] = formatDecimal(memPercent)
+	}
+	if hasFailcnt {
+		values["MEM_FAILCNT"] = info.MemoryStats.Failcnt
+	}
+	if hasNetErrors {
+		values["NET_RX_ERRORS"] = netErrors.rxErrors
+		values["NET_TX_ERRORS"] = netErrors.txErrors
+		values["NET_RX_DROPPED"] = netErrors.rxDropped
+		values["NET_TX_DROPPED"] = netErrors.txDropped
+	}
+	if hasPidsLimit {
+		values["PIDS_LIMIT"] = pidsLimit
+		values["PIDS_PCT"] = formatDecimal(pidsPercent)
+	}
+	for name, v := range memParts {
+		values[name+"_MB"] = formatMB(v)
+	}
+	throttleReadings(info, readings, values)
+	if collectPerCPU {
+		perCPUReadings(perCPUPercents(info), readings, values)
+	}
+	if hasOps {
+		values["BLK_READ_OPS"] = readOps
+		values["BLK_WRITE_OPS"] = writeOps
+	}
+	if hasIOPS {
+		values["BLK_READ_IOPS"] = formatDecimal(readIOPS)
+		values["BLK_WRITE_IOPS"] = formatDecimal(writeIOPS)
+	}
+	if hasServiceTime {
+		values["BLK_OPS"] = ops
+		values["BLK_LATENCY_MS"] = formatDecimal(latencyMs)
+	}
+	fields := logrus.Fields{
+		"ID":      container.ID,
+		"Names":   container.Names,
+		"Image":   container.Image,
+		"ImageID": container.ImageID,
+		"State":   container.State,
+		"Status":  container.Status,
+		"OS":      osType,
+		"Stats":   values,
+	}
+	fields["Key"] = containerKey(sample{ID: container.ID, Fields: fields})
+	if collectNetworkInterfaces {
+		interfaceReadings(info.Networks, fields, readings, values)
+	}
+	if collectBlockDevices {
+		deviceReadings(info.BlkioStats, fields, readings, values)
+	}
+	labelFields(fields, container.Labels)
+	cluster.fields(container.ID, fields)
+	lifespans.scraped(container.ID, time.Now(), fields, kept)
+
+	// Inspect based enrichment is only available for containers of our own daemon.
+	if !local {
+		return sample{ID: container.ID, Labels: container.Labels, Fields: fields, Values: readings}, true
+	}
+
+	networkFields(ctx, container, fields)
+	stateFields(ctx, container, fields, readings, values)
+
+	if collectImageAge {
+		imageFields(ctx, container, fields)
+	}
+
+	if len(envAllowlist) > 0 {
+		if inspect, err := inspects.get(ctx, container.ID); err != nil {
+			logrus.WithFields(logrus.Fields{"error": err}).Warn("error inspecting container")
+		} else if inspect.Config != nil {
+			fields["Env"] = filterEnv(inspect.Config.Env)
+		}
+	}
+
+	if collectProcesses {
+		processFields(ctx, container, fields, readings, values)
+	}
+	if collectGPU {
+		gpuFields(ctx, container, fields, readings, values)
+	}
+	if collectDiskUsage {
+		diskUsageFields(container, readings, values)
+	}
+	if collectSockets {
+		socketFields(ctx, container.ID, readings, values)
+	}
+	if collectPSI {
+		psiFields(ctx, container.ID, readings, values)
+	}
+
+	if collectFDs {
+		if fds, err := countFDs(ctx, container.ID); err != nil {
+			warnFDs(err)
+		} else {
+			values["FDS"] = fds
+			readings["FDS"] = float64(fds)
+		}
+	}
+
+	return sample{ID: container.ID, Labels: container.Labels, Fields: fields, Values: readings}, true
 }
 
 func calculateCPUPercent(stats *types.StatsJSON) float64 {
@@ -150,34 +988,105 @@ func calculateCPUPercent(stats *types.StatsJSON) float64 {
 		cpuDelta = float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
 		// calculate the change for the entire system between readings
 		systemDelta = float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
-		onlineCPUs  = float64(stats.CPUStats.OnlineCPUs)
 	)
 
+	if systemDelta > 0.0 && cpuDelta > 0.0 {
+		cpuPercent = (cpuDelta / systemDelta) * onlineCPUs(stats) * 100.0
+	}
+	return cpuPercent
+}
+
+// onlineCPUs returns the number of cores the system usage is spread over.
+func onlineCPUs(stats *types.StatsJSON) float64 {
+	onlineCPUs := float64(stats.CPUStats.OnlineCPUs)
 	if onlineCPUs == 0.0 {
 		onlineCPUs = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
 	}
-	if systemDelta > 0.0 && cpuDelta > 0.0 {
-		cpuPercent = (cpuDelta / systemDelta) * onlineCPUs * 100.0
+	// cgroup v2 hosts report neither OnlineCPUs nor PercpuUsage, fall back to
+	// the daemon's CPU count and finally to our own.
+	if onlineCPUs == 0.0 {
+		onlineCPUs = float64(hostCPUs)
 	}
-	return cpuPercent
+	if onlineCPUs == 0.0 {
+		onlineCPUs = float64(runtime.NumCPU())
+	}
+	return onlineCPUs
 }
 
-func calculateBlockIO(blkio types.BlkioStats) (blkRead float64, blkWrite float64) {
+func calculateBlockIO(blkio types.BlkioStats) (blkRead uint64, blkWrite uint64) {
 	for _, bioEntry := range blkio.IoServiceBytesRecursive {
 		switch strings.ToLower(bioEntry.Op) {
 		case "read":
-			blkRead += float64(bioEntry.Value)
+			blkRead += bioEntry.Value
 		case "write":
-			blkWrite += float64(bioEntry.Value)
+			blkWrite += bioEntry.Value
 		}
 	}
 	return
 }
 
-func calculateNetwork(network map[string]types.NetworkStats) (netRead float64, netWrite float64) {
+// calculateBlockIOTime sums the time spent servicing IO and the number of IO
+// operations over all devices. Both are only reported under cgroup v1 with
+// the CFQ/BFQ schedulers, ok is false when the kernel didn't provide them.
+func calculateBlockIOTime(blkio types.BlkioStats) (serviceNs uint64, ops uint64, ok bool) {
+	serviceNs = blkioTotal(blkio.IoServiceTimeRecursive)
+	ops = blkioTotal(blkio.IoServicedRecursive)
+	return serviceNs, ops, len(blkio.IoServicedRecursive) > 0
+}
+
+// blkioTotal adds up the "Total" entries of all devices, or reads and writes
+// when no totals are reported.
+func blkioTotal(entries []types.BlkioStatEntry) uint64 {
+	var total, rw uint64
+	hasTotal := false
+	for _, e := range entries {
+		switch strings.ToLower(e.Op) {
+		case "total":
+			total += e.Value
+			hasTotal = true
+		case "read", "write":
+			rw += e.Value
+		}
+	}
+	if hasTotal {
+		return total
+	}
+	return rw
+}
+
+func calculateNetwork(network map[string]types.NetworkStats) (netRead uint64, netWrite uint64) {
 	for _, v := range network {
-		netRead += float64(v.RxBytes)
-		netWrite += float64(v.TxBytes)
+		netRead += v.RxBytes
+		netWrite += v.TxBytes
 	}
 	return
 }
+
+// networkErrors are the receive and transmit errors and dropped packets of a
+// container's interfaces.
+type networkErrors struct {
+	rxErrors, txErrors, rxDropped, txDropped uint64
+}
+
+// calculateNetworkErrors adds up the errors and drops of all interfaces, ok
+// is false when the container has none of its own, as with host networking.
+func calculateNetworkErrors(network map[string]types.NetworkStats) (errs networkErrors, ok bool) {
+	for _, v := range network {
+		errs.rxErrors += v.RxErrors
+		errs.txErrors += v.TxErrors
+		errs.rxDropped += v.RxDropped
+		errs.txDropped += v.TxDropped
+	}
+	return errs, len(network) > 0
+}
+
+// splitList splits a comma separated setting, trimming blanks.
+func splitList(v string) []string {
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestOnlineCPUs(t *testing.T) {
+	defer func(n int) { hostCPUs = n }(hostCPUs)
+
+	tests := []struct {
+		name       string
+		onlineCPUs uint32
+		percpu     []uint64
+		hostCPUs   int
+		want       float64
+	}{
+		{name: "online CPUs", onlineCPUs: 2, percpu: []uint64{1, 2, 3, 4}, hostCPUs: 8, want: 2},
+		{name: "per-CPU usage", percpu: []uint64{1, 2, 3, 4}, hostCPUs: 8, want: 4},
+		{name: "daemon CPUs", percpu: []uint64{}, hostCPUs: 8, want: 8},
+		{name: "daemon CPUs without per-CPU usage", hostCPUs: 6, want: 6},
+		{name: "agent CPUs", percpu: []uint64{}, want: float64(runtime.NumCPU())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hostCPUs = tt.hostCPUs
+			var stats types.StatsJSON
+			stats.CPUStats.OnlineCPUs = tt.onlineCPUs
+			stats.CPUStats.CPUUsage.PercpuUsage = tt.percpu
+			if got := onlineCPUs(&stats); got != tt.want {
+				t.Errorf("onlineCPUs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// sequenceStats answers the nth stats call of a container with frame(id, n).
+type sequenceStats struct {
+	*fakeStats
+	mu    sync.Mutex
+	calls map[string]int
+	frame func(id string, n int) types.StatsJSON
+}
+
+func (f *sequenceStats) ContainerStats(ctx context.Context, id string, stream bool) (types.ContainerStats, error) {
+	f.mu.Lock()
+	n := f.calls[id]
+	f.calls[id]++
+	f.mu.Unlock()
+	data, _ := json.Marshal(f.frame(id, n))
+	return types.ContainerStats{Body: ioutil.NopCloser(bytes.NewReader(data)), OSType: "linux"}, nil
+}
+
+func TestScrapeKeepsTickState(t *testing.T) {
+	defer func(api dockerAPI, m string) { dockerClient, statsMode = api, m }(dockerClient, statsMode)
+	statsMode = statsModeOneshot
+
+	start := time.Now().Add(-time.Minute)
+	// The scrape, the second call, sees the quiet container busy and the
+	// read counter of the busy one ahead of the second tick's.
+	cpu := map[string][]float64{"scrape-busy": {50, 10, 50}, "scrape-quiet": {10, 90, 10}}
+	reads := []uint64{100, 1000, 200}
+	dockerClient = &sequenceStats{fakeStats: newFakeStats(), calls: map[string]int{}, frame: func(id string, n int) types.StatsJSON {
+		var frame types.StatsJSON
+		frame.Read = start.Add(time.Duration(n) * time.Second)
+		frame.CPUStats.OnlineCPUs = 1
+		frame.CPUStats.SystemUsage = 1e9
+		frame.CPUStats.CPUUsage.TotalUsage = uint64(cpu[id][n] * 1e7)
+		frame.MemoryStats.Usage = 1 << 20
+		frame.BlkioStats.IoServicedRecursive = []types.BlkioStatEntry{{Op: "Read", Value: reads[n]}, {Op: "Write", Value: 0}}
+		return frame
+	}}
+	containers := []types.Container{
+		{ID: "scrape-busy", Names: []string{"/scrape-busy"}, State: "running"},
+		{ID: "scrape-quiet", Names: []string{"/scrape-quiet"}, State: "running"},
+	}
+	for _, c := range containers {
+		defer iops.forget(c.ID)
+		defer tickScheduler.forget(c.ID)
+		defer lifespans.forget(c.ID)
+	}
+
+	ctx := context.Background()
+	collect(ctx, containers)
+	if scraped := collect(collectionContext(ctx, collectScrape), containers); len(scraped) != len(containers) {
+		t.Fatalf("scrape collected %d samples of %d containers", len(scraped), len(containers))
+	}
+
+	picked := tickScheduler.pick(containers, 1, priorityBusiest)
+	if len(picked) != 1 || picked[0].ID != "scrape-busy" {
+		t.Errorf("busiest container after the scrape = %v, want scrape-busy", picked)
+	}
+	for _, s := range collect(ctx, containers) {
+		// 100 reads over the 2 seconds since the first tick.
+		if got := s.Values["BLK_READ_IOPS"]; got != 50 {
+			t.Errorf("%s BLK_READ_IOPS = %v, want 50", s.ID, got)
+		}
+	}
+}
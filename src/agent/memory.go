@@ -0,0 +1,75 @@
+package main
+
+import "github.com/docker/docker/api/types"
+
+var collectMemoryBreakdown bool
+
+// memoryBreakdown names a part of the memory usage after its cgroup v1 key
+// and the cgroup v2 key reporting the same thing, empty when that version
+// has none.
+var memoryBreakdown = []struct {
+	name string
+	v1   string
+	v2   string
+}{
+	{"MEM_RSS", "rss", "anon"},
+	{"MEM_CACHE", "cache", "file"},
+	{"MEM_MAPPED", "mapped_file", "file_mapped"},
+	{"MEM_ACTIVE_ANON", "active_anon", "active_anon"},
+	{"MEM_INACTIVE_FILE", "inactive_file", "inactive_file"},
+	{"MEM_SWAP", "swap", ""},
+	{"MEM_KERNEL", "", "kernel"},
+}
+
+// memoryKernelParts add up to the kernel memory on cgroup v2 kernels older
+// than 5.18, which don't report "kernel" itself.
+var memoryKernelParts = []string{"kernel_stack", "pagetables", "percpu", "sock", "vmalloc", "slab"}
+
+// memoryParts returns the breakdown of MemoryStats.Stats in bytes, keyed by
+// name. cgroup v2 is recognized by its "anon" key; parts the kernel didn't
+// report are left out.
+func memoryParts(mem types.MemoryStats) map[string]uint64 {
+	_, v2 := mem.Stats["anon"]
+	parts := map[string]uint64{}
+	for _, p := range memoryBreakdown {
+		key := p.v1
+		if v2 {
+			key = p.v2
+		}
+		if key == "" {
+			continue
+		}
+		if v, ok := mem.Stats[key]; ok {
+			parts[p.name] = v
+		}
+	}
+	if _, ok := parts["MEM_KERNEL"]; v2 && !ok {
+		var kernel uint64
+		found := false
+		for _, key := range memoryKernelParts {
+			if v, ok := mem.Stats[key]; ok {
+				kernel += v
+				found = true
+			}
+		}
+		if found {
+			parts["MEM_KERNEL"] = kernel
+		}
+	}
+	return parts
+}
+
+// memoryWorkingSet returns the working set the way the kubelet and cAdvisor
+// compute it: the usage minus the inactive file cache, which the kernel can
+// reclaim at any time. cgroup v1 reports the hierarchical total_inactive_file
+// next to inactive_file; cgroup v2 only has the latter.
+func memoryWorkingSet(mem types.MemoryStats) uint64 {
+	inactive, ok := mem.Stats["total_inactive_file"]
+	if !ok {
+		inactive = mem.Stats["inactive_file"]
+	}
+	if mem.Usage < inactive {
+		return 0
+	}
+	return mem.Usage - inactive
+}
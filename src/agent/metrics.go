@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metric is one Prometheus metric family as exposed on /metrics.
+type metric struct {
+	name    string
+	help    string
+	kind    string // gauge or counter
+	samples []metricSample
+}
+
+type metricSample struct {
+	labels map[string]string
+	value  float64
+}
+
+var (
+	metricSourcesMu sync.Mutex
+	metricSources   []func() []metric
+
+	// metricsPrecision is the number of decimals written on /metrics, -1 for
+	// the shortest representation that round-trips the float64.
+	metricsPrecision = -1
+)
+
+// registerMetrics adds a source consulted on every /metrics request.
+func registerMetrics(source func() []metric) {
+	metricSourcesMu.Lock()
+	metricSources = append(metricSources, source)
+	metricSourcesMu.Unlock()
+}
+
+// serveMetrics writes every registered metric in the Prometheus text format.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	metricSourcesMu.Lock()
+	sources := make([]func() []metric, len(metricSources))
+	copy(sources, metricSources)
+	metricSourcesMu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, source := range sources {
+		for _, m := range source() {
+			writeMetric(w, m)
+		}
+	}
+}
+
+func writeMetric(w io.Writer, m metric) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	for _, s := range m.samples {
+		fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(s.labels), formatMetricValue(s.value))
+	}
+}
+
+func formatMetricValue(v float64) string {
+	if metricsPrecision < 0 {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'f', metricsPrecision, 64)
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+`="`+labelEscaper.Replace(labels[k])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// sanitizeMetricName replaces the characters not allowed in Prometheus metric
+// and label names with underscores.
+func sanitizeMetricName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' && i > 0) {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// gauge is a shorthand for a single unlabelled gauge.
+func gauge(name, help string, value float64) metric {
+	return metric{name: name, help: help, kind: "gauge", samples: []metricSample{{value: value}}}
+}
+
+func counter(name, help string, value float64) metric {
+	return metric{name: name, help: help, kind: "counter", samples: []metricSample{{value: value}}}
+}
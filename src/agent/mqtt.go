@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// MQTT control packet types.
+const (
+	mqttConnect    = 1
+	mqttConnack    = 2
+	mqttPublish    = 3
+	mqttPuback     = 4
+	mqttPubrec     = 5
+	mqttPubrel     = 6
+	mqttPubcomp    = 7
+	mqttPingreq    = 12
+	mqttPingresp   = 13
+	mqttDisconnect = 14
+)
+
+const (
+	mqttTimeout   = 10 * time.Second
+	mqttKeepAlive = 60 * time.Second
+)
+
+type mqttOptions struct {
+	version            byte // 4 for 3.1.1, 5 for 5.0
+	clientID           string
+	username, password string
+	tls                *tls.Config
+}
+
+// mqttConn is a clean session with a broker. A reader completes the QoS 1 and
+// 2 handshakes of publishes, and a pinger keeps the session alive between
+// ticks.
+type mqttConn struct {
+	conn    net.Conn
+	version byte
+
+	mu      sync.Mutex
+	w       *bufio.Writer
+	next    uint16
+	pending map[uint16]chan error
+	closed  error
+	done    chan struct{}
+	once    sync.Once
+}
+
+func dialMQTT(address string, opts mqttOptions) (*mqttConn, error) {
+	conn, err := net.DialTimeout("tcp", address, mqttTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if opts.tls != nil {
+		config := opts.tls.Clone()
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(address)
+		}
+		conn = tls.Client(conn, config)
+	}
+	c := &mqttConn{conn: conn, version: opts.version, w: bufio.NewWriter(conn), pending: map[uint16]chan error{}, done: make(chan struct{})}
+
+	var b mqttWriter
+	b.string("MQTT")
+	b.byte(opts.version)
+	flags := byte(0x02) // clean session
+	if opts.username != "" {
+		flags |= 0x80
+		if opts.password != "" {
+			flags |= 0x40
+		}
+	}
+	b.byte(flags)
+	b.uint16(uint16(mqttKeepAlive / time.Second))
+	if opts.version == 5 {
+		b.byte(0) // no properties
+	}
+	b.string(opts.clientID)
+	if opts.username != "" {
+		b.string(opts.username)
+		if opts.password != "" {
+			b.string(opts.password)
+		}
+	}
+
+	conn.SetDeadline(time.Now().Add(mqttTimeout))
+	r := bufio.NewReader(conn)
+	c.write(mqttConnect<<4, b.buf)
+	if err := c.w.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	kind, body, err := readMQTT(r)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if kind>>4 != mqttConnack || len(body) < 2 {
+		conn.Close()
+		return nil, errors.New("mqtt: broker didn't answer CONNECT with CONNACK")
+	}
+	if code := body[1]; code != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("mqtt: connection refused with code %#x", code)
+	}
+	conn.SetDeadline(time.Time{})
+
+	go c.read(r)
+	go c.ping()
+	return c, nil
+}
+
+// write queues a packet; the caller holds mu or owns the connection.
+func (c *mqttConn) write(header byte, body []byte) {
+	var b mqttWriter
+	b.byte(header)
+	b.varint(len(body))
+	c.w.Write(b.buf)
+	c.w.Write(body)
+}
+
+// publish sends a message and returns a function waiting until the broker
+// took it: immediately for QoS 0, on PUBACK for 1 and on PUBCOMP for 2.
+func (c *mqttConn) publish(topic string, payload []byte, qos byte, retain bool) (func() error, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed != nil {
+		return nil, c.closed
+	}
+
+	var b mqttWriter
+	b.string(topic)
+	var ack chan error
+	if qos > 0 {
+		c.next++
+		if c.next == 0 {
+			c.next = 1
+		}
+		for c.pending[c.next] != nil {
+			c.next++
+		}
+		ack = make(chan error, 1)
+		c.pending[c.next] = ack
+		b.uint16(c.next)
+	}
+	if c.version == 5 {
+		b.byte(0)
+	}
+	b.buf = append(b.buf, payload...)
+	header := byte(mqttPublish<<4) | qos<<1
+	if retain {
+		header |= 0x01
+	}
+	c.write(header, b.buf)
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	id := c.next
+	return func() error {
+		if ack == nil {
+			return nil
+		}
+		select {
+		case err := <-ack:
+			return err
+		case <-time.After(mqttTimeout):
+			c.mu.Lock()
+			delete(c.pending, id)
+			c.mu.Unlock()
+			return fmt.Errorf("mqtt: no acknowledgement for %s", topic)
+		}
+	}, nil
+}
+
+// read handles the broker's packets until the connection fails.
+func (c *mqttConn) read(r *bufio.Reader) {
+	err := func() error {
+		for {
+			kind, body, err := readMQTT(r)
+			if err != nil {
+				return err
+			}
+			switch kind >> 4 {
+			case mqttPuback, mqttPubrec, mqttPubcomp:
+				if len(body) < 2 {
+					return errors.New("mqtt: short acknowledgement")
+				}
+				id := binary.BigEndian.Uint16(body)
+				var failed error
+				if len(body) > 2 && body[2] >= 0x80 {
+					failed = fmt.Errorf("mqtt: broker rejected the message with reason %#x", body[2])
+				}
+				c.mu.Lock()
+				if kind>>4 == mqttPubrec && failed == nil {
+					c.write(mqttPubrel<<4|0x02, body[:2])
+					err = c.w.Flush()
+				} else if ack, ok := c.pending[id]; ok {
+					ack <- failed
+					delete(c.pending, id)
+				}
+				c.mu.Unlock()
+				if err != nil {
+					return err
+				}
+			case mqttDisconnect:
+				if len(body) > 0 {
+					return fmt.Errorf("mqtt: broker disconnected with reason %#x", body[0])
+				}
+				return errors.New("mqtt: broker disconnected")
+			}
+		}
+	}()
+
+	c.mu.Lock()
+	if c.closed == nil {
+		c.closed = err
+	}
+	for id, ack := range c.pending {
+		ack <- c.closed
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+	c.once.Do(func() { close(c.done) })
+	c.conn.Close()
+}
+
+// ping keeps the session alive at half the keep alive period.
+func (c *mqttConn) ping() {
+	ticker := time.NewTicker(mqttKeepAlive / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.mu.Lock()
+			c.write(mqttPingreq<<4, nil)
+			c.w.Flush()
+			c.mu.Unlock()
+		}
+	}
+}
+
+func (c *mqttConn) Close() error {
+	c.mu.Lock()
+	if c.closed == nil {
+		c.closed = errors.New("mqtt: connection closed")
+		c.write(mqttDisconnect<<4, nil)
+		c.w.Flush()
+	}
+	c.mu.Unlock()
+	c.once.Do(func() { close(c.done) })
+	return c.conn.Close()
+}
+
+// readMQTT reads one packet, returning its first byte and its body.
+func readMQTT(r *bufio.Reader) (byte, []byte, error) {
+	kind, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var n, shift uint
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n |= uint(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return 0, nil, errors.New("mqtt: malformed remaining length")
+		}
+	}
+	body := make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	return kind, body, err
+}
+
+type mqttWriter struct{ buf []byte }
+
+func (w *mqttWriter) byte(b byte) { w.buf = append(w.buf, b) }
+
+func (w *mqttWriter) uint16(v uint16) { w.buf = append(w.buf, byte(v>>8), byte(v)) }
+
+func (w *mqttWriter) string(s string) {
+	w.uint16(uint16(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+// varint writes a remaining length: 7 bits per byte, lowest first.
+func (w *mqttWriter) varint(n int) {
+	for {
+		b := byte(n & 0x7f)
+		if n >>= 7; n > 0 {
+			b |= 0x80
+		}
+		w.buf = append(w.buf, b)
+		if n == 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// natsTimeout bounds the handshake and JetStream acknowledgements.
+const natsTimeout = 10 * time.Second
+
+// natsConn speaks the NATS client protocol: CONNECT, PUB, and a reader that
+// answers the server's PINGs and routes the replies to JetStream publishes.
+type natsConn struct {
+	conn  net.Conn
+	inbox string
+
+	mu     sync.Mutex
+	w      *bufio.Writer
+	next   int
+	acks   map[string]chan []byte
+	closed error
+}
+
+type natsOptions struct {
+	user, password, token string
+	tls                   *tls.Config
+}
+
+type natsInfo struct {
+	TLSRequired bool `json:"tls_required"`
+}
+
+func dialNATS(address string, opts natsOptions) (*natsConn, error) {
+	conn, err := net.DialTimeout("tcp", address, natsTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(natsTimeout))
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var info natsInfo
+	if !strings.HasPrefix(line, "INFO ") || json.Unmarshal([]byte(line[5:]), &info) != nil {
+		conn.Close()
+		return nil, fmt.Errorf("nats: unexpected greeting %q", strings.TrimSpace(line))
+	}
+	if opts.tls == nil && info.TLSRequired {
+		opts.tls = &tls.Config{}
+	}
+	if opts.tls != nil {
+		config := opts.tls.Clone()
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(address)
+		}
+		conn = tls.Client(conn, config)
+		r = bufio.NewReader(conn)
+	}
+
+	connect, _ := json.Marshal(map[string]interface{}{
+		"verbose": false, "pedantic": false, "tls_required": opts.tls != nil,
+		"name": "docker-stats", "lang": "go", "version": "1", "protocol": 1,
+		"user": opts.user, "pass": opts.password, "auth_token": opts.token,
+	})
+	c := &natsConn{conn: conn, w: bufio.NewWriter(conn), acks: map[string]chan []byte{}}
+	fmt.Fprintf(c.w, "CONNECT %s\r\nPING\r\n", connect)
+	if err := c.w.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// The server answers PONG once it accepted the CONNECT, -ERR otherwise.
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "PONG" {
+			break
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			conn.Close()
+			return nil, errors.New("nats: " + strings.Trim(strings.TrimPrefix(line, "-ERR "), "'"))
+		}
+	}
+	conn.SetDeadline(time.Time{})
+
+	b := make([]byte, 8)
+	rand.Read(b)
+	c.inbox = "_INBOX." + hex.EncodeToString(b)
+	fmt.Fprintf(c.w, "SUB %s.* 1\r\n", c.inbox)
+	if err := c.w.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go c.read(r)
+	return c, nil
+}
+
+// read handles what the server sends until the connection fails.
+func (c *natsConn) read(r *bufio.Reader) {
+	err := func() error {
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return err
+			}
+			line = strings.TrimSpace(line)
+			switch {
+			case line == "PING":
+				c.mu.Lock()
+				c.w.WriteString("PONG\r\n")
+				err = c.w.Flush()
+				c.mu.Unlock()
+				if err != nil {
+					return err
+				}
+			case strings.HasPrefix(line, "-ERR"):
+				return errors.New("nats: " + strings.Trim(strings.TrimPrefix(line, "-ERR "), "'"))
+			case strings.HasPrefix(line, "MSG "):
+				// MSG <subject> <sid> [reply-to] <size>
+				parts := strings.Fields(line)
+				size, err := strconv.Atoi(parts[len(parts)-1])
+				if err != nil {
+					return fmt.Errorf("nats: bad message header %q", line)
+				}
+				payload := make([]byte, size+2)
+				if _, err := io.ReadFull(r, payload); err != nil {
+					return err
+				}
+				c.mu.Lock()
+				if ack, ok := c.acks[parts[1]]; ok {
+					ack <- payload[:size]
+					delete(c.acks, parts[1])
+				}
+				c.mu.Unlock()
+			}
+		}
+	}()
+
+	c.mu.Lock()
+	if c.closed == nil {
+		c.closed = err
+	}
+	c.mu.Unlock()
+	c.conn.Close()
+}
+
+// publish queues a message; flush sends what's queued.
+func (c *natsConn) publish(subject string, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed != nil {
+		return c.closed
+	}
+	fmt.Fprintf(c.w, "PUB %s %d\r\n", subject, len(payload))
+	c.w.Write(payload)
+	_, err := c.w.WriteString("\r\n")
+	return err
+}
+
+func (c *natsConn) flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed != nil {
+		return c.closed
+	}
+	return c.w.Flush()
+}
+
+// publishAck publishes to a JetStream stream and returns a function waiting
+// until the stream stored the message, so many can be in flight at once.
+func (c *natsConn) publishAck(subject string, payload []byte) (func() error, error) {
+	ack := make(chan []byte, 1)
+	c.mu.Lock()
+	if c.closed != nil {
+		c.mu.Unlock()
+		return nil, c.closed
+	}
+	c.next++
+	reply := c.inbox + "." + strconv.Itoa(c.next)
+	c.acks[reply] = ack
+	fmt.Fprintf(c.w, "PUB %s %s %d\r\n", subject, reply, len(payload))
+	c.w.Write(payload)
+	_, err := c.w.WriteString("\r\n")
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		select {
+		case b := <-ack:
+			var resp struct {
+				Stream string `json:"stream"`
+				Error  *struct {
+					Description string `json:"description"`
+				} `json:"error"`
+			}
+			if json.Unmarshal(b, &resp) != nil || (resp.Stream == "" && resp.Error == nil) {
+				return fmt.Errorf("nats: no JetStream stream acknowledged %s", subject)
+			}
+			if resp.Error != nil {
+				return errors.New("nats: " + resp.Error.Description)
+			}
+			return nil
+		case <-time.After(natsTimeout):
+			c.mu.Lock()
+			delete(c.acks, reply)
+			c.mu.Unlock()
+			return fmt.Errorf("nats: no acknowledgement for %s", subject)
+		}
+	}, nil
+}
+
+func (c *natsConn) Close() error {
+	c.mu.Lock()
+	if c.closed == nil {
+		c.closed = errors.New("nats: connection closed")
+	}
+	c.w.Flush()
+	c.mu.Unlock()
+	return c.conn.Close()
+}
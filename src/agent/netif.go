@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+)
+
+var collectNetworkInterfaces bool
+
+// interfaceCounters are the per-interface readings, each a
+// NET_<INTERFACE>_<suffix> reading with the metric family exposing it.
+var interfaceCounters = []struct {
+	suffix string
+	name   string
+	help   string
+	value  func(types.NetworkStats) uint64
+}{
+	{"RX_BYTES", "docker_container_network_interface_receive_bytes_total", "Bytes received on an interface.", func(n types.NetworkStats) uint64 { return n.RxBytes }},
+	{"TX_BYTES", "docker_container_network_interface_transmit_bytes_total", "Bytes sent on an interface.", func(n types.NetworkStats) uint64 { return n.TxBytes }},
+	{"RX_PACKETS", "docker_container_network_interface_receive_packets_total", "Packets received on an interface.", func(n types.NetworkStats) uint64 { return n.RxPackets }},
+	{"TX_PACKETS", "docker_container_network_interface_transmit_packets_total", "Packets sent on an interface.", func(n types.NetworkStats) uint64 { return n.TxPackets }},
+	{"RX_ERRORS", "docker_container_network_interface_receive_errors_total", "Receive errors on an interface.", func(n types.NetworkStats) uint64 { return n.RxErrors }},
+	{"TX_ERRORS", "docker_container_network_interface_transmit_errors_total", "Transmit errors on an interface.", func(n types.NetworkStats) uint64 { return n.TxErrors }},
+	{"RX_DROPPED", "docker_container_network_interface_receive_dropped_total", "Received packets dropped on an interface.", func(n types.NetworkStats) uint64 { return n.RxDropped }},
+	{"TX_DROPPED", "docker_container_network_interface_transmit_dropped_total", "Packets to send dropped on an interface.", func(n types.NetworkStats) uint64 { return n.TxDropped }},
+}
+
+func init() {
+	registerMetrics(interfaceMetrics)
+}
+
+// interfaceReadings adds the counters of every interface to the readings
+// and formatted values, byte counts formatted in megabytes, and lists the
+// interfaces under Interfaces.
+func interfaceReadings(networks map[string]types.NetworkStats, fields logrus.Fields, readings map[string]float64, values map[string]interface{}) {
+	if len(networks) == 0 {
+		return
+	}
+	names := make([]string, 0, len(networks))
+	for name, n := range networks {
+		names = append(names, name)
+		prefix := interfaceKey(name)
+		for _, c := range interfaceCounters {
+			v := c.value(n)
+			readings[prefix+c.suffix] = float64(v)
+			if strings.HasSuffix(c.suffix, "_BYTES") {
+				values[prefix+strings.TrimSuffix(c.suffix, "_BYTES")+"_MB"] = formatMB(v)
+			} else {
+				values[prefix+c.suffix] = v
+			}
+		}
+	}
+	sort.Strings(names)
+	fields["Interfaces"] = names
+}
+
+// interfaceKey returns the prefix of an interface's readings, e.g. NET_ETH0_.
+func interfaceKey(name string) string {
+	return "NET_" + readingName(name) + "_"
+}
+
+// interfaceMetrics exposes the per-interface readings of the last snapshot,
+// labeled by interface.
+func interfaceMetrics() []metric {
+	if !collectNetworkInterfaces {
+		return nil
+	}
+	entries := snapshots.list()
+	families := make([]metric, 0, len(interfaceCounters))
+	for _, c := range interfaceCounters {
+		m := metric{name: c.name, help: c.help, kind: "counter"}
+		for _, e := range entries {
+			names, _ := e.sample.Fields["Interfaces"].([]string)
+			for _, name := range names {
+				v, ok := e.sample.Values[interfaceKey(name)+c.suffix]
+				if !ok {
+					continue
+				}
+				labels := containerLabels(e.sample)
+				labels["interface"] = name
+				m.samples = append(m.samples, metricSample{labels: labels, value: v})
+			}
+		}
+		families = append(families, m)
+	}
+	return families
+}
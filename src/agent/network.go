@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/sirupsen/logrus"
+)
+
+// networkFields reports how a container is networked. Containers sharing the
+// host's or another container's network stack report no network stats of
+// their own, which NetworkShared makes explicit next to the zero readings.
+func networkFields(ctx context.Context, c types.Container, fields logrus.Fields) {
+	mode := container.NetworkMode(c.HostConfig.NetworkMode)
+
+	inspect, err := inspects.get(ctx, c.ID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err}).Warn("error inspecting container")
+	} else if inspect.ContainerJSONBase != nil && inspect.HostConfig != nil {
+		if mode == "" {
+			mode = inspect.HostConfig.NetworkMode
+		}
+		if len(inspect.HostConfig.Links) > 0 {
+			fields["Links"] = inspect.HostConfig.Links
+		}
+	}
+
+	if mode == "" {
+		return
+	}
+	fields["NetworkMode"] = string(mode)
+	if mode.IsHost() || mode.IsContainer() {
+		fields["NetworkShared"] = true
+	}
+}
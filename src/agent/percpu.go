@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+var collectPerCPU bool
+
+func init() {
+	registerMetrics(perCPUMetrics)
+}
+
+// perCPUPercents returns the usage of every core in percent of that core,
+// indexed like PercpuUsage. Only cgroup v1 reports per-core usage; on
+// cgroup v2 hosts, or when the previous reading has none, it returns nil.
+func perCPUPercents(stats *types.StatsJSON) []float64 {
+	cur, pre := stats.CPUStats.CPUUsage.PercpuUsage, stats.PreCPUStats.CPUUsage.PercpuUsage
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	if len(cur) == 0 || len(pre) == 0 || systemDelta <= 0 {
+		return nil
+	}
+	// The system usage adds up every core, each got its share of it.
+	perCore := systemDelta / onlineCPUs(stats)
+	percents := make([]float64, len(cur))
+	for i := range cur {
+		if i < len(pre) && cur[i] > pre[i] {
+			percents[i] = float64(cur[i]-pre[i]) / perCore * 100.0
+		}
+	}
+	return percents
+}
+
+// perCPUReadings adds CPU<n>_PCT for every core and CPU_MAX_CORE_PCT, the
+// busiest core, to the readings and formatted values.
+func perCPUReadings(percents []float64, readings map[string]float64, values map[string]interface{}) {
+	if len(percents) == 0 {
+		return
+	}
+	var max float64
+	for i, pct := range percents {
+		name := "CPU" + strconv.Itoa(i) + "_PCT"
+		readings[name] = pct
+		values[name] = formatDecimal(pct)
+		if pct > max {
+			max = pct
+		}
+	}
+	readings["CPU_MAX_CORE_PCT"] = max
+	values["CPU_MAX_CORE_PCT"] = formatDecimal(max)
+}
+
+// perCPUMetrics exposes the per-core readings of the last snapshot as one
+// family labeled by core.
+func perCPUMetrics() []metric {
+	if !collectPerCPU {
+		return nil
+	}
+	m := metric{name: "docker_container_cpu_core_percent", help: "CPU usage of a core in percent of that core.", kind: "gauge"}
+	for _, e := range snapshots.list() {
+		var cores []int
+		for k := range e.sample.Values {
+			if n, ok := perCPUIndex(k); ok {
+				cores = append(cores, n)
+			}
+		}
+		sort.Ints(cores)
+		for _, n := range cores {
+			labels := containerLabels(e.sample)
+			labels["cpu"] = strconv.Itoa(n)
+			m.samples = append(m.samples, metricSample{labels: labels, value: e.sample.Values[fmt.Sprintf("CPU%d_PCT", n)]})
+		}
+	}
+	return []metric{m}
+}
+
+// perCPUIndex returns the core of a CPU<n>_PCT reading.
+func perCPUIndex(reading string) (int, bool) {
+	if !strings.HasPrefix(reading, "CPU") || !strings.HasSuffix(reading, "_PCT") {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(reading, "CPU"), "_PCT"))
+	return n, err == nil && n >= 0
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type pidReading struct {
+	at   time.Time
+	pids uint64
+}
+
+type pidHistory struct {
+	readings []pidReading
+	warned   bool
+}
+
+// pidTrend keeps the last PID counts of every container to report how fast
+// they grow. A container whose count never dropped over the whole window and
+// grew faster than warnRate per minute is logged once as a likely PID leak.
+type pidTrend struct {
+	mu       sync.Mutex
+	window   int
+	warnRate float64
+	history  map[string]*pidHistory
+}
+
+var pidTrends = &pidTrend{window: 5, history: map[string]*pidHistory{}}
+
+// observe records the PID count of a tick and returns the change since the
+// previous tick and the growth per minute over the window.
+func (t *pidTrend) observe(id string, names []string, pids uint64, now time.Time) (delta int64, perMinute float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h, ok := t.history[id]
+	if !ok {
+		h = &pidHistory{}
+		t.history[id] = h
+	}
+	h.readings = append(h.readings, pidReading{at: now, pids: pids})
+	if len(h.readings) > t.window {
+		h.readings = h.readings[len(h.readings)-t.window:]
+	}
+	if len(h.readings) < 2 {
+		return 0, 0
+	}
+
+	prev := h.readings[len(h.readings)-2]
+	first := h.readings[0]
+	delta = int64(pids) - int64(prev.pids)
+	if elapsed := now.Sub(first.at).Minutes(); elapsed > 0 {
+		perMinute = (float64(pids) - float64(first.pids)) / elapsed
+	}
+
+	climbing := len(h.readings) == t.window
+	for i := 1; climbing && i < len(h.readings); i++ {
+		climbing = h.readings[i].pids >= h.readings[i-1].pids
+	}
+	leaking := t.warnRate > 0 && climbing && perMinute > t.warnRate
+	if leaking && !h.warned {
+		logrus.WithFields(logrus.Fields{
+			"ID":          id,
+			"Names":       names,
+			"PIDS":        pids,
+			"PIDS_GROWTH": formatDecimal(perMinute),
+		}).Warn("process count keeps climbing")
+	}
+	h.warned = leaking
+	return delta, perMinute
+}
+
+func (t *pidTrend) forget(id string) {
+	t.mu.Lock()
+	delete(t.history, id)
+	t.mu.Unlock()
+}
+
+func (t *pidTrend) size() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.history)
+}
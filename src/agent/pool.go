@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// workerPool bounds how many calls of a kind run against the daemon at once.
+// A size of zero leaves the pool unbounded, still counting busy workers.
+type workerPool struct {
+	name  string
+	size  int
+	slots chan struct{}
+
+	mu      sync.Mutex
+	busy    int
+	waiting int
+}
+
+var (
+	statsPool  = &workerPool{name: "stats"}
+	enrichPool = &workerPool{name: "enrich", size: 4}
+)
+
+var pools = []*workerPool{statsPool, enrichPool}
+
+func init() {
+	registerMetrics(poolMetrics)
+}
+
+// start allocates the slots once the size is configured.
+func (p *workerPool) start() {
+	if p.size > 0 {
+		p.slots = make(chan struct{}, p.size)
+	}
+}
+
+// acquire waits for a free worker, giving up when ctx is done.
+func (p *workerPool) acquire(ctx context.Context) error {
+	if p.slots != nil {
+		p.mu.Lock()
+		p.waiting++
+		p.mu.Unlock()
+
+		select {
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			p.mu.Lock()
+			p.waiting--
+			p.mu.Unlock()
+			return ctx.Err()
+		}
+
+		p.mu.Lock()
+		p.waiting--
+		p.mu.Unlock()
+	}
+	p.mu.Lock()
+	p.busy++
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *workerPool) release() {
+	p.mu.Lock()
+	p.busy--
+	p.mu.Unlock()
+	if p.slots != nil {
+		<-p.slots
+	}
+}
+
+func poolMetrics() []metric {
+	size := metric{name: "docker_stats_pool_size", help: "Workers of a pool, 0 when unbounded.", kind: "gauge"}
+	busy := metric{name: "docker_stats_pool_busy", help: "Workers of a pool currently calling the daemon.", kind: "gauge"}
+	waiting := metric{name: "docker_stats_pool_waiting", help: "Calls waiting for a free worker; above zero the pool is saturated.", kind: "gauge"}
+	for _, p := range pools {
+		labels := map[string]string{"pool": p.name}
+		p.mu.Lock()
+		size.samples = append(size.samples, metricSample{labels: labels, value: float64(p.size)})
+		busy.samples = append(busy.samples, metricSample{labels: labels, value: float64(p.busy)})
+		waiting.samples = append(waiting.samples, metricSample{labels: labels, value: float64(p.waiting)})
+		p.mu.Unlock()
+	}
+	return []metric{size, busy, waiting}
+}
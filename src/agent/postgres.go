@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Enough of the PostgreSQL frontend/backend protocol (v3) to run simple
+// queries: startup with cleartext, MD5 or SCRAM-SHA-256 password auth,
+// optionally over TLS.
+const pgTimeout = 30 * time.Second
+
+type pgConfig struct {
+	address  string
+	user     string
+	password string
+	database string
+	sslMode  string // disable, prefer, require or verify-full
+	tls      *tls.Config
+}
+
+type pgConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// pgError is an ErrorResponse of the server.
+type pgError struct {
+	code, message string
+}
+
+func (e *pgError) Error() string { return "postgres: " + e.message + " (" + e.code + ")" }
+
+func dialPostgres(cfg pgConfig) (*pgConn, error) {
+	conn, err := net.DialTimeout("tcp", cfg.address, pgTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(pgTimeout))
+	if cfg.sslMode != "disable" {
+		// SSLRequest is answered with a single S or N.
+		var req [8]byte
+		binary.BigEndian.PutUint32(req[0:], 8)
+		binary.BigEndian.PutUint32(req[4:], 80877103)
+		var answer [1]byte
+		if _, err = conn.Write(req[:]); err == nil {
+			_, err = io.ReadFull(conn, answer[:])
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		switch {
+		case answer[0] == 'S':
+			config := &tls.Config{InsecureSkipVerify: cfg.sslMode != "verify-full"}
+			if cfg.tls != nil {
+				config = cfg.tls.Clone()
+			}
+			if config.ServerName == "" {
+				config.ServerName, _, _ = net.SplitHostPort(cfg.address)
+			}
+			conn = tls.Client(conn, config)
+		case cfg.sslMode != "prefer":
+			conn.Close()
+			return nil, errors.New("postgres: server doesn't support TLS, set sslmode=disable or prefer")
+		}
+	}
+
+	c := &pgConn{conn: conn, r: bufio.NewReader(conn)}
+	var startup pgWriter
+	startup.int32(196608) // protocol 3.0
+	for _, kv := range [][2]string{{"user", cfg.user}, {"database", cfg.database}, {"application_name", "docker-stats"}} {
+		startup.cstring(kv[0])
+		startup.cstring(kv[1])
+	}
+	startup.buf = append(startup.buf, 0)
+	if err := c.send(0, startup.buf); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := c.authenticate(cfg); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := c.ready(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+func (c *pgConn) authenticate(cfg pgConfig) error {
+	var scram *scramClient
+	for {
+		kind, body, err := c.receive()
+		if err != nil {
+			return err
+		}
+		if kind == 'E' {
+			return pgParseError(body)
+		}
+		if kind != 'R' || len(body) < 4 {
+			return fmt.Errorf("postgres: unexpected %q message during authentication", kind)
+		}
+		switch code := binary.BigEndian.Uint32(body); code {
+		case 0:
+			if scram != nil && !scram.verified {
+				return errors.New("postgres: server didn't prove it knows the password")
+			}
+			return nil
+		case 3:
+			var w pgWriter
+			w.cstring(cfg.password)
+			err = c.send('p', w.buf)
+		case 5:
+			inner := md5.Sum([]byte(cfg.password + cfg.user))
+			outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), body[4:8]...))
+			var w pgWriter
+			w.cstring("md5" + hex.EncodeToString(outer[:]))
+			err = c.send('p', w.buf)
+		case 10:
+			if !strings.Contains(string(body[4:]), "SCRAM-SHA-256\x00") {
+				return errors.New("postgres: server offers no supported SASL mechanism")
+			}
+			scram = newScramClient("", cfg.password)
+			first := scram.first()
+			var w pgWriter
+			w.cstring("SCRAM-SHA-256")
+			w.int32(int32(len(first)))
+			w.buf = append(w.buf, first...)
+			err = c.send('p', w.buf)
+		case 11:
+			if scram == nil {
+				return errors.New("postgres: unexpected SASL continuation")
+			}
+			var final string
+			if final, err = scram.final(string(body[4:])); err == nil {
+				err = c.send('p', []byte(final))
+			}
+		case 12:
+			if scram == nil {
+				return errors.New("postgres: unexpected SASL completion")
+			}
+			err = scram.verify(string(body[4:]))
+		default:
+			return fmt.Errorf("postgres: unsupported authentication method %d", code)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ready reads messages until ReadyForQuery, returning the first error and the
+// transaction status (I idle, T in a transaction, E in a failed one).
+func (c *pgConn) ready() (byte, error) {
+	var first error
+	for {
+		kind, body, err := c.receive()
+		if err != nil {
+			return 0, err
+		}
+		switch kind {
+		case 'E':
+			if first == nil {
+				first = pgParseError(body)
+			}
+		case 'Z':
+			if len(body) < 1 {
+				return 0, errors.New("postgres: malformed ReadyForQuery")
+			}
+			return body[0], first
+		}
+	}
+}
+
+// exec runs one or more statements with the simple query protocol. When they
+// fail inside a transaction block it's rolled back.
+func (c *pgConn) exec(query string) error {
+	c.conn.SetDeadline(time.Now().Add(pgTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+	var w pgWriter
+	w.cstring(query)
+	if err := c.send('Q', w.buf); err != nil {
+		return err
+	}
+	status, err := c.ready()
+	if status == 'E' || status == 'T' {
+		var rollback pgWriter
+		rollback.cstring("ROLLBACK")
+		if sendErr := c.send('Q', rollback.buf); sendErr != nil {
+			return sendErr
+		}
+		c.ready()
+	}
+	return err
+}
+
+func (c *pgConn) send(kind byte, body []byte) error {
+	var w pgWriter
+	if kind != 0 {
+		w.buf = append(w.buf, kind)
+	}
+	w.int32(int32(len(body) + 4))
+	w.buf = append(w.buf, body...)
+	_, err := c.conn.Write(w.buf)
+	return err
+}
+
+func (c *pgConn) receive() (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	n := int(binary.BigEndian.Uint32(header[1:])) - 4
+	if n < 0 {
+		return 0, nil, errors.New("postgres: malformed message")
+	}
+	body := make([]byte, n)
+	_, err := io.ReadFull(c.r, body)
+	return header[0], body, err
+}
+
+func (c *pgConn) Close() error {
+	c.send('X', nil)
+	return c.conn.Close()
+}
+
+func pgParseError(body []byte) error {
+	e := &pgError{}
+	for _, field := range strings.Split(string(body), "\x00") {
+		if len(field) < 2 {
+			continue
+		}
+		switch field[0] {
+		case 'C':
+			e.code = field[1:]
+		case 'M':
+			e.message = field[1:]
+		}
+	}
+	return e
+}
+
+type pgWriter struct{ buf []byte }
+
+func (w *pgWriter) int32(v int32) {
+	w.buf = append(w.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (w *pgWriter) cstring(s string) {
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, 0)
+}
+
+// scramClient runs the client side of SCRAM-SHA-256 (RFC 7677).
+type scramClient struct {
+	user, password string
+	nonce          string
+	firstBare      string
+	serverKey      []byte
+	authMessage    string
+	verified       bool
+}
+
+func newScramClient(user, password string) *scramClient {
+	b := make([]byte, 18)
+	rand.Read(b)
+	return &scramClient{user: user, password: password, nonce: base64.StdEncoding.EncodeToString(b)}
+}
+
+func (s *scramClient) first() string {
+	s.firstBare = "n=" + s.user + ",r=" + s.nonce
+	return "n,," + s.firstBare
+}
+
+func (s *scramClient) final(serverFirst string) (string, error) {
+	attrs := scramAttributes(serverFirst)
+	salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+	iterations, convErr := strconv.Atoi(attrs["i"])
+	if err != nil || convErr != nil || iterations < 1 || !strings.HasPrefix(attrs["r"], s.nonce) {
+		return "", errors.New("postgres: malformed SCRAM challenge")
+	}
+
+	salted := pbkdf2SHA256([]byte(s.password), salt, iterations)
+	clientKey := hmacSHA256(salted, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	withoutProof := "c=biws,r=" + attrs["r"]
+	s.authMessage = s.firstBare + "," + serverFirst + "," + withoutProof
+	signature := hmacSHA256(storedKey[:], s.authMessage)
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ signature[i]
+	}
+	s.serverKey = hmacSHA256(salted, "Server Key")
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+func (s *scramClient) verify(serverFinal string) error {
+	want := base64.StdEncoding.EncodeToString(hmacSHA256(s.serverKey, s.authMessage))
+	if scramAttributes(serverFinal)["v"] != want {
+		return errors.New("postgres: server signature doesn't match")
+	}
+	s.verified = true
+	return nil
+}
+
+func scramAttributes(msg string) map[string]string {
+	attrs := map[string]string{}
+	for _, kv := range strings.Split(msg, ",") {
+		if len(kv) > 2 && kv[1] == '=' {
+			attrs[kv[:1]] = kv[2:]
+		}
+	}
+	return attrs
+}
+
+// pbkdf2SHA256 derives a 32 byte key, the single block SCRAM-SHA-256 needs.
+func pbkdf2SHA256(password, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	key := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
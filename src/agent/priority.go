@@ -0,0 +1,108 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+)
+
+const (
+	priorityRoundRobin = "round_robin"
+	priorityBusiest    = "busiest"
+)
+
+// scheduler picks which containers are collected on a tick when max_containers
+// caps the work per tick. Containers outside the chosen set are picked up on a
+// later tick, so every container is eventually covered.
+type scheduler struct {
+	mu      sync.Mutex
+	cursor  int
+	lastCPU map[string]float64
+}
+
+var tickScheduler = &scheduler{lastCPU: map[string]float64{}}
+
+// observe records the CPU reading of a container for the busiest policy.
+func (s *scheduler) observe(id string, cpu float64) {
+	s.mu.Lock()
+	s.lastCPU[id] = cpu
+	s.mu.Unlock()
+}
+
+func (s *scheduler) forget(id string) {
+	s.mu.Lock()
+	delete(s.lastCPU, id)
+	s.mu.Unlock()
+}
+
+func (s *scheduler) size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.lastCPU)
+}
+
+// pick returns at most limit containers according to policy. A limit of zero
+// or less disables the cap.
+func (s *scheduler) pick(containers []types.Container, limit int, policy string) []types.Container {
+	if limit <= 0 || len(containers) <= limit {
+		return containers
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sorted := make([]types.Container, len(containers))
+	copy(sorted, containers)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	if policy != priorityBusiest {
+		return s.roundRobin(sorted, limit)
+	}
+
+	// Half of the budget goes to the busiest containers of the previous tick,
+	// the remainder round-robins over everything else so idle and new
+	// containers still get measured.
+	busy := limit / 2
+	if busy == 0 {
+		busy = 1
+	}
+	byCPU := make([]types.Container, 0, len(sorted))
+	for _, c := range sorted {
+		if _, ok := s.lastCPU[c.ID]; ok {
+			byCPU = append(byCPU, c)
+		}
+	}
+	sort.SliceStable(byCPU, func(i, j int) bool { return s.lastCPU[byCPU[i].ID] > s.lastCPU[byCPU[j].ID] })
+	if len(byCPU) > busy {
+		byCPU = byCPU[:busy]
+	}
+
+	chosen := make(map[string]bool, len(byCPU))
+	for _, c := range byCPU {
+		chosen[c.ID] = true
+	}
+	rest := make([]types.Container, 0, len(sorted)-len(byCPU))
+	for _, c := range sorted {
+		if !chosen[c.ID] {
+			rest = append(rest, c)
+		}
+	}
+	return append(byCPU, s.roundRobin(rest, limit-len(byCPU))...)
+}
+
+func (s *scheduler) roundRobin(containers []types.Container, limit int) []types.Container {
+	if limit <= 0 || len(containers) == 0 {
+		return nil
+	}
+	if limit >= len(containers) {
+		return containers
+	}
+	start := s.cursor % len(containers)
+	picked := make([]types.Container, 0, limit)
+	for i := 0; i < limit; i++ {
+		picked = append(picked, containers[(start+i)%len(containers)])
+	}
+	s.cursor = (start + limit) % len(containers)
+	return picked
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	collectProcesses bool
+	// processesTop is how many process names to list in TopProcesses, none
+	// when 0.
+	processesTop int
+
+	processesWarnOnce sync.Once
+)
+
+// processTopArgs are the ps options docker top runs with.
+var processTopArgs = []string{"-eo", "pid,nlwp,pcpu,rss,comm"}
+
+type processGroup struct {
+	name    string
+	procs   int
+	threads int
+	cpu     float64
+	rssKB   uint64
+}
+
+// processFields runs docker top and adds PROCS and THREADS, and with
+// processes_top the busiest process names under TopProcesses: their
+// processes, threads, CPU share as reported by ps and resident memory.
+func processFields(ctx context.Context, c types.Container, fields logrus.Fields, readings map[string]float64, values map[string]interface{}) {
+	if err := enrichPool.acquire(ctx); err != nil {
+		return
+	}
+	top, err := dockerClient.ContainerTop(ctx, c.ID, processTopArgs)
+	enrichPool.release()
+	if err != nil {
+		logged := false
+		processesWarnOnce.Do(func() {
+			logrus.WithFields(logrus.Fields{"ID": c.ID, "error": err}).Warn("cannot list container processes")
+			logged = true
+		})
+		if !logged {
+			logrus.WithFields(logrus.Fields{"ID": c.ID, "error": err}).Debug("cannot list container processes")
+		}
+		return
+	}
+
+	col := map[string]int{}
+	for i, title := range top.Titles {
+		col[title] = i
+	}
+	groups := map[string]*processGroup{}
+	var procs, threads int
+	for _, p := range top.Processes {
+		name := topColumn(p, col, "COMMAND")
+		g, ok := groups[name]
+		if !ok {
+			g = &processGroup{name: name}
+			groups[name] = g
+		}
+		n, err := strconv.Atoi(topColumn(p, col, "NLWP"))
+		if err != nil || n < 1 {
+			n = 1
+		}
+		cpu, _ := strconv.ParseFloat(topColumn(p, col, "%CPU"), 64)
+		rss, _ := strconv.ParseUint(topColumn(p, col, "RSS"), 10, 64)
+		g.procs++
+		g.threads += n
+		g.cpu += cpu
+		g.rssKB += rss
+		procs++
+		threads += n
+	}
+	readings["PROCS"] = float64(procs)
+	readings["THREADS"] = float64(threads)
+	values["PROCS"] = procs
+	values["THREADS"] = threads
+
+	if processesTop == 0 || len(groups) == 0 {
+		return
+	}
+	list := make([]*processGroup, 0, len(groups))
+	for _, g := range groups {
+		list = append(list, g)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].cpu != list[j].cpu {
+			return list[i].cpu > list[j].cpu
+		}
+		return list[i].name < list[j].name
+	})
+	if len(list) > processesTop {
+		list = list[:processesTop]
+	}
+	topProcesses := make([]map[string]interface{}, 0, len(list))
+	for _, g := range list {
+		topProcesses = append(topProcesses, map[string]interface{}{
+			"Name":      g.name,
+			"Processes": g.procs,
+			"Threads":   g.threads,
+			"CPU_PCT":   formatDecimal(g.cpu),
+			"RSS_MB":    formatMB(g.rssKB * 1024),
+		})
+	}
+	fields["TopProcesses"] = topProcesses
+}
+
+// topColumn returns a process's value in the column titled title, empty when
+// ps didn't report it.
+func topColumn(process []string, col map[string]int, title string) string {
+	if i, ok := col[title]; ok && i < len(process) {
+		return process[i]
+	}
+	return ""
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// protoWriter appends protocol buffers wire format. Messages are built inside
+// out: a nested message is written to its own protoWriter and embedded as
+// bytes, which is all the export formats need. protoScan reads messages back.
+type protoWriter struct {
+	buf []byte
+}
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+func (w *protoWriter) tag(field int, wire int) {
+	w.rawVarint(uint64(field)<<3 | uint64(wire))
+}
+
+func (w *protoWriter) rawVarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	w.buf = append(w.buf, b[:n]...)
+}
+
+func (w *protoWriter) varint(field int, v uint64) {
+	w.tag(field, wireVarint)
+	w.rawVarint(v)
+}
+
+// sint64 writes a zigzag encoded signed integer.
+func (w *protoWriter) sint64(field int, v int64) {
+	w.varint(field, uint64(v<<1)^uint64(v>>63))
+}
+
+func (w *protoWriter) bool(field int, v bool) {
+	if v {
+		w.varint(field, 1)
+	} else {
+		w.varint(field, 0)
+	}
+}
+
+func (w *protoWriter) fixed64(field int, v uint64) {
+	w.tag(field, wireFixed64)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	w.buf = append(w.buf, b[:]...)
+}
+
+func (w *protoWriter) double(field int, v float64) {
+	w.fixed64(field, math.Float64bits(v))
+}
+
+func (w *protoWriter) bytes(field int, b []byte) {
+	w.tag(field, wireBytes)
+	w.rawVarint(uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *protoWriter) string(field int, s string) {
+	w.tag(field, wireBytes)
+	w.rawVarint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *protoWriter) message(field int, m *protoWriter) {
+	w.bytes(field, m.buf)
+}
+
+// protoScan calls fn for every field of a message: v holds varint and fixed
+// values, b the contents of length delimited fields. Unknown fields are
+// simply passed on, so callers skip what they don't know.
+func protoScan(buf []byte, fn func(field, wire int, v uint64, b []byte)) error {
+	for len(buf) > 0 {
+		key, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return errors.New("malformed protobuf tag")
+		}
+		buf = buf[n:]
+		field, wire := int(key>>3), int(key&7)
+		switch wire {
+		case wireVarint:
+			v, n := binary.Uvarint(buf)
+			if n <= 0 {
+				return errors.New("malformed protobuf varint")
+			}
+			buf = buf[n:]
+			fn(field, wire, v, nil)
+		case wireFixed64, wireFixed32:
+			size := 8
+			if wire == wireFixed32 {
+				size = 4
+			}
+			if len(buf) < size {
+				return errors.New("truncated protobuf field")
+			}
+			var v uint64
+			if size == 8 {
+				v = binary.LittleEndian.Uint64(buf)
+			} else {
+				v = uint64(binary.LittleEndian.Uint32(buf))
+			}
+			buf = buf[size:]
+			fn(field, wire, v, nil)
+		case wireBytes:
+			l, n := binary.Uvarint(buf)
+			if n <= 0 || uint64(len(buf)-n) < l {
+				return errors.New("truncated protobuf field")
+			}
+			fn(field, wire, 0, buf[n:n+int(l)])
+			buf = buf[n+int(l):]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", wire)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	collectPSI bool
+	// cgroupRoot is where the cgroup v2 hierarchy is mounted, the host's
+	// /sys/fs/cgroup mounted in when the agent runs in a container.
+	cgroupRoot = "/sys/fs/cgroup"
+
+	psiWarnOnce sync.Once
+)
+
+// psiResources are the pressure files of a cgroup, each a PSI_<name>_ prefix.
+var psiResources = []struct {
+	file, name, label string
+}{
+	{"cpu.pressure", "CPU", "cpu"},
+	{"memory.pressure", "MEM", "memory"},
+	{"io.pressure", "IO", "io"},
+}
+
+func init() {
+	registerMetrics(psiMetrics)
+}
+
+// psiFields adds the pressure stall information of the container's cgroup:
+// for CPU, memory and IO the share of the last 10 seconds some or all of its
+// tasks were stalled waiting on it, as PSI_CPU_SOME_PCT, PSI_CPU_FULL_PCT and
+// so on, and the total stall time as PSI_CPU_SOME_STALL_US and the like.
+func psiFields(ctx context.Context, id string, readings map[string]float64, values map[string]interface{}) {
+	dir, err := containerCgroup(ctx, id)
+	if err != nil {
+		warnPSI(err)
+		return
+	}
+	for _, r := range psiResources {
+		lines, err := readPressure(filepath.Join(dir, r.file))
+		if err != nil {
+			warnPSI(err)
+			continue
+		}
+		for kind, p := range lines {
+			prefix := "PSI_" + r.name + "_" + strings.ToUpper(kind)
+			readings[prefix+"_PCT"] = p.avg10
+			readings[prefix+"_STALL_US"] = float64(p.total)
+			values[prefix+"_PCT"] = formatDecimal(p.avg10)
+		}
+	}
+}
+
+type pressure struct {
+	avg10 float64
+	total uint64
+}
+
+// readPressure parses a pressure file, keyed by "some" and "full":
+//
+//	some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+//	full avg10=0.00 avg60=0.00 avg300=0.00 total=0
+func readPressure(path string) (map[string]pressure, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	lines := map[string]pressure{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		cols := strings.Fields(scanner.Text())
+		if len(cols) == 0 || (cols[0] != "some" && cols[0] != "full") {
+			continue
+		}
+		var p pressure
+		for _, col := range cols[1:] {
+			kv := strings.SplitN(col, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "avg10":
+				p.avg10, _ = strconv.ParseFloat(kv[1], 64)
+			case "total":
+				p.total, _ = strconv.ParseUint(kv[1], 10, 64)
+			}
+		}
+		lines[cols[0]] = p
+	}
+	return lines, scanner.Err()
+}
+
+// containerCgroup returns the cgroup v2 directory of the container: the one
+// its main process is in, else where the systemd and cgroupfs drivers put
+// containers.
+func containerCgroup(ctx context.Context, id string) (string, error) {
+	candidates := []string{
+		filepath.Join(cgroupRoot, "system.slice", "docker-"+id+".scope"),
+		filepath.Join(cgroupRoot, "docker", id),
+	}
+	if proc, err := containerProc(ctx, id); err == nil {
+		if data, err := ioutil.ReadFile(filepath.Join(proc, "cgroup")); err == nil {
+			for _, line := range strings.Split(string(data), "\n") {
+				// The unified hierarchy is listed as 0::/path.
+				if strings.HasPrefix(line, "0::") {
+					candidates = append([]string{filepath.Join(cgroupRoot, strings.TrimPrefix(line, "0::"))}, candidates...)
+				}
+			}
+		}
+	}
+	for _, dir := range candidates {
+		if _, err := os.Stat(filepath.Join(dir, "cpu.pressure")); err == nil {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("no cgroup v2 pressure files for container %s under %s", id, cgroupRoot)
+}
+
+// warnPSI reports the first failure loudly and the rest at debug level.
+func warnPSI(err error) {
+	logged := false
+	psiWarnOnce.Do(func() {
+		logrus.WithFields(logrus.Fields{"error": err}).Warn("cannot read container pressure; is the host on cgroup v2 with PSI enabled and cgroup_root its /sys/fs/cgroup?")
+		logged = true
+	})
+	if !logged {
+		logrus.WithFields(logrus.Fields{"error": err}).Debug("cannot read container pressure")
+	}
+}
+
+// psiMetrics exposes the pressure readings of the last snapshot, labeled by
+// resource and by some or full.
+func psiMetrics() []metric {
+	if !collectPSI {
+		return nil
+	}
+	entries := snapshots.list()
+	percent := metric{name: "docker_container_pressure_percent", help: "Share of the last 10 seconds the container's tasks stalled on a resource.", kind: "gauge"}
+	stalled := metric{name: "docker_container_pressure_stalled_seconds_total", help: "Time the container's tasks stalled on a resource.", kind: "counter"}
+	for _, e := range entries {
+		for _, r := range psiResources {
+			for _, kind := range []string{"some", "full"} {
+				prefix := "PSI_" + r.name + "_" + strings.ToUpper(kind)
+				labels := containerLabels(e.sample)
+				labels["resource"] = r.label
+				labels["kind"] = kind
+				if v, ok := e.sample.Values[prefix+"_PCT"]; ok {
+					percent.samples = append(percent.samples, metricSample{labels: labels, value: v})
+				}
+				if v, ok := e.sample.Values[prefix+"_STALL_US"]; ok {
+					stalled.samples = append(stalled.samples, metricSample{labels: labels, value: v / 1e6})
+				}
+			}
+		}
+	}
+	return []metric{percent, stalled}
+}
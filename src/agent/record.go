@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Schemas an output can serialize records with. formatted is the original
+// log line with stats rounded to two decimals and sizes in megabytes; raw
+// keeps the full-precision readings, sizes in bytes.
+const (
+	schemaFormatted = "formatted"
+	schemaRaw       = "raw"
+)
+
+// outputSchema returns the schema selected by <output>_schema.
+func outputSchema(output string) (string, error) {
+	switch schema := os.Getenv(output + "_schema"); schema {
+	case "":
+		return schemaFormatted, nil
+	case schemaFormatted, schemaRaw:
+		return schema, nil
+	default:
+		return "", fmt.Errorf("unknown %s_schema %q", output, schema)
+	}
+}
+
+// record serializes the sample with the given schema. Both share every field
+// but Stats, and the formatted record is never modified.
+func (s sample) record(schema string) logrus.Fields {
+	if schema != schemaRaw {
+		return s.Fields
+	}
+	fields := make(logrus.Fields, len(s.Fields))
+	for k, v := range s.Fields {
+		fields[k] = v
+	}
+	fields["Stats"] = jsonReadings(s.Values)
+	return fields
+}
+
+// jsonReadings copies the readings but those JSON can't represent, NaN and
+// the infinities, which would fail the marshalling of a whole batch.
+func jsonReadings(values map[string]float64) map[string]float64 {
+	out := make(map[string]float64, len(values))
+	for k, v := range values {
+		if finite(v) {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// finite reports whether v is neither NaN nor infinite.
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// serialize serializes the samples of a tick with the given schema.
+func serialize(samples []sample, schema string) []logrus.Fields {
+	list := make([]logrus.Fields, 0, len(samples))
+	for _, s := range samples {
+		list = append(list, s.record(schema))
+	}
+	return list
+}
+
+// sortedReadings returns the names of the raw readings in order.
+func sortedReadings(values map[string]float64) []string {
+	names := make([]string, 0, len(values))
+	for k := range values {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readingName upper-cases a name for use in reading names, replacing what
+// isn't a letter or a digit with an underscore.
+func readingName(name string) string {
+	b := []byte(strings.ToUpper(name))
+	for i, c := range b {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// startRecords starts the enabled periodic records, such as volumes, in the
+// background.
+func startRecords() {
+	ctx := background
+	if collectVolumes {
+		go watchRecords(ctx, "volumes", volumesInterval, volumeRecords, lastVolumes)
+	}
+	if collectImages {
+		go watchRecords(ctx, "images", imagesInterval, imageRecords, lastImages)
+	}
+}
+
+// watchRecords collects records right away and then every interval until
+// ctx is done, keeping the last ones for /metrics and exporting them.
+func watchRecords(ctx context.Context, what string, interval time.Duration, collect func(context.Context) ([]sample, error), last *recordList) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		collectCtx, cancel := context.WithTimeout(ctx, interval)
+		records, err := collect(collectCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logrus.WithFields(logrus.Fields{"records": what, "error": err}).Warn("cannot collect records")
+		} else {
+			last.set(records)
+			exportRecords(records)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recordList keeps the last records of a kind for /metrics.
+type recordList struct {
+	mu      sync.Mutex
+	records []sample
+}
+
+func (l *recordList) set(records []sample) {
+	l.mu.Lock()
+	l.records = records
+	l.mu.Unlock()
+}
+
+func (l *recordList) get() []sample {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.records
+}
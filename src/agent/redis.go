@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"time"
+)
+
+const redisTimeout = 10 * time.Second
+
+// redisConn is a RESP connection sending pipelined commands.
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// redisError is an error reply of the server.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func dialRedis(address string, tlsConfig *tls.Config, username, password string, db int) (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", address, redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		config := tlsConfig.Clone()
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(address)
+		}
+		conn = tls.Client(conn, config)
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+
+	var setup [][]string
+	if password != "" {
+		if username != "" {
+			setup = append(setup, []string{"AUTH", username, password})
+		} else {
+			setup = append(setup, []string{"AUTH", password})
+		}
+	}
+	if db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(db)})
+	}
+	if err := c.do(setup); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// do pipelines the commands and returns the first error replied.
+func (c *redisConn) do(commands [][]string) error {
+	if len(commands) == 0 {
+		return nil
+	}
+	c.conn.SetDeadline(time.Now().Add(redisTimeout))
+	for _, args := range commands {
+		fmt.Fprintf(c.w, "*%d\r\n", len(args))
+		for _, a := range args {
+			fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(a), a)
+		}
+	}
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	var first error
+	for range commands {
+		if err := c.reply(); err != nil {
+			if _, ok := err.(redisError); !ok {
+				return err
+			}
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// reply reads and discards one reply, returning it if it's an error.
+func (c *redisConn) reply() error {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if len(line) < 3 {
+		return errors.New("redis: malformed reply")
+	}
+	body := line[1 : len(line)-2]
+	switch line[0] {
+	case '+', ':':
+		return nil
+	case '-':
+		return redisError(body)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return errors.New("redis: malformed reply")
+		}
+		if n >= 0 {
+			_, err = io.CopyN(ioutil.Discard, c.r, int64(n+2))
+		}
+		return err
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return errors.New("redis: malformed reply")
+		}
+		for ; n > 0; n-- {
+			if err := c.reply(); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
+
+func (c *redisConn) Close() error { return c.conn.Close() }
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/swarm"
+)
+
+// replayClient serves recorded StatsJSON frames instead of talking to a Docker
+// daemon. Each *.json file in the fixture directory holds the frames of one
+// container, as written by the stats API; every ContainerStats call returns the
+// next frame and wraps around at the end. An events.jsonl file holds messages
+// of the events API, sent once to every Events subscriber. Nothing else is
+// recorded, so what the daemon would say beyond that is left out.
+type replayClient struct {
+	mu         sync.Mutex
+	containers []types.Container
+	frames     map[string][][]byte
+	next       map[string]int
+	events     []events.Message
+}
+
+func newReplayClient(dir string) (*replayClient, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	r := &replayClient{frames: map[string][][]byte{}, next: map[string]int{}}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var (
+			frames [][]byte
+			first  types.StatsJSON
+		)
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+			if len(frames) == 0 {
+				if err := json.Unmarshal(raw, &first); err != nil {
+					return nil, fmt.Errorf("%s: %v", file, err)
+				}
+			}
+			frames = append(frames, raw)
+		}
+		if len(frames) == 0 {
+			continue
+		}
+
+		id := first.ID
+		if id == "" {
+			id = strings.TrimSuffix(filepath.Base(file), ".json")
+		}
+		name := first.Name
+		if name == "" {
+			name = "/" + id
+		}
+		r.frames[id] = frames
+		r.containers = append(r.containers, types.Container{
+			ID:     id,
+			Names:  []string{name},
+			Image:  "replay",
+			State:  "running",
+			Status: "Replayed from " + filepath.Base(file),
+			Labels: map[string]string{},
+		})
+	}
+	if len(r.containers) == 0 {
+		return nil, fmt.Errorf("no stats fixtures found in %s", dir)
+	}
+
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "events.jsonl")); err == nil {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var m events.Message
+			if err := dec.Decode(&m); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("events.jsonl: %v", err)
+			}
+			r.events = append(r.events, m)
+		}
+	}
+	return r, nil
+}
+
+func (r *replayClient) Ping(ctx context.Context) (types.Ping, error) {
+	return types.Ping{OSType: "linux"}, nil
+}
+
+func (r *replayClient) Info(ctx context.Context) (types.Info, error) {
+	return types.Info{
+		Containers:        len(r.containers),
+		ContainersRunning: len(r.containers),
+		NCPU:              runtime.NumCPU(),
+		OSType:            "linux",
+		Name:              "replay",
+	}, nil
+}
+
+func (r *replayClient) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	containers := make([]types.Container, len(r.containers))
+	copy(containers, r.containers)
+	return containers, nil
+}
+
+func (r *replayClient) ContainerStats(ctx context.Context, containerID string, stream bool) (types.ContainerStats, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	frames, ok := r.frames[containerID]
+	if !ok {
+		return types.ContainerStats{}, fmt.Errorf("no such container: %s", containerID)
+	}
+	frame := frames[r.next[containerID]%len(frames)]
+	r.next[containerID]++
+	if stream {
+		// A stream yields the following frames too, like the daemon sending
+		// one per second.
+		var body []byte
+		for i := 0; i < len(frames); i++ {
+			body = append(body, frames[(r.next[containerID]-1+i)%len(frames)]...)
+		}
+		frame = body
+	}
+	return types.ContainerStats{Body: ioutil.NopCloser(bytes.NewReader(frame)), OSType: "linux"}, nil
+}
+
+func (r *replayClient) Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error) {
+	messages := make(chan events.Message)
+	errs := make(chan error, 1)
+	go func() {
+		for _, m := range r.events {
+			select {
+			case messages <- m:
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
+			}
+		}
+		<-ctx.Done()
+		errs <- ctx.Err()
+	}()
+	return messages, errs
+}
+
+func (r *replayClient) ContainerTop(ctx context.Context, containerID string, arguments []string) (container.ContainerTopOKBody, error) {
+	return container.ContainerTopOKBody{}, errors.New("replay fixtures record no processes")
+}
+
+// DiskUsage has no volumes, images or sizes, which the fixtures don't record.
+func (r *replayClient) DiskUsage(ctx context.Context) (types.DiskUsage, error) {
+	return types.DiskUsage{}, nil
+}
+
+func (r *replayClient) ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error) {
+	return types.ImageInspect{}, nil, fmt.Errorf("no such image: %s", imageID)
+}
+
+// The replayed host is never a swarm manager, so the swarm listings stay empty.
+
+func (r *replayClient) TaskList(ctx context.Context, options types.TaskListOptions) ([]swarm.Task, error) {
+	return nil, nil
+}
+
+func (r *replayClient) ServiceList(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error) {
+	return nil, nil
+}
+
+func (r *replayClient) NodeList(ctx context.Context, options types.NodeListOptions) ([]swarm.Node, error) {
+	return nil, nil
+}
+
+func (r *replayClient) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	for _, c := range r.containers {
+		if c.ID == containerID {
+			return types.ContainerJSON{
+				ContainerJSONBase: &types.ContainerJSONBase{
+					ID:    c.ID,
+					Name:  c.Names[0],
+					Image: c.Image,
+					State: &types.ContainerState{Status: c.State, Running: true},
+				},
+				Config: &container.Config{Image: c.Image, Labels: c.Labels},
+			}, nil
+		}
+	}
+	return types.ContainerJSON{}, fmt.Errorf("no such container: %s", containerID)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// rotatingFile appends to the file named by <output>_path, moving it aside
+// as <name>-<UTC time><ext> once it grows past <output>_max_size or when
+// <output>_rotate_interval starts a new period, e.g. every midnight UTC with
+// 24h. With <output>_compress=true rotated files are gzipped in the
+// background. Only the newest <output>_max_files rotated files are kept when
+// set.
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	interval time.Duration
+	maxFiles int
+	compress bool
+	// header starts every new file, e.g. the column names of a CSV file.
+	header []byte
+
+	f           *os.File
+	size        int64
+	opened      time.Time
+	compressing sync.WaitGroup
+}
+
+func newRotatingFile(output string) (*rotatingFile, error) {
+	r := &rotatingFile{path: os.Getenv(output + "_path")}
+	if r.path == "" {
+		return nil, fmt.Errorf("%s_path is required", output)
+	}
+	if v := os.Getenv(output + "_max_size"); v != "" {
+		n, err := parseSize(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid %s_max_size %q, expected a size such as 100MB", output, v)
+		}
+		r.maxSize = n
+	}
+	if v := os.Getenv(output + "_rotate_interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid %s_rotate_interval %q", output, v)
+		}
+		r.interval = d
+	}
+	if v := os.Getenv(output + "_max_files"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid %s_max_files %q", output, v)
+		}
+		r.maxFiles = n
+	}
+	var err error
+	if r.compress, err = boolSetting(output + "_compress"); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Write appends p, rotating first when p would take the file past its size
+// or a new period began. p is never split across files.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	now := time.Now().UTC()
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	if r.size > int64(len(r.header)) && r.due(now, len(p)) {
+		if err := r.rotate(now); err != nil {
+			return 0, err
+		}
+	}
+	if r.size == 0 && len(r.header) > 0 {
+		n, err := r.f.Write(r.header)
+		r.size += int64(n)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) due(now time.Time, n int) bool {
+	if r.maxSize > 0 && r.size+int64(n) > r.maxSize {
+		return true
+	}
+	return r.interval > 0 && now.Truncate(r.interval).After(r.opened.Truncate(r.interval))
+}
+
+// open continues the file left by a previous run, which counts as opened at
+// its last write.
+func (r *rotatingFile) open() error {
+	if dir := filepath.Dir(r.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size, r.opened = f, info.Size(), info.ModTime().UTC()
+	if r.size == 0 {
+		r.opened = time.Now().UTC()
+	}
+	return nil
+}
+
+func (r *rotatingFile) rotate(now time.Time) error {
+	r.f.Close()
+	r.f = nil
+	ext := filepath.Ext(r.path)
+	base := strings.TrimSuffix(r.path, ext) + "-" + now.Format("20060102T150405")
+	name := base + ext
+	for i := 1; fileExists(name) || fileExists(name+".gz"); i++ {
+		name = base + "." + strconv.Itoa(i) + ext
+	}
+	if err := os.Rename(r.path, name); err != nil {
+		return err
+	}
+	if r.compress {
+		r.compressing.Add(1)
+		go func() {
+			defer r.compressing.Done()
+			if err := gzipFile(name); err != nil {
+				logrus.WithFields(logrus.Fields{"path": name, "error": err}).Error("error compressing rotated file")
+			}
+			r.prune()
+		}()
+	} else {
+		r.prune()
+	}
+	return r.open()
+}
+
+// prune removes the oldest rotated files beyond maxFiles. Their names sort
+// by rotation time.
+func (r *rotatingFile) prune() {
+	if r.maxFiles == 0 {
+		return
+	}
+	ext := filepath.Ext(r.path)
+	rotated, _ := filepath.Glob(strings.TrimSuffix(r.path, ext) + "-[0-9]*T[0-9]*" + ext + "*")
+	sort.Strings(rotated)
+	for len(rotated) > r.maxFiles {
+		os.Remove(rotated[0])
+		rotated = rotated[1:]
+	}
+}
+
+// Close waits for the rotated files being compressed.
+func (r *rotatingFile) Close() error {
+	r.compressing.Wait()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// gzipFile replaces name with name.gz.
+func gzipFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// parseSize reads a byte count such as 1048576, 512KB, 100MB or 1GB.
+func parseSize(v string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(v))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, unit.suffix) {
+			s, multiplier = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix)), unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * multiplier, nil
+}
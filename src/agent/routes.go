@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// route sends the samples it matches to Output. Match holds regular
+// expressions keyed by field (Names, Image, State, Status or Labels.<key>) and
+// Above holds minimum readings keyed by metric (CPU_PCT, MEM_PCT, ...). A sample
+// matches when every condition holds.
+//
+// An output named by at least one route only receives matching samples;
+// outputs without routes receive everything.
+type route struct {
+	Match  map[string]string  `json:"match"`
+	Above  map[string]float64 `json:"above"`
+	Output string             `json:"output"`
+
+	patterns map[string]*regexp.Regexp
+}
+
+var routes []route
+
+// parseRoutes reads rules from JSON such as
+// [{"match":{"Status":"unhealthy"},"output":"webhook"},{"above":{"CPU_PCT":90},"output":"webhook"}].
+func parseRoutes(v string) ([]route, error) {
+	var rules []route
+	if err := json.Unmarshal([]byte(v), &rules); err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		r := &rules[i]
+		if r.Output == "" {
+			return nil, fmt.Errorf("route %d has no output", i)
+		}
+		r.patterns = map[string]*regexp.Regexp{}
+		for field, expr := range r.Match {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("route %d: %v", i, err)
+			}
+			r.patterns[field] = re
+		}
+	}
+	return rules, nil
+}
+
+// checkRoutes fails on a route to an output that isn't configured, which
+// would never match and leave its samples to the other outputs.
+func checkRoutes(rules []route, exporters []Exporter) error {
+	configured := map[string]bool{}
+	for _, e := range exporters {
+		configured[e.Name()] = true
+	}
+	for i, r := range rules {
+		if !configured[r.Output] {
+			return fmt.Errorf("route %d sends to output %q, which isn't in outputs", i, r.Output)
+		}
+	}
+	return nil
+}
+
+// routeSamples returns the samples the named output should receive.
+func routeSamples(output string, samples []sample) []sample {
+	var own []route
+	for _, r := range routes {
+		if r.Output == output {
+			own = append(own, r)
+		}
+	}
+	if len(own) == 0 {
+		return samples
+	}
+
+	var routed []sample
+	for _, s := range samples {
+		for _, r := range own {
+			if r.matches(s) {
+				routed = append(routed, s)
+				break
+			}
+		}
+	}
+	return routed
+}
+
+func (r route) matches(s sample) bool {
+	for metric, min := range r.Above {
+		if v, ok := s.Values[metric]; !ok || v < min {
+			return false
+		}
+	}
+	for field, re := range r.patterns {
+		if !re.MatchString(fieldString(s, field)) {
+			return false
+		}
+	}
+	return true
+}
+
+// fieldString renders a sample field for matching; Labels.<key> selects a label.
+func fieldString(s sample, field string) string {
+	if strings.HasPrefix(field, "Labels.") {
+		return s.Labels[strings.TrimPrefix(field, "Labels.")]
+	}
+	switch v := s.Fields[field].(type) {
+	case string:
+		return v
+	case []string:
+		return strings.Join(v, ",")
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
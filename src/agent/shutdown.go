@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron"
+	"github.com/sirupsen/logrus"
+)
+
+var shutdownTimeout = 10 * time.Second
+
+var (
+	collectorMu sync.Mutex
+	collector   *cron.Cron
+	stopping    bool
+
+	// inFlight counts running ticks so shutdown can let them reach the exporters.
+	inFlight sync.WaitGroup
+
+	// background is done once shutdown starts. It stops what collection
+	// starts, such as the events subscription, even when the daemon was only
+	// reached after shutdown began.
+	background, stopBackground = context.WithCancel(context.Background())
+)
+
+// beginTick registers a running tick, failing once shutdown has started.
+func beginTick() bool {
+	collectorMu.Lock()
+	defer collectorMu.Unlock()
+	if stopping {
+		return false
+	}
+	inFlight.Add(1)
+	return true
+}
+
+// shutdown stops collection, lets in-flight ticks finish and flushes every
+// exporter, all within shutdownTimeout.
+func shutdown(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	collectorMu.Lock()
+	stopping = true
+	if collector != nil {
+		collector.Stop()
+	}
+	collectorMu.Unlock()
+	intervals.stopAll()
+	stopBackground()
+	statStreams.closeAll()
+
+	if err := server.Shutdown(ctx); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err}).Warn("error shutting down http server")
+	}
+	if grpcServer != nil {
+		streams.close()
+		if err := grpcServer.Shutdown(ctx); err != nil {
+			logrus.WithFields(logrus.Fields{"error": err}).Warn("error shutting down grpc server")
+		}
+	}
+
+	if !wait(ctx, inFlight.Wait) {
+		logrus.Warn("shutdown timeout reached before in-flight collection finished")
+	}
+
+	var closing sync.WaitGroup
+	for _, e := range exporters {
+		closing.Add(1)
+		go func(e Exporter) {
+			defer closing.Done()
+			if err := e.Close(); err != nil {
+				logrus.WithFields(logrus.Fields{"output": e.Name(), "error": err}).Error("error flushing output")
+			}
+		}(e)
+	}
+	if !wait(ctx, closing.Wait) {
+		logrus.Warn("shutdown timeout reached before all outputs were flushed")
+	}
+	history.close()
+}
+
+// wait runs fn and reports whether it returned before ctx expired.
+func wait(ctx context.Context, fn func()) bool {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import "encoding/binary"
+
+// Snappy compresses blocks of up to 64KiB independently, so every copy
+// offset fits in two bytes. Matches are found through a hash table of the
+// last position each 4-byte sequence was seen at.
+const (
+	snappyBlockSize = 1 << 16
+	snappyTableBits = 14
+	// Sequences shorter than this aren't worth looking for matches in.
+	snappyMinInput = 17
+)
+
+// snappyEncode compresses b in the snappy block format remote_write
+// receivers expect: the uncompressed length followed by literals and copies.
+func snappyEncode(b []byte) []byte {
+	var lenBuf [binary.MaxVarintLen64]byte
+	out := append([]byte(nil), lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(b)))]...)
+	for len(b) > 0 {
+		n := len(b)
+		if n > snappyBlockSize {
+			n = snappyBlockSize
+		}
+		out = snappyBlock(out, b[:n])
+		b = b[n:]
+	}
+	return out
+}
+
+// snappyBlock appends the compressed form of src, at most snappyBlockSize
+// bytes, to out.
+func snappyBlock(out, src []byte) []byte {
+	if len(src) < snappyMinInput {
+		return snappyLiteral(out, src)
+	}
+	var table [1 << snappyTableBits]uint16
+	hash := func(i int) uint32 {
+		return (binary.LittleEndian.Uint32(src[i:]) * 0x1e35a7bd) >> (32 - snappyTableBits)
+	}
+
+	literal := 0
+	for s := 0; s+4 <= len(src); {
+		h := hash(s)
+		candidate := int(table[h])
+		table[h] = uint16(s)
+		if candidate >= s || binary.LittleEndian.Uint32(src[candidate:]) != binary.LittleEndian.Uint32(src[s:]) {
+			s++
+			continue
+		}
+		out = snappyLiteral(out, src[literal:s])
+		start := s
+		s, candidate = s+4, candidate+4
+		for s < len(src) && src[s] == src[candidate] {
+			s, candidate = s+1, candidate+1
+		}
+		out = snappyCopy(out, s-candidate, s-start)
+		literal = s
+	}
+	return snappyLiteral(out, src[literal:])
+}
+
+// snappyLiteral appends lit, at most 64KiB, as one literal element.
+func snappyLiteral(out, lit []byte) []byte {
+	if len(lit) == 0 {
+		return out
+	}
+	// Up to 60 bytes the length minus one is in the tag itself, otherwise
+	// in the following 1 or 2 bytes.
+	switch m := len(lit) - 1; {
+	case m < 60:
+		out = append(out, byte(m)<<2)
+	case m < 1<<8:
+		out = append(out, 60<<2, byte(m))
+	default:
+		out = append(out, 61<<2, byte(m), byte(m>>8))
+	}
+	return append(out, lit...)
+}
+
+// snappyCopy appends copy elements repeating length bytes from offset back,
+// length being at least 4.
+func snappyCopy(out []byte, offset, length int) []byte {
+	// A 2-byte offset copy takes up to 64 bytes; the split leaves at least 4
+	// for the last one.
+	for length >= 68 {
+		out = append(out, 63<<2|2, byte(offset), byte(offset>>8))
+		length -= 64
+	}
+	if length > 64 {
+		out = append(out, 59<<2|2, byte(offset), byte(offset>>8))
+		length -= 60
+	}
+	if length >= 12 || offset >= 2048 {
+		return append(out, byte(length-1)<<2|2, byte(offset), byte(offset>>8))
+	}
+	return append(out, byte(offset>>8)<<5|byte(length-4)<<2|1, byte(offset))
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	api "agent/client"
+	"github.com/sirupsen/logrus"
+)
+
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+type snapshotEntry struct {
+	Collected time.Time     `json:"collected"`
+	Record    logrus.Fields `json:"record"`
+
+	sample sample
+}
+
+// snapshotStore keeps the last record of every container for /stats.
+type snapshotStore struct {
+	mu      sync.Mutex
+	entries map[string]snapshotEntry
+}
+
+var snapshots = &snapshotStore{entries: map[string]snapshotEntry{}}
+
+func (s *snapshotStore) update(samples []sample) {
+	now := time.Now().UTC()
+	s.mu.Lock()
+	for _, smp := range samples {
+		s.entries[smp.ID] = snapshotEntry{Collected: now, Record: smp.Fields, sample: smp}
+	}
+	s.mu.Unlock()
+}
+
+func (s *snapshotStore) forget(id string) {
+	s.mu.Lock()
+	delete(s.entries, id)
+	s.mu.Unlock()
+}
+
+func (s *snapshotStore) size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// list returns the entries ordered by container ID.
+func (s *snapshotStore) list() []snapshotEntry {
+	s.mu.Lock()
+	ids := make([]string, 0, len(s.entries))
+	for id := range s.entries {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	list := make([]snapshotEntry, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, s.entries[id])
+	}
+	s.mu.Unlock()
+	return list
+}
+
+// statsFilter narrows /stats to the containers matching every given query
+// parameter: name, state and min_cpu.
+type statsFilter struct {
+	name   string
+	state  string
+	minCPU float64
+	hasCPU bool
+}
+
+func parseStatsFilter(q url.Values) (statsFilter, error) {
+	f := statsFilter{
+		name:  strings.TrimPrefix(q.Get("name"), "/"),
+		state: q.Get("state"),
+	}
+	if v := q.Get("min_cpu"); v != "" {
+		cpu, err := strconv.ParseFloat(v, 64)
+		if err != nil || cpu < 0 {
+			return f, fmt.Errorf("invalid min_cpu %q", v)
+		}
+		f.minCPU, f.hasCPU = cpu, true
+	}
+	return f, nil
+}
+
+func (f statsFilter) match(e snapshotEntry) bool {
+	if f.name != "" && !hasName(e.sample, f.name) {
+		return false
+	}
+	if f.state != "" && e.Record["State"] != f.state {
+		return false
+	}
+	if f.hasCPU && e.sample.Values["CPU_PCT"] < f.minCPU {
+		return false
+	}
+	return true
+}
+
+func hasName(s sample, name string) bool {
+	names, _ := s.Fields["Names"].([]string)
+	for _, n := range names {
+		if strings.TrimPrefix(n, "/") == name {
+			return true
+		}
+	}
+	return false
+}
+
+// serveStats returns the last record collected for every container matching
+// the query filters.
+func serveStats(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseStatsFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	list := []snapshotEntry{}
+	for _, e := range snapshots.list() {
+		if filter.match(e) {
+			list = append(list, e)
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// serveScrape collects every container right away and returns the records.
+// They aren't exported nor stored, so a scrape can't add to what the outputs
+// and the tick state see.
+func serveScrape(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !dockerConnected() {
+		http.Error(w, "not connected to docker yet", http.StatusServiceUnavailable)
+		return
+	}
+	if !beginTick() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer inFlight.Done()
+
+	ctx, cancel := tickContext(expectedInterval(time.Now()))
+	defer cancel()
+	containers, err := listContainers(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	samples := collect(collectionContext(ctx, collectScrape), containers)
+	records := make([]logrus.Fields, 0, len(samples))
+	for _, s := range samples {
+		records = append(records, s.Fields)
+	}
+	writeJSON(w, http.StatusOK, records)
+}
+
+func serveVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, api.Version{Version: version, Schema: api.SchemaVersion, Go: runtime.Version()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
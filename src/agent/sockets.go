@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	collectSockets bool
+
+	socketWarnOnce sync.Once
+)
+
+// tcpStates names the connection states of /proc/net/tcp by the kernel's
+// number for them, which the table lists in hexadecimal.
+var tcpStates = []string{
+	1:  "ESTABLISHED",
+	2:  "SYN_SENT",
+	3:  "SYN_RECV",
+	4:  "FIN_WAIT1",
+	5:  "FIN_WAIT2",
+	6:  "TIME_WAIT",
+	7:  "CLOSE",
+	8:  "CLOSE_WAIT",
+	9:  "LAST_ACK",
+	10: "LISTEN",
+	11: "CLOSING",
+}
+
+func init() {
+	registerMetrics(socketMetrics)
+}
+
+// socketFields counts the TCP and UDP sockets of the container's network
+// namespace, read through its main process, as TCP_SOCKETS and UDP_SOCKETS,
+// and the TCP sockets in every state as TCP_ESTABLISHED, TCP_TIME_WAIT and
+// so on. Containers on the host network share the host's sockets and are
+// skipped.
+func socketFields(ctx context.Context, id string, readings map[string]float64, values map[string]interface{}) {
+	if inspect, err := inspects.get(ctx, id); err == nil && inspect.ContainerJSONBase != nil && inspect.HostConfig != nil && inspect.HostConfig.NetworkMode.IsHost() {
+		return
+	}
+	dir, err := containerProc(ctx, id)
+	if err != nil {
+		warnSockets(err)
+		return
+	}
+
+	states := map[string]int{}
+	var tcp, udp int
+	for _, file := range []string{"tcp", "tcp6"} {
+		n, err := readSockets(filepath.Join(dir, "net", file), states)
+		if os.IsNotExist(err) && file == "tcp" {
+			// The cached PID is gone, most likely because the container restarted.
+			inspects.invalidate(id)
+		}
+		if err != nil && !(os.IsNotExist(err) && file == "tcp6") {
+			warnSockets(err)
+			return
+		}
+		tcp += n
+	}
+	for _, file := range []string{"udp", "udp6"} {
+		n, err := readSockets(filepath.Join(dir, "net", file), nil)
+		if err != nil && !os.IsNotExist(err) {
+			warnSockets(err)
+			return
+		}
+		udp += n
+	}
+
+	readings["TCP_SOCKETS"] = float64(tcp)
+	readings["UDP_SOCKETS"] = float64(udp)
+	values["TCP_SOCKETS"] = tcp
+	values["UDP_SOCKETS"] = udp
+	for _, state := range tcpStates[1:] {
+		readings["TCP_"+state] = float64(states[state])
+		values["TCP_"+state] = states[state]
+	}
+}
+
+// readSockets returns the number of sockets listed in a /proc/net table and
+// counts them by state when states isn't nil.
+func readSockets(path string, states map[string]int) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var n int
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		// sl local_address rem_address st ...
+		cols := strings.Fields(scanner.Text())
+		if len(cols) < 4 {
+			continue
+		}
+		n++
+		if states != nil {
+			if code, err := strconv.ParseUint(cols[3], 16, 8); err == nil && code > 0 && int(code) < len(tcpStates) {
+				states[tcpStates[code]]++
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("%s: %v", path, err)
+	}
+	return n, nil
+}
+
+// warnSockets reports the first failure loudly and the rest at debug level.
+func warnSockets(err error) {
+	logged := false
+	socketWarnOnce.Do(func() {
+		logrus.WithFields(logrus.Fields{"error": err}).Warn("cannot count container sockets; does the agent run with --pid=host?")
+		logged = true
+	})
+	if !logged {
+		logrus.WithFields(logrus.Fields{"error": err}).Debug("cannot count container sockets")
+	}
+}
+
+// socketMetrics exposes the socket counts of the last snapshot, labeled by
+// protocol and, for TCP connections, by state.
+func socketMetrics() []metric {
+	if !collectSockets {
+		return nil
+	}
+	entries := snapshots.list()
+	sockets := metric{name: "docker_container_sockets", help: "Open sockets in the container's network namespace.", kind: "gauge"}
+	connections := metric{name: "docker_container_tcp_connections", help: "TCP sockets in the container's network namespace by state.", kind: "gauge"}
+	for _, e := range entries {
+		for _, protocol := range []string{"tcp", "udp"} {
+			if v, ok := e.sample.Values[strings.ToUpper(protocol)+"_SOCKETS"]; ok {
+				labels := containerLabels(e.sample)
+				labels["protocol"] = protocol
+				sockets.samples = append(sockets.samples, metricSample{labels: labels, value: v})
+			}
+		}
+		for _, state := range tcpStates[1:] {
+			if v, ok := e.sample.Values["TCP_"+state]; ok {
+				labels := containerLabels(e.sample)
+				labels["state"] = state
+				connections.samples = append(connections.samples, metricSample{labels: labels, value: v})
+			}
+		}
+	}
+	return []metric{sockets, connections}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// Ways of reading a container's stats each tick. oneshot asks for a single
+// frame and relies on its PreCPU reading for the CPU delta; burst opens a
+// stream, reads two frames about a second apart and closes it, so the delta
+// always covers a known, short window; stream keeps a stream open per
+// container and takes its latest frame, the CPU delta covering the time since
+// the previous tick.
+const (
+	statsModeOneshot = "oneshot"
+	statsModeBurst   = "burst"
+	statsModeStream  = "stream"
+)
+
+var statsMode = statsModeOneshot
+
+const burstFrames = 2
+
+// readStats returns the stats frame to compute a sample from and the OS type
+// of the container.
+func readStats(ctx context.Context, source dockerAPI, id string) (*types.StatsJSON, string, error) {
+	if statsMode == statsModeStream {
+		return statStreams.read(ctx, source, id)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	stream := statsMode == statsModeBurst
+	stats, err := source.ContainerStats(ctx, id, stream)
+	if err != nil {
+		return nil, "", err
+	}
+	defer stats.Body.Close()
+
+	frames := 1
+	if stream {
+		frames = burstFrames
+	}
+	dec := json.NewDecoder(stats.Body)
+	var info *types.StatsJSON
+	for i := 0; i < frames; i++ {
+		if err := dec.Decode(&info); err != nil {
+			return nil, "", decodeError{err}
+		}
+	}
+	return info, stats.OSType, nil
+}
+
+// statsStream is a long-lived stats stream of a container, decoded in the
+// background as the daemon sends a frame about every second.
+type statsStream struct {
+	cancel context.CancelFunc
+	ready  chan struct{} // closed on the first frame or when the stream ends
+
+	mu       sync.Mutex
+	latest   *types.StatsJSON
+	osType   string
+	err      error
+	done     bool
+	lastRead *types.StatsJSON
+}
+
+func (s *statsStream) run(ctx context.Context, source dockerAPI, id string) {
+	var once sync.Once
+	signal := func() { once.Do(func() { close(s.ready) }) }
+	defer signal()
+
+	stats, err := source.ContainerStats(ctx, id, true)
+	if err != nil {
+		s.finish(err)
+		return
+	}
+	defer stats.Body.Close()
+	s.mu.Lock()
+	s.osType = stats.OSType
+	s.mu.Unlock()
+
+	dec := json.NewDecoder(stats.Body)
+	for {
+		var frame *types.StatsJSON
+		if err := dec.Decode(&frame); err != nil {
+			s.finish(decodeError{err})
+			return
+		}
+		s.mu.Lock()
+		s.latest = frame
+		s.mu.Unlock()
+		signal()
+	}
+}
+
+func (s *statsStream) finish(err error) {
+	s.mu.Lock()
+	s.err, s.done = err, true
+	s.mu.Unlock()
+}
+
+// frame returns the latest frame with the CPU and PreCPU readings of the
+// previous kept call, so the deltas of consecutive ticks leave no gap.
+func (s *statsStream) frame(keep bool) (*types.StatsJSON, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.latest == nil {
+		return nil, false
+	}
+	frame := *s.latest
+	if s.lastRead != nil && s.lastRead.Read.Before(frame.Read) {
+		frame.PreRead = s.lastRead.Read
+		frame.PreCPUStats = s.lastRead.CPUStats
+	}
+	if keep {
+		s.lastRead = s.latest
+	}
+	return &frame, true
+}
+
+// streamSet keeps the stats stream of every container read in stream mode.
+type streamSet struct {
+	mu      sync.Mutex
+	streams map[string]*statsStream
+}
+
+var statStreams = &streamSet{streams: map[string]*statsStream{}}
+
+// read returns the latest frame of the container's stream, opening it or
+// replacing one the daemon ended, and waiting for its first frame.
+func (ss *streamSet) read(ctx context.Context, source dockerAPI, id string) (*types.StatsJSON, string, error) {
+	ss.mu.Lock()
+	s, ok := ss.streams[id]
+	if ok {
+		s.mu.Lock()
+		ended := s.done
+		s.mu.Unlock()
+		if ended {
+			s.cancel()
+			ok = false
+		}
+	}
+	if !ok {
+		streamCtx, cancel := context.WithCancel(context.Background())
+		s = &statsStream{cancel: cancel, ready: make(chan struct{})}
+		ss.streams[id] = s
+		go s.run(streamCtx, source, id)
+	}
+	ss.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	select {
+	case <-s.ready:
+	case <-ctx.Done():
+		return nil, "", ctx.Err()
+	}
+	if frame, ok := s.frame(collection(ctx) != collectScrape); ok {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return frame, s.osType, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return nil, "", s.err
+}
+
+// forget closes the stream of a container gone from the listing.
+func (ss *streamSet) forget(id string) {
+	ss.mu.Lock()
+	if s, ok := ss.streams[id]; ok {
+		s.cancel()
+		delete(ss.streams, id)
+	}
+	ss.mu.Unlock()
+}
+
+func (ss *streamSet) size() int {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return len(ss.streams)
+}
+
+// closeAll closes every stream on shutdown.
+func (ss *streamSet) closeAll() {
+	ss.mu.Lock()
+	for id, s := range ss.streams {
+		s.cancel()
+		delete(ss.streams, id)
+	}
+	ss.mu.Unlock()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+// fakeStats answers the stats and inspect calls of a tick from a canned frame,
+// without the second the daemon takes between streamed frames.
+type fakeStats struct {
+	dockerAPI
+	frame []byte
+}
+
+func newFakeStats() *fakeStats {
+	now := time.Now()
+	var frame types.StatsJSON
+	frame.Read, frame.PreRead = now, now.Add(-time.Second)
+	frame.CPUStats.CPUUsage.TotalUsage = 2e9
+	frame.CPUStats.SystemUsage = 8e9
+	frame.CPUStats.OnlineCPUs = 4
+	frame.PreCPUStats.CPUUsage.TotalUsage = 1e9
+	frame.PreCPUStats.SystemUsage = 4e9
+	frame.MemoryStats.Usage = 100 << 20
+	frame.MemoryStats.Limit = 1 << 30
+	frame.PidsStats.Current = 12
+	frame.Networks = map[string]types.NetworkStats{"eth0": {RxBytes: 1 << 20, TxBytes: 2 << 20}}
+	frame.BlkioStats.IoServiceBytesRecursive = []types.BlkioStatEntry{{Op: "Read", Value: 4096}, {Op: "Write", Value: 8192}}
+	data, _ := json.Marshal(frame)
+	return &fakeStats{frame: data}
+}
+
+func (f *fakeStats) ContainerStats(ctx context.Context, id string, stream bool) (types.ContainerStats, error) {
+	body := f.frame
+	if stream {
+		body = bytes.Repeat(f.frame, burstFrames)
+	}
+	return types.ContainerStats{Body: ioutil.NopCloser(bytes.NewReader(body)), OSType: "linux"}, nil
+}
+
+func (f *fakeStats) ContainerInspect(ctx context.Context, id string) (types.ContainerJSON, error) {
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:         id,
+			Created:    time.Now().Add(-time.Hour).Format(time.RFC3339Nano),
+			State:      &types.ContainerState{Status: "running", Running: true, StartedAt: time.Now().Add(-time.Hour).Format(time.RFC3339Nano)},
+			HostConfig: &container.HostConfig{},
+		},
+		Config: &container.Config{},
+	}, nil
+}
+
+func benchmarkCollect(b *testing.B, mode string) {
+	defer func(api dockerAPI, m string) { dockerClient, statsMode = api, m }(dockerClient, statsMode)
+	dockerClient, statsMode = newFakeStats(), mode
+
+	containers := make([]types.Container, 20)
+	for i := range containers {
+		id := strconv.Itoa(i)
+		containers[i] = types.Container{ID: id, Names: []string{"/bench-" + id}, Image: "bench", State: "running"}
+	}
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if samples := collect(ctx, containers); len(samples) != len(containers) {
+			b.Fatalf("collected %d samples of %d containers", len(samples), len(containers))
+		}
+	}
+}
+
+func BenchmarkCollectBurst(b *testing.B) { benchmarkCollect(b, statsModeBurst) }
+
+func BenchmarkCollectOneshot(b *testing.B) { benchmarkCollect(b, statsModeOneshot) }
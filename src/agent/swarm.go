@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	swarmMode bool
+	// swarmNodeHost is the Docker host of other nodes, with {addr} replaced
+	// by the node address, e.g. tcp://{addr}:2375. Without it only the tasks
+	// running on this node can be collected.
+	swarmNodeHost string
+)
+
+type swarmTags struct {
+	NodeID   string
+	Node     string
+	Service  string
+	TaskID   string
+	TaskSlot int
+}
+
+// swarmState maps the containers of running tasks to their task and to the
+// daemon their stats come from. It is rebuilt on every global tick.
+type swarmState struct {
+	mu      sync.Mutex
+	tags    map[string]swarmTags
+	remote  map[string]dockerAPI // container ID -> node daemon
+	clients map[string]dockerAPI // node address -> client
+	warned  bool
+}
+
+var cluster = &swarmState{tags: map[string]swarmTags{}, remote: map[string]dockerAPI{}, clients: map[string]dockerAPI{}}
+
+// expand returns the local containers plus, when running on a manager, the
+// containers of tasks scheduled on other reachable nodes. Off a manager it
+// falls back to the local containers.
+func (s *swarmState) expand(ctx context.Context, local []types.Container) []types.Container {
+	info, err := dockerClient.Info(ctx)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err}).Warn("error getting docker info")
+		return local
+	}
+	if !info.Swarm.ControlAvailable {
+		s.mu.Lock()
+		if !s.warned {
+			logrus.Warn("swarm_mode is set but this node is not a swarm manager, collecting local containers only")
+			s.warned = true
+		}
+		s.tags, s.remote = map[string]swarmTags{}, map[string]dockerAPI{}
+		s.mu.Unlock()
+		return local
+	}
+
+	tasks, err := dockerClient.TaskList(ctx, types.TaskListOptions{Filters: filters.NewArgs(filters.Arg("desired-state", "running"))})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err}).Warn("error listing swarm tasks")
+		return local
+	}
+	services, err := dockerClient.ServiceList(ctx, types.ServiceListOptions{})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err}).Warn("error listing swarm services")
+		return local
+	}
+	nodes, err := dockerClient.NodeList(ctx, types.NodeListOptions{})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err}).Warn("error listing swarm nodes")
+		return local
+	}
+
+	serviceNames := make(map[string]string, len(services))
+	for _, svc := range services {
+		serviceNames[svc.ID] = svc.Spec.Name
+	}
+	nodeByID := make(map[string]swarm.Node, len(nodes))
+	for _, n := range nodes {
+		nodeByID[n.ID] = n
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tags, s.remote = map[string]swarmTags{}, map[string]dockerAPI{}
+	containers := local
+	for _, t := range tasks {
+		id := t.Status.ContainerStatus.ContainerID
+		if id == "" || t.Status.State != swarm.TaskStateRunning {
+			continue
+		}
+		node := nodeByID[t.NodeID]
+		s.tags[id] = swarmTags{
+			NodeID:   t.NodeID,
+			Node:     node.Description.Hostname,
+			Service:  serviceNames[t.ServiceID],
+			TaskID:   t.ID,
+			TaskSlot: t.Slot,
+		}
+		if t.NodeID == info.Swarm.NodeID || swarmNodeHost == "" {
+			continue
+		}
+
+		api, err := s.nodeClient(node.Status.Addr)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"node": node.Description.Hostname, "error": err}).Warn("cannot reach swarm node")
+			continue
+		}
+		s.remote[id] = api
+
+		c := types.Container{
+			ID:      id,
+			Names:   []string{fmt.Sprintf("/%s.%d.%s", serviceNames[t.ServiceID], t.Slot, t.ID)},
+			State:   "running",
+			Status:  t.Status.Message,
+			Labels:  map[string]string{},
+			Created: t.CreatedAt.Unix(),
+		}
+		if spec := t.Spec.ContainerSpec; spec != nil {
+			c.Image = spec.Image
+			if spec.Labels != nil {
+				c.Labels = spec.Labels
+			}
+		}
+		containers = append(containers, c)
+	}
+	return containers
+}
+
+// nodeClient returns a cached client for the daemon of the node at addr.
+func (s *swarmState) nodeClient(addr string) (dockerAPI, error) {
+	if c, ok := s.clients[addr]; ok {
+		return c, nil
+	}
+	if addr == "" {
+		return nil, fmt.Errorf("node has no address")
+	}
+	c, err := client.NewClient(strings.Replace(swarmNodeHost, "{addr}", addr, -1), api.DefaultVersion, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.clients[addr] = c
+	return c, nil
+}
+
+// source returns the daemon serving the stats of a container and whether it is
+// this agent's own daemon.
+func (s *swarmState) source(id string) (dockerAPI, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if api, ok := s.remote[id]; ok {
+		return api, false
+	}
+	return dockerClient, true
+}
+
+// fields adds the swarm tags of a task container to its record.
+func (s *swarmState) fields(id string, fields logrus.Fields) {
+	s.mu.Lock()
+	t, ok := s.tags[id]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	fields["SwarmNodeID"] = t.NodeID
+	fields["SwarmNode"] = t.Node
+	fields["SwarmService"] = t.Service
+	fields["SwarmTaskID"] = t.TaskID
+	fields["SwarmTaskSlot"] = t.TaskSlot
+}
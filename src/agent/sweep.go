@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// containerState is implemented by every cache keyed by container ID so the
+// sweeper can drop entries of containers that went away.
+type containerState interface {
+	forget(id string)
+	size() int
+}
+
+// sweeper remembers the tick each container was last listed on and prunes the
+// per-container state of those missing for more than retention ticks.
+type sweeper struct {
+	mu        sync.Mutex
+	tick      int
+	retention int
+	lastSeen  map[string]int
+	states    map[string]containerState
+}
+
+var retention = &sweeper{
+	retention: 10,
+	lastSeen:  map[string]int{},
+	states: map[string]containerState{
+		"scheduler": tickScheduler,
+		"alerts":    alerts,
+		"inspect":   inspects,
+		"snapshot":  snapshots,
+		"lifespan":  lifespans,
+		"idle":      idle,
+		"pid_trend": pidTrends,
+		"iops":      iops,
+		"oom":       oomKills,
+		"streams":   statStreams,
+	},
+}
+
+func init() {
+	registerMetrics(retention.metrics)
+}
+
+// observe marks the listed containers as seen on a new tick and sweeps.
+func (s *sweeper) observe(ids []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tick++
+	for _, id := range ids {
+		s.lastSeen[id] = s.tick
+	}
+	for id, tick := range s.lastSeen {
+		if s.tick-tick <= s.retention {
+			continue
+		}
+		for _, st := range s.states {
+			st.forget(id)
+		}
+		delete(s.lastSeen, id)
+		logrus.WithFields(logrus.Fields{"ID": id}).Debug("dropped state of vanished container")
+	}
+}
+
+func (s *sweeper) metrics() []metric {
+	s.mu.Lock()
+	tracked := len(s.lastSeen)
+	names := make([]string, 0, len(s.states))
+	for name := range s.states {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	entries := metric{name: "docker_stats_state_entries", help: "Entries held in per-container state, by cache.", kind: "gauge"}
+	for _, name := range names {
+		entries.samples = append(entries.samples, metricSample{labels: map[string]string{"cache": name}, value: float64(s.states[name].size())})
+	}
+	s.mu.Unlock()
+
+	return []metric{
+		gauge("docker_stats_tracked_containers", "Containers the agent currently keeps state for.", float64(tracked)),
+		entries,
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import "github.com/docker/docker/api/types"
+
+// throttleReadings adds the CFS throttling counters of containers with a CPU
+// limit, and CPU_THROTTLED_PCT, the share of the enforcement periods since
+// the previous reading in which the container was throttled. A container
+// starved by its limit shows a low CPU_PCT but a high CPU_THROTTLED_PCT.
+func throttleReadings(stats *types.StatsJSON, readings map[string]float64, values map[string]interface{}) {
+	cur, pre := stats.CPUStats.ThrottlingData, stats.PreCPUStats.ThrottlingData
+	if cur.Periods == 0 {
+		return
+	}
+	readings["CPU_PERIODS"] = float64(cur.Periods)
+	readings["CPU_THROTTLED_PERIODS"] = float64(cur.ThrottledPeriods)
+	readings["CPU_THROTTLED_NS"] = float64(cur.ThrottledTime)
+	values["CPU_THROTTLED_PERIODS"] = cur.ThrottledPeriods
+	values["CPU_THROTTLED_SECONDS"] = formatDecimal(float64(cur.ThrottledTime) / 1e9)
+
+	if pre.Periods == 0 || cur.Periods <= pre.Periods || cur.ThrottledPeriods < pre.ThrottledPeriods {
+		return
+	}
+	pct := 100.0 * float64(cur.ThrottledPeriods-pre.ThrottledPeriods) / float64(cur.Periods-pre.Periods)
+	readings["CPU_THROTTLED_PCT"] = pct
+	values["CPU_THROTTLED_PCT"] = formatDecimal(pct)
+}
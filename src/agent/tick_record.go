@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Record modes of an output: one record per container, or one document per
+// tick grouping every container with a summary.
+const (
+	recordsContainer = "container"
+	recordsTick      = "tick"
+)
+
+// outputRecords returns the record mode selected by <output>_records.
+func outputRecords(output string) (string, error) {
+	switch mode := os.Getenv(output + "_records"); mode {
+	case "":
+		return recordsContainer, nil
+	case recordsContainer, recordsTick:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown %s_records %q", output, mode)
+	}
+}
+
+// tickRecord combines the samples of a tick into one document, serialized
+// with the given schema.
+func tickRecord(samples []sample, schema string) logrus.Fields {
+	var cpu, mem float64
+	var containers int
+	for _, s := range samples {
+		// Volume and other records aren't containers and don't count.
+		if _, ok := s.Fields["Record"]; ok {
+			continue
+		}
+		containers++
+		cpu += s.Values["CPU_PCT"]
+		mem += s.Values["MEM_BYTES"]
+	}
+	summary := map[string]interface{}{
+		"Time":       time.Now().UTC().Format(time.RFC3339),
+		"Containers": containers,
+	}
+	if schema == schemaRaw {
+		summary["CPU_PCT"] = cpu
+		summary["MEM_BYTES"] = mem
+	} else {
+		summary["CPU_PCT"] = formatDecimal(cpu)
+		summary["MEM_MB"] = formatMB(uint64(mem))
+	}
+	return logrus.Fields{"Tick": summary, "Records": serialize(samples, schema)}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+// outputTLS returns the TLS configuration of a socket output, or nil when
+// <output>_tls is off. <output>_tls_ca names a PEM bundle to trust instead of
+// the system roots, and <output>_tls_insecure skips verification altogether.
+func outputTLS(output, serverName string) (*tls.Config, error) {
+	on, err := boolSetting(output + "_tls")
+	if err != nil || !on {
+		return nil, err
+	}
+	config := &tls.Config{ServerName: serverName}
+	if config.InsecureSkipVerify, err = boolSetting(output + "_tls_insecure"); err != nil {
+		return nil, err
+	}
+	if path := os.Getenv(output + "_tls_ca"); path != "" {
+		pem, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s_tls_ca: %v", output, err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New(output + "_tls_ca has no PEM certificates")
+		}
+	}
+	return config, nil
+}
+
+// boolSetting parses an optional boolean setting, false when unset.
+func boolSetting(name string) (bool, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return b, nil
+}
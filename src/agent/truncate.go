@@ -0,0 +1,70 @@
+package main
+
+import (
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+const truncationMarker = "..."
+
+// truncateFields returns a copy of fields with every string longer than max
+// bytes cut at a rune boundary and marked with an ellipsis, including label
+// values and names nested in maps and lists. Stats are numbers, even when
+// formatted as strings, and are kept as is like other values.
+func truncateFields(fields logrus.Fields, max int) logrus.Fields {
+	out := make(logrus.Fields, len(fields))
+	for k, v := range fields {
+		if k == "Stats" || k == "Tick" {
+			out[k] = v
+			continue
+		}
+		out[k] = truncateValue(v, max)
+	}
+	return out
+}
+
+func truncateValue(v interface{}, max int) interface{} {
+	switch v := v.(type) {
+	case string:
+		return truncateString(v, max)
+	case []string:
+		out := make([]string, len(v))
+		for i, s := range v {
+			out[i] = truncateString(s, max)
+		}
+		return out
+	case map[string]string:
+		out := make(map[string]string, len(v))
+		for k, s := range v {
+			out[k] = truncateString(s, max)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			out[k] = truncateValue(e, max)
+		}
+		return out
+	case logrus.Fields:
+		return truncateFields(v, max)
+	case []logrus.Fields:
+		out := make([]logrus.Fields, len(v))
+		for i, f := range v {
+			out[i] = truncateFields(f, max)
+		}
+		return out
+	}
+	return v
+}
+
+func truncateString(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + truncationMarker
+}
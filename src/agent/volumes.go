@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	collectVolumes bool
+	// volumesInterval is how often volumes are measured. The daemon walks
+	// every volume, layer and image to answer, so it's far slower than a
+	// stats tick.
+	volumesInterval = 5 * time.Minute
+)
+
+func init() {
+	registerMetrics(volumeMetrics)
+}
+
+// volumeRecords returns a record per named volume with its size, the number
+// of containers referencing it and the names of the ones mounting it.
+func volumeRecords(ctx context.Context) ([]sample, error) {
+	du, err := dockerClient.DiskUsage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mounts := map[string][]string{}
+	for _, c := range du.Containers {
+		name := c.ID
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		for _, m := range c.Mounts {
+			if m.Type == "volume" && m.Name != "" {
+				mounts[m.Name] = append(mounts[m.Name], name)
+			}
+		}
+	}
+
+	records := make([]sample, 0, len(du.Volumes))
+	for _, v := range du.Volumes {
+		containers := mounts[v.Name]
+		if containers == nil {
+			containers = []string{}
+		}
+		sort.Strings(containers)
+		values := map[string]interface{}{}
+		readings := map[string]float64{}
+		// The daemon reports -1 for what it couldn't measure.
+		if v.UsageData != nil && v.UsageData.Size >= 0 {
+			readings["VOLUME_SIZE_BYTES"] = float64(v.UsageData.Size)
+			values["VOLUME_SIZE_MB"] = formatMB(uint64(v.UsageData.Size))
+		}
+		if v.UsageData != nil && v.UsageData.RefCount >= 0 {
+			readings["VOLUME_REF_COUNT"] = float64(v.UsageData.RefCount)
+			values["VOLUME_REF_COUNT"] = v.UsageData.RefCount
+		}
+		records = append(records, sample{
+			ID:     v.Name,
+			Labels: v.Labels,
+			Fields: logrus.Fields{
+				"Record":     recordVolume,
+				"Volume":     v.Name,
+				"Driver":     v.Driver,
+				"Scope":      v.Scope,
+				"Mountpoint": v.Mountpoint,
+				"Containers": containers,
+				"Stats":      values,
+			},
+			Values: readings,
+		})
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records, nil
+}
+
+var lastVolumes = &recordList{}
+
+// volumeMetrics exposes the last volume records, labeled by volume and
+// driver.
+func volumeMetrics() []metric {
+	if !collectVolumes {
+		return nil
+	}
+	size := metric{name: "docker_volume_size_bytes", help: "Size of a named volume.", kind: "gauge"}
+	refs := metric{name: "docker_volume_containers", help: "Containers referencing a named volume.", kind: "gauge"}
+	for _, r := range lastVolumes.get() {
+		driver, _ := r.Fields["Driver"].(string)
+		labels := map[string]string{"volume": r.ID, "driver": driver}
+		if v, ok := r.Values["VOLUME_SIZE_BYTES"]; ok {
+			size.samples = append(size.samples, metricSample{labels: labels, value: v})
+		}
+		if v, ok := r.Values["VOLUME_REF_COUNT"]; ok {
+			refs.samples = append(refs.samples, metricSample{labels: labels, value: v})
+		}
+	}
+	return []metric{size, refs}
+}